@@ -0,0 +1,72 @@
+package apikey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Keys maps API keys to the tenant they authenticate. Only sha256 digests of
+// the keys are configured, so raw keys never appear in env or config files.
+type Keys struct {
+	tenants map[[sha256.Size]byte]string
+}
+
+// Parse reads "tenant=sha256hex;..." where sha256hex is the hex digest of
+// the raw key (printf %s "$KEY" | sha256sum). A tenant may have several keys.
+func Parse(spec string) (*Keys, error) {
+	k := &Keys{tenants: map[[sha256.Size]byte]string{}}
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		tenant, digest, ok := strings.Cut(item, "=")
+		tenant = strings.TrimSpace(tenant)
+		raw, err := hex.DecodeString(strings.TrimSpace(digest))
+		if !ok || tenant == "" || err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("api key %q: want tenant=sha256hex", tenant)
+		}
+		k.tenants[[sha256.Size]byte(raw)] = tenant
+	}
+	return k, nil
+}
+
+// Len is the number of configured keys.
+func (k *Keys) Len() int {
+	if k == nil {
+		return 0
+	}
+	return len(k.tenants)
+}
+
+// Tenant returns the tenant authenticated by the request's X-API-Key. It is
+// false for a missing or unknown key, and always false on a nil Keys.
+func (k *Keys) Tenant(r *http.Request) (string, bool) {
+	if k == nil {
+		return "", false
+	}
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		return "", false
+	}
+	tenant, ok := k.tenants[sha256.Sum256([]byte(key))]
+	return tenant, ok
+}
+
+// Require serves next only to requests authenticated by a configured key and
+// answers 401 to everyone else. With no keys configured nothing passes, so
+// routes behind it fail closed.
+func (k *Keys) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := k.Tenant(r); !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"missing or invalid API key"}` + "\n"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
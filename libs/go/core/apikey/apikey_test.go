@@ -0,0 +1,71 @@
+package apikey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func digest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestTenant(t *testing.T) {
+	k, err := Parse("acme=" + digest("k1") + "; globex=" + digest("k2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{"k1": "acme", "k2": "globex", "k3": "", "": ""} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		got, ok := k.Tenant(r)
+		if got != want || ok != (want != "") {
+			t.Errorf("key %q: got %q %v, want %q", key, got, ok, want)
+		}
+	}
+	var none *Keys
+	if _, ok := none.Tenant(httptest.NewRequest("GET", "/", nil)); ok || none.Len() != 0 {
+		t.Fatal("nil Keys authenticated a request")
+	}
+}
+
+func TestParseRejectsRawKeys(t *testing.T) {
+	for _, spec := range []string{"acme=secret", "=" + digest("k"), "acme"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) accepted", spec)
+		}
+	}
+}
+
+func TestRequire(t *testing.T) {
+	k, err := Parse("acme=" + digest("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	for _, c := range []struct {
+		keys *Keys
+		key  string
+		want int
+	}{
+		{k, "k1", http.StatusNoContent},
+		{k, "k2", http.StatusUnauthorized},
+		{k, "", http.StatusUnauthorized},
+		{nil, "k1", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest("POST", "/", nil)
+		if c.key != "" {
+			r.Header.Set("X-API-Key", c.key)
+		}
+		w := httptest.NewRecorder()
+		c.keys.Require(ok).ServeHTTP(w, r)
+		if w.Code != c.want {
+			t.Errorf("key %q: status %d, want %d", c.key, w.Code, c.want)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// Command swarmbench drives HTTP load profiles against the evaluation and scan
+// hot paths and compares latency percentiles with a stored baseline.
+//
+//	swarmbench -profile evaluate -duration 30s -rps 500 -baseline bench/baseline.json
+//	swarmbench -config profiles.json -save-baseline bench/baseline.json
+//
+// It exits non-zero when any profile's p99 (or error rate) regresses by more
+// than -threshold percent, so it can gate CI performance checks.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Profile is one load target.
+type Profile struct {
+	Name        string            `json:"name"`
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	Body        json.RawMessage   `json:"body,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	RPS         int               `json:"rps"`         // 0 = closed loop (as fast as workers allow)
+	Concurrency int               `json:"concurrency"` // worker goroutines
+	Duration    string            `json:"duration"`
+}
+
+func builtinProfiles() map[string]Profile {
+	return map[string]Profile{
+		"evaluate": {Name: "evaluate", Method: http.MethodPost, URL: getenv("POLICY_URL", "http://127.0.0.1:8181") + "/v1/evaluate",
+			Body: json.RawMessage(`{"policy":"swarm.authz","input":{"subject":"svc-a","action":"read","resource":"events"}}`)},
+		"scan": {Name: "scan", Method: http.MethodPost, URL: getenv("SIGNATURE_URL", "http://127.0.0.1:8082") + "/scan",
+			Body: json.RawMessage(`{"payload":"GET /index.php?id=1 UNION SELECT password FROM users"}`)},
+		"ingest": {Name: "ingest", Method: http.MethodPost, URL: getenv("GATEWAY_URL", "http://127.0.0.1:8080") + "/v1/ingest",
+			Body: json.RawMessage(`{"source":"swarmbench","type":"network","payload":{"src_ip":"10.0.0.1","dst_port":443}}`)},
+	}
+}
+
+func main() {
+	var (
+		profileNames = flag.String("profile", "evaluate,scan,ingest", "comma separated built-in profiles (evaluate, scan, ingest)")
+		configPath   = flag.String("config", "", "JSON file with a list of profiles (overrides -profile)")
+		duration     = flag.Duration("duration", 10*time.Second, "default run duration per profile")
+		rps          = flag.Int("rps", 0, "default target requests/sec per profile (0 = closed loop)")
+		concurrency  = flag.Int("concurrency", 16, "default workers per profile")
+		baselinePath = flag.String("baseline", "", "baseline JSON to compare against")
+		savePath     = flag.String("save-baseline", "", "write results as a new baseline")
+		threshold    = flag.Float64("threshold", 10, "allowed p99 regression in percent before failing")
+		token        = flag.String("token", os.Getenv("SWARM_BENCH_TOKEN"), "bearer token sent with every request")
+	)
+	flag.Parse()
+
+	profiles, err := loadProfiles(*configPath, *profileNames)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "swarmbench:", err)
+		os.Exit(2)
+	}
+	client := &http.Client{Timeout: 10 * time.Second, Transport: &http.Transport{MaxIdleConnsPerHost: 256}}
+	results := map[string]Result{}
+	for _, p := range profiles {
+		if p.Duration == "" {
+			p.Duration = duration.String()
+		}
+		if p.RPS == 0 {
+			p.RPS = *rps
+		}
+		if p.Concurrency == 0 {
+			p.Concurrency = *concurrency
+		}
+		if *token != "" {
+			if p.Headers == nil {
+				p.Headers = map[string]string{}
+			}
+			p.Headers["Authorization"] = "Bearer " + *token
+		}
+		res, err := run(context.Background(), client, p)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "swarmbench: %s: %v\n", p.Name, err)
+			os.Exit(2)
+		}
+		results[p.Name] = res
+		fmt.Println(res.String(p.Name))
+	}
+
+	failed := false
+	if *baselinePath != "" {
+		base, err := loadBaseline(*baselinePath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "swarmbench: baseline:", err)
+			os.Exit(2)
+		}
+		for _, line := range compare(base, results, *threshold) {
+			fmt.Println(line.msg)
+			failed = failed || line.regressed
+		}
+	}
+	if *savePath != "" {
+		b, _ := json.MarshalIndent(results, "", "  ")
+		if err := os.WriteFile(*savePath, b, 0o644); err != nil {
+			fmt.Fprintln(os.Stderr, "swarmbench: save baseline:", err)
+			os.Exit(2)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func loadProfiles(configPath, names string) ([]Profile, error) {
+	if configPath != "" {
+		b, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		var ps []Profile
+		if err := json.Unmarshal(b, &ps); err != nil {
+			return nil, fmt.Errorf("%s: %w", configPath, err)
+		}
+		return ps, nil
+	}
+	builtin := builtinProfiles()
+	var ps []Profile
+	for _, n := range strings.Split(names, ",") {
+		p, ok := builtin[strings.TrimSpace(n)]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q", n)
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// run executes one profile. With RPS > 0 request i is scheduled at
+// start + i/RPS (open loop) and its latency is measured from that scheduled
+// time, so queueing behind a slow server is counted instead of omitted;
+// otherwise each worker fires back to back. A run in which no request
+// completes is an error.
+func run(ctx context.Context, client *http.Client, p Profile) (Result, error) {
+	d, err := time.ParseDuration(p.Duration)
+	if err != nil {
+		return Result{}, fmt.Errorf("duration: %w", err)
+	}
+	if p.Method == "" {
+		p.Method = http.MethodPost
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	rec := newRecorder()
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < p.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				t0 := time.Now()
+				if p.RPS > 0 {
+					// Float math: an integer interval is zero above 1e9 RPS.
+					t0 = start.Add(time.Duration(float64(next.Add(1)-1) * float64(time.Second) / float64(p.RPS)))
+					if t0.Sub(start) >= d {
+						return
+					}
+					timer := time.NewTimer(time.Until(t0))
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return
+					case <-timer.C:
+					}
+				} else if ctx.Err() != nil {
+					return
+				}
+				status, err := doRequest(ctx, client, p)
+				if ctx.Err() != nil {
+					return // cut off by the end of the run, not a real failure
+				}
+				rec.record(time.Since(t0), err == nil && status < 400)
+			}
+		}()
+	}
+	wg.Wait()
+	res := rec.result(time.Since(start))
+	if res.Requests == 0 {
+		return res, errors.New("no request completed within the run")
+	}
+	return res, nil
+}
+
+func doRequest(ctx context.Context, client *http.Client, p Profile) (int, error) {
+	var body io.Reader
+	if len(p.Body) > 0 {
+		body = bytes.NewReader(p.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, p.Method, p.URL, body)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
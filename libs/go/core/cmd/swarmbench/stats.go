@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Result is the per-profile summary stored in baselines. Latencies are in
+// milliseconds.
+type Result struct {
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	RPS       float64 `json:"rps"`
+	P50       float64 `json:"p50_ms"`
+	P90       float64 `json:"p90_ms"`
+	P99       float64 `json:"p99_ms"`
+	Max       float64 `json:"max_ms"`
+}
+
+func (r Result) String(name string) string {
+	return fmt.Sprintf("%-10s req=%d err=%.2f%% rps=%.1f p50=%.2fms p90=%.2fms p99=%.2fms max=%.2fms",
+		name, r.Requests, r.ErrorRate*100, r.RPS, r.P50, r.P90, r.P99, r.Max)
+}
+
+type recorder struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	errors  int
+}
+
+func newRecorder() *recorder { return &recorder{samples: make([]time.Duration, 0, 4096)} }
+
+func (r *recorder) record(d time.Duration, ok bool) {
+	r.mu.Lock()
+	r.samples = append(r.samples, d)
+	if !ok {
+		r.errors++
+	}
+	r.mu.Unlock()
+}
+
+func (r *recorder) result(elapsed time.Duration) Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := Result{Requests: len(r.samples), Errors: r.errors}
+	if len(r.samples) == 0 {
+		return res
+	}
+	sort.Slice(r.samples, func(i, j int) bool { return r.samples[i] < r.samples[j] })
+	res.ErrorRate = float64(r.errors) / float64(len(r.samples))
+	res.RPS = float64(len(r.samples)) / elapsed.Seconds()
+	res.P50 = ms(percentile(r.samples, 0.50))
+	res.P90 = ms(percentile(r.samples, 0.90))
+	res.P99 = ms(percentile(r.samples, 0.99))
+	res.Max = ms(r.samples[len(r.samples)-1])
+	return res
+}
+
+// percentile uses the nearest-rank method on sorted samples.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	idx := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(0, min(idx, len(sorted)-1))]
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+func loadBaseline(path string) (map[string]Result, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var base map[string]Result
+	return base, json.Unmarshal(b, &base)
+}
+
+type comparison struct {
+	msg       string
+	regressed bool
+}
+
+// compare flags profiles whose p99 grew by more than thresholdPct percent,
+// whose error rate grew by more than one percentage point, or that completed
+// no request at all.
+func compare(base, cur map[string]Result, thresholdPct float64) []comparison {
+	names := make([]string, 0, len(cur))
+	for n := range cur {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var out []comparison
+	for _, n := range names {
+		b, ok := base[n]
+		if !ok || b.P99 == 0 {
+			out = append(out, comparison{msg: fmt.Sprintf("[baseline] %s: no baseline, skipped", n)})
+			continue
+		}
+		c := cur[n]
+		delta := (c.P99 - b.P99) / b.P99 * 100
+		regressed := c.Requests == 0 || delta > thresholdPct || c.ErrorRate-b.ErrorRate > 0.01
+		status := "ok"
+		if regressed {
+			status = "REGRESSION"
+		}
+		out = append(out, comparison{
+			msg: fmt.Sprintf("[baseline] %s: p99 %.2fms -> %.2fms (%+.1f%%, limit %.0f%%) err %.2f%% -> %.2f%% %s",
+				n, b.P99, c.P99, delta, thresholdPct, b.ErrorRate*100, c.ErrorRate*100, status),
+			regressed: regressed,
+		})
+	}
+	return out
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentileNearestRank(t *testing.T) {
+	samples := make([]time.Duration, 100)
+	for i := range samples {
+		samples[i] = time.Duration(i+1) * time.Millisecond
+	}
+	for _, c := range []struct {
+		q    float64
+		want time.Duration
+	}{{0, 1 * time.Millisecond}, {0.5, 50 * time.Millisecond}, {0.9, 90 * time.Millisecond}, {0.99, 99 * time.Millisecond}, {1, 100 * time.Millisecond}} {
+		if got := percentile(samples, c.q); got != c.want {
+			t.Errorf("percentile(%v) = %v, want %v", c.q, got, c.want)
+		}
+	}
+	if got := percentile([]time.Duration{7}, 0.99); got != 7 {
+		t.Errorf("single sample: %v", got)
+	}
+}
+
+func TestCompareFlagsRegressions(t *testing.T) {
+	base := map[string]Result{
+		"evaluate": {Requests: 100, P99: 10},
+		"scan":     {Requests: 100, P99: 10, ErrorRate: 0.01},
+		"ingest":   {Requests: 100, P99: 10},
+		"zero":     {Requests: 100, P99: 10},
+	}
+	cur := map[string]Result{
+		"evaluate": {Requests: 100, P99: 10.5},                // +5%: within 10%
+		"scan":     {Requests: 100, P99: 10, ErrorRate: 0.03}, // error rate +2 points
+		"ingest":   {Requests: 100, P99: 12},                  // +20%
+		"zero":     {},                                        // nothing completed
+		"new":      {Requests: 100, P99: 10},                  // no baseline
+	}
+	want := map[string]bool{"evaluate": false, "ingest": true, "new": false, "scan": true, "zero": true}
+	out := compare(base, cur, 10)
+	if len(out) != len(want) {
+		t.Fatalf("got %d lines", len(out))
+	}
+	for _, c := range out {
+		name := strings.TrimSuffix(strings.Fields(c.msg)[1], ":")
+		if c.regressed != want[name] {
+			t.Errorf("%s: regressed=%v: %s", name, c.regressed, c.msg)
+		}
+	}
+}
+
+func TestRunMeasuresFromScheduledTime(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(40 * time.Millisecond)
+	}))
+	defer srv.Close()
+	// One worker at 100 rps against a 40ms server falls further behind with
+	// every request; that backlog must show up in the latencies.
+	res, err := run(context.Background(), srv.Client(), Profile{URL: srv.URL, RPS: 100, Concurrency: 1, Duration: "400ms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Max < 100 {
+		t.Fatalf("max latency %.1fms hides the queueing delay", res.Max)
+	}
+}
+
+func TestRunFailsWithoutRequests(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+	if _, err := run(context.Background(), srv.Client(), Profile{URL: srv.URL, Concurrency: 2, Duration: "50ms"}); err == nil {
+		t.Fatal("a run without completed requests must fail")
+	}
+	// Above 1e9 rps the per-request interval rounds to zero; it must not panic.
+	if _, err := run(context.Background(), srv.Client(), Profile{URL: srv.URL, RPS: 2_000_000_000, Concurrency: 1, Duration: "20ms"}); err == nil {
+		t.Fatal("want error for a run without completed requests")
+	}
+}
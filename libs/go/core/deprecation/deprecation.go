@@ -0,0 +1,207 @@
+package deprecation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxClientsPerRoute bounds per-client tracking; further clients are
+// counted under "other".
+const maxClientsPerRoute = 1000
+
+// Policy marks one route deprecated. Deprecated is when the route was
+// deprecated, Sunset when it stops being served (zero if not scheduled).
+type Policy struct {
+	Deprecated time.Time
+	Sunset     time.Time
+	Successor  string // replacement route or doc URL, sent as a Link header
+	// Enforce answers 410 Gone after Sunset instead of serving the route.
+	Enforce bool
+}
+
+// ClientUsage counts calls to a deprecated route by one client.
+type ClientUsage struct {
+	Client   string    `json:"client"`
+	Calls    uint64    `json:"calls"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// RouteReport lists who still calls a deprecated route.
+type RouteReport struct {
+	Route      string        `json:"route"`
+	Deprecated time.Time     `json:"deprecated"`
+	Sunset     *time.Time    `json:"sunset,omitempty"`
+	Successor  string        `json:"successor,omitempty"`
+	Calls      uint64        `json:"calls"`
+	Clients    []ClientUsage `json:"clients"`
+}
+
+type route struct {
+	policy  Policy
+	calls   uint64
+	clients map[string]*ClientUsage
+}
+
+// Registry holds deprecated routes keyed by their ServeMux pattern (e.g.
+// "GET /v1/entries") and counts their use per client.
+type Registry struct {
+	clientID func(*http.Request) string
+
+	mu     sync.Mutex
+	routes map[string]*route
+}
+
+// New returns a Registry identifying clients with clientID, or with
+// ClientID when nil.
+func New(clientID func(*http.Request) string) *Registry {
+	if clientID == nil {
+		clientID = ClientID
+	}
+	return &Registry{clientID: clientID, routes: map[string]*route{}}
+}
+
+// ClientID identifies a caller by X-Client-ID, falling back to the product
+// token of its User-Agent.
+func ClientID(r *http.Request) string {
+	if id := r.Header.Get("X-Client-ID"); id != "" {
+		return id
+	}
+	if ua, _, _ := strings.Cut(r.UserAgent(), " "); ua != "" {
+		return ua
+	}
+	return "unknown"
+}
+
+// Deprecate marks the route registered under pattern.
+func (d *Registry) Deprecate(pattern string, p Policy) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.routes[pattern] = &route{policy: p, clients: map[string]*ClientUsage{}}
+}
+
+// ParseSpec marks routes from a config string of the form
+// "GET /v1/root=2026-10-01/2027-03-31!>/v1/streams/{stream}/root;...", i.e.
+// pattern=deprecated[/sunset[!]][>successor] separated by semicolons. Dates
+// are YYYY-MM-DD in UTC; a "!" after the sunset sets Enforce.
+func (d *Registry) ParseSpec(spec string) error {
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pattern, rest, ok := strings.Cut(item, "=")
+		if !ok {
+			return fmt.Errorf("deprecation %q: missing '='", item)
+		}
+		var p Policy
+		rest, p.Successor, _ = strings.Cut(rest, ">")
+		dep, sunset, hasSunset := strings.Cut(rest, "/")
+		var err error
+		if p.Deprecated, err = time.Parse(time.DateOnly, dep); err != nil {
+			return fmt.Errorf("deprecation %q: %w", item, err)
+		}
+		if hasSunset {
+			sunset, p.Enforce = strings.CutSuffix(sunset, "!")
+			if p.Sunset, err = time.Parse(time.DateOnly, sunset); err != nil {
+				return fmt.Errorf("deprecation %q: %w", item, err)
+			}
+		}
+		d.Deprecate(strings.TrimSpace(pattern), p)
+	}
+	return nil
+}
+
+// Middleware resolves each request's pattern on mux and, for deprecated
+// routes, adds Deprecation (RFC 9745), Sunset (RFC 8594) and Link headers and
+// counts the call before serving it.
+func (d *Registry) Middleware(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if p, ok := d.record(pattern, r); ok {
+			h := w.Header()
+			h.Set("Deprecation", fmt.Sprintf("@%d", p.Deprecated.Unix()))
+			if !p.Sunset.IsZero() {
+				h.Set("Sunset", p.Sunset.UTC().Format(http.TimeFormat))
+				if p.Enforce && time.Now().After(p.Sunset) {
+					http.Error(w, "route sunset", http.StatusGone)
+					return
+				}
+			}
+			if p.Successor != "" {
+				h.Add("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", p.Successor))
+			}
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (d *Registry) record(pattern string, r *http.Request) (Policy, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rt, ok := d.routes[pattern]
+	if !ok {
+		return Policy{}, false
+	}
+	client := d.clientID(r)
+	cu, ok := rt.clients[client]
+	if !ok {
+		if len(rt.clients) >= maxClientsPerRoute {
+			client = "other"
+			cu = rt.clients[client]
+		}
+		if cu == nil {
+			cu = &ClientUsage{Client: client}
+			rt.clients[client] = cu
+		}
+	}
+	rt.calls++
+	cu.Calls++
+	cu.LastSeen = time.Now().UTC()
+	return rt.policy, true
+}
+
+// Report lists deprecated routes by pattern with their callers, busiest
+// client first.
+func (d *Registry) Report() []RouteReport {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make([]RouteReport, 0, len(d.routes))
+	for pattern, rt := range d.routes {
+		rep := RouteReport{Route: pattern, Deprecated: rt.policy.Deprecated, Successor: rt.policy.Successor, Calls: rt.calls, Clients: []ClientUsage{}}
+		if !rt.policy.Sunset.IsZero() {
+			s := rt.policy.Sunset
+			rep.Sunset = &s
+		}
+		for _, cu := range rt.clients {
+			rep.Clients = append(rep.Clients, *cu)
+		}
+		sort.Slice(rep.Clients, func(i, j int) bool { return rep.Clients[i].Calls > rep.Clients[j].Calls })
+		out = append(out, rep)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Route < out[j].Route })
+	return out
+}
+
+// ReportHandler serves Report as JSON.
+func (d *Registry) ReportHandler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"routes": d.Report()})
+}
+
+// WriteMetrics renders swarm_deprecated_route_requests_total{route,client}
+// in Prometheus text format.
+func (d *Registry) WriteMetrics(w io.Writer) {
+	const name = "swarm_deprecated_route_requests_total"
+	fmt.Fprintf(w, "# HELP %s Calls to deprecated routes per client.\n# TYPE %s counter\n", name, name)
+	for _, rep := range d.Report() {
+		for _, cu := range rep.Clients {
+			fmt.Fprintf(w, "%s{route=%q,client=%q} %d\n", name, rep.Route, cu.Client, cu.Calls)
+		}
+	}
+}
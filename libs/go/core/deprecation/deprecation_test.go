@@ -0,0 +1,105 @@
+package deprecation
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSpec(t *testing.T) {
+	d := New(nil)
+	err := d.ParseSpec(" GET /v1/root=2026-10-01/2027-03-31!>/v1/streams/{stream}/root ; GET /v1/old=2026-01-02 ; GET /v1/soft=2026-01-02/2026-06-30 ;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Policy{
+		"GET /v1/root": {Deprecated: date("2026-10-01"), Sunset: date("2027-03-31"), Successor: "/v1/streams/{stream}/root", Enforce: true},
+		"GET /v1/old":  {Deprecated: date("2026-01-02")},
+		"GET /v1/soft": {Deprecated: date("2026-01-02"), Sunset: date("2026-06-30")},
+	}
+	if len(d.routes) != len(want) {
+		t.Fatalf("routes %v", d.routes)
+	}
+	for pattern, p := range want {
+		if rt, ok := d.routes[pattern]; !ok || rt.policy != p {
+			t.Errorf("%s: got %+v, want %+v", pattern, d.routes[pattern], p)
+		}
+	}
+	for _, bad := range []string{"GET /v1/x", "GET /v1/x=tomorrow", "GET /v1/x=2026-01-02/never", "GET /v1/x=2026-01-02!"} {
+		if err := New(nil).ParseSpec(bad); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
+
+func date(s string) time.Time {
+	t, _ := time.Parse(time.DateOnly, s)
+	return t
+}
+
+func TestMiddlewareHeadersAndEnforcement(t *testing.T) {
+	mux := http.NewServeMux()
+	for _, p := range []string{"GET /v1/old", "GET /v1/gone", "GET /v1/soft", "GET /v1/current"} {
+		mux.HandleFunc(p, func(w http.ResponseWriter, _ *http.Request) {})
+	}
+	d := New(nil)
+	if err := d.ParseSpec("GET /v1/old=2026-01-02/2099-01-01>/v2/new;GET /v1/gone=2000-01-01/2000-06-30!;GET /v1/soft=2000-01-01/2000-06-30"); err != nil {
+		t.Fatal(err)
+	}
+	h := d.Middleware(mux)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("User-Agent", "agent/1.2 (linux)")
+		h.ServeHTTP(w, req)
+		return w
+	}
+	w := get("/v1/old")
+	if w.Code != http.StatusOK ||
+		w.Header().Get("Deprecation") != "@"+strconv.FormatInt(date("2026-01-02").Unix(), 10) ||
+		w.Header().Get("Sunset") != "Thu, 01 Jan 2099 00:00:00 GMT" ||
+		w.Header().Get("Link") != `</v2/new>; rel="successor-version"` {
+		t.Fatalf("deprecated route: %d %v", w.Code, w.Header())
+	}
+	if w := get("/v1/gone"); w.Code != http.StatusGone {
+		t.Fatalf("enforced sunset: %d", w.Code)
+	}
+	if w := get("/v1/soft"); w.Code != http.StatusOK || w.Header().Get("Sunset") == "" {
+		t.Fatalf("unenforced sunset: %d %v", w.Code, w.Header())
+	}
+	if w := get("/v1/current"); w.Header().Get("Deprecation") != "" {
+		t.Fatalf("current route marked deprecated: %v", w.Header())
+	}
+	rep := d.Report()
+	if len(rep) != 3 || rep[1].Route != "GET /v1/old" || rep[1].Calls != 1 || rep[1].Clients[0].Client != "agent/1.2" {
+		t.Fatalf("report %+v", rep)
+	}
+	var buf strings.Builder
+	d.WriteMetrics(&buf)
+	if !strings.Contains(buf.String(), `swarm_deprecated_route_requests_total{route="GET /v1/gone",client="agent/1.2"} 1`) {
+		t.Fatalf("metrics:\n%s", buf.String())
+	}
+}
+
+func TestClientTrackingIsCapped(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/old", func(w http.ResponseWriter, _ *http.Request) {})
+	d := New(nil)
+	d.Deprecate("GET /v1/old", Policy{Deprecated: date("2026-01-02")})
+	h := d.Middleware(mux)
+	for i := 0; i < maxClientsPerRoute+5; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/v1/old", nil)
+		req.Header.Set("X-Client-ID", "client-"+strconv.Itoa(i))
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	rep := d.Report()[0]
+	if rep.Calls != maxClientsPerRoute+5 || len(rep.Clients) != maxClientsPerRoute+1 {
+		t.Fatalf("calls %d, clients %d", rep.Calls, len(rep.Clients))
+	}
+	if top := rep.Clients[0]; top.Client != "other" || top.Calls != 5 {
+		t.Fatalf("overflow client %+v", top)
+	}
+}
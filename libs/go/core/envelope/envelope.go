@@ -0,0 +1,347 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Prefix marks an encrypted value: enc:v1:<tenant b64url>:<dek version>:<nonce|ciphertext b64url>.
+const Prefix = "enc:v1:"
+
+var (
+	ErrUnknownKey = errors.New("envelope: unknown key")
+	ErrMalformed  = errors.New("envelope: malformed ciphertext")
+)
+
+// KEK (key-encryption key) wraps per-tenant data keys. The local
+// implementation uses a master key from config; a KMS client can implement
+// the same interface.
+type KEK interface {
+	ID() string
+	Wrap(dek []byte) ([]byte, error)
+	Unwrap(wrapped []byte) ([]byte, error)
+}
+
+type localKEK struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// NewLocalKEK builds an AES-256-GCM KEK from a 32 byte master key.
+func NewLocalKEK(id string, key []byte) (KEK, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, fmt.Errorf("kek %s: %w", id, err)
+	}
+	return &localKEK{id: id, aead: aead}, nil
+}
+
+func (k *localKEK) ID() string { return k.id }
+
+func (k *localKEK) Wrap(dek []byte) ([]byte, error) { return seal(k.aead, dek, []byte(k.id)) }
+
+func (k *localKEK) Unwrap(wrapped []byte) ([]byte, error) { return open(k.aead, wrapped, []byte(k.id)) }
+
+// ParseMasterKeys parses "id1:base64key,id2:base64key". The first key is
+// active for wrapping; the others stay available to unwrap keys created
+// before a master key rotation.
+func ParseMasterKeys(spec string) (active KEK, all map[string]KEK, err error) {
+	all = map[string]KEK{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, b64, ok := strings.Cut(part, ":")
+		if !ok || id == "" {
+			return nil, nil, fmt.Errorf("master key %q: want id:base64key", part)
+		}
+		key, err := base64.StdEncoding.DecodeString(b64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("master key %s: %w", id, err)
+		}
+		kek, err := NewLocalKEK(id, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if active == nil {
+			active = kek
+		}
+		all[id] = kek
+	}
+	if active == nil {
+		return nil, nil, errors.New("no master keys configured")
+	}
+	return active, all, nil
+}
+
+// wrappedKey is one version of a tenant data key as persisted.
+type wrappedKey struct {
+	Tenant    string    `json:"tenant"`
+	Version   int       `json:"version"`
+	KEKID     string    `json:"kek_id"`
+	Wrapped   []byte    `json:"wrapped"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Keyring holds per-tenant data keys wrapped by a KEK and persisted to a JSON
+// file. Values are encrypted with the newest key version of their tenant;
+// older versions stay readable until the data is rewritten.
+type Keyring struct {
+	mu     sync.Mutex
+	path   string
+	active KEK
+	keks   map[string]KEK
+	keys   map[string][]*wrappedKey // tenant -> versions, ascending
+	aeads  map[string]cipher.AEAD   // "tenant/version" -> unwrapped key
+}
+
+func OpenKeyring(path string, active KEK, keks map[string]KEK) (*Keyring, error) {
+	k := &Keyring{path: path, active: active, keks: keks, keys: map[string][]*wrappedKey{}, aeads: map[string]cipher.AEAD{}}
+	if k.keks == nil {
+		k.keks = map[string]KEK{active.ID(): active}
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*wrappedKey
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	for _, wk := range list {
+		k.keys[wk.Tenant] = append(k.keys[wk.Tenant], wk)
+	}
+	return k, nil
+}
+
+// Open parses masterKeys (see ParseMasterKeys) and opens the keyring at path.
+func Open(path, masterKeys string) (*Keyring, error) {
+	active, all, err := ParseMasterKeys(masterKeys)
+	if err != nil {
+		return nil, err
+	}
+	return OpenKeyring(path, active, all)
+}
+
+// Encrypt seals plaintext for tenant. aad binds the ciphertext to its context
+// (e.g. a record ID) so it cannot be moved to another record.
+func (k *Keyring) Encrypt(tenant string, plaintext, aad []byte) (string, error) {
+	k.mu.Lock()
+	versions := k.keys[tenant]
+	if len(versions) == 0 {
+		if _, err := k.rotateLocked(tenant); err != nil {
+			k.mu.Unlock()
+			return "", err
+		}
+		versions = k.keys[tenant]
+	}
+	version := versions[len(versions)-1].Version
+	aead, err := k.aeadLocked(tenant, version)
+	k.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	ct, err := seal(aead, plaintext, bindAAD(tenant, version, aad))
+	if err != nil {
+		return "", err
+	}
+	return Prefix + base64.RawURLEncoding.EncodeToString([]byte(tenant)) + ":" + strconv.Itoa(version) + ":" + base64.RawURLEncoding.EncodeToString(ct), nil
+}
+
+// Decrypt opens a value produced by Encrypt with the same aad.
+func (k *Keyring) Decrypt(value string, aad []byte) ([]byte, error) {
+	if !IsEncrypted(value) {
+		return nil, ErrMalformed
+	}
+	parts := strings.Split(strings.TrimPrefix(value, Prefix), ":")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	tenant, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	version, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	ct, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	k.mu.Lock()
+	aead, err := k.aeadLocked(string(tenant), version)
+	k.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return open(aead, ct, bindAAD(string(tenant), version, aad))
+}
+
+// IsEncrypted reports whether value carries the envelope prefix.
+func IsEncrypted(value string) bool { return strings.HasPrefix(value, Prefix) }
+
+// Rotate creates a new data key version for tenant and returns it. New
+// writes use it; existing ciphertexts keep decrypting with their version.
+func (k *Keyring) Rotate(tenant string) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.rotateLocked(tenant)
+}
+
+// Rewrap re-wraps every data key with the active KEK, completing a master key
+// rotation; afterwards the old master key can be removed from config.
+func (k *Keyring) Rewrap() (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	n := 0
+	for _, versions := range k.keys {
+		for _, wk := range versions {
+			if wk.KEKID == k.active.ID() {
+				continue
+			}
+			dek, err := k.unwrapLocked(wk)
+			if err != nil {
+				return n, err
+			}
+			wrapped, err := k.active.Wrap(dek)
+			if err != nil {
+				return n, err
+			}
+			wk.KEKID, wk.Wrapped = k.active.ID(), wrapped
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, k.persistLocked()
+}
+
+func (k *Keyring) rotateLocked(tenant string) (int, error) {
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return 0, err
+	}
+	wrapped, err := k.active.Wrap(dek)
+	if err != nil {
+		return 0, err
+	}
+	version := 1
+	if versions := k.keys[tenant]; len(versions) > 0 {
+		version = versions[len(versions)-1].Version + 1
+	}
+	k.keys[tenant] = append(k.keys[tenant], &wrappedKey{Tenant: tenant, Version: version, KEKID: k.active.ID(), Wrapped: wrapped, CreatedAt: time.Now().UTC()})
+	if err := k.persistLocked(); err != nil {
+		k.keys[tenant] = k.keys[tenant][:len(k.keys[tenant])-1]
+		return 0, err
+	}
+	return version, nil
+}
+
+func (k *Keyring) aeadLocked(tenant string, version int) (cipher.AEAD, error) {
+	id := tenant + "/" + strconv.Itoa(version)
+	if aead, ok := k.aeads[id]; ok {
+		return aead, nil
+	}
+	for _, wk := range k.keys[tenant] {
+		if wk.Version != version {
+			continue
+		}
+		dek, err := k.unwrapLocked(wk)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newAEAD(dek)
+		if err != nil {
+			return nil, err
+		}
+		k.aeads[id] = aead
+		return aead, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+}
+
+func (k *Keyring) unwrapLocked(wk *wrappedKey) ([]byte, error) {
+	kek, ok := k.keks[wk.KEKID]
+	if !ok {
+		return nil, fmt.Errorf("%w: kek %s", ErrUnknownKey, wk.KEKID)
+	}
+	return kek.Unwrap(wk.Wrapped)
+}
+
+// Export returns the keyring in its file format for backups. Data keys stay
+// wrapped, so the export is useless without the master keys.
+func (k *Keyring) Export() ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.marshalLocked()
+}
+
+func (k *Keyring) marshalLocked() ([]byte, error) {
+	list := []*wrappedKey{}
+	for _, versions := range k.keys {
+		list = append(list, versions...)
+	}
+	return json.MarshalIndent(list, "", "  ")
+}
+
+func (k *Keyring) persistLocked() error {
+	data, err := k.marshalLocked()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(k.path), 0o700); err != nil {
+		return err
+	}
+	tmp := k.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, k.path)
+}
+
+func bindAAD(tenant string, version int, aad []byte) []byte {
+	return append([]byte(tenant+"\x00"+strconv.Itoa(version)+"\x00"), aad...)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, ct, aad []byte) ([]byte, error) {
+	if len(ct) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	return aead.Open(nil, ct[:aead.NonceSize()], ct[aead.NonceSize():], aad)
+}
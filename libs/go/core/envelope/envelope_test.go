@@ -0,0 +1,187 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func masterKey(t *testing.T, id string) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return id + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+func TestEncryptDecryptRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	master := masterKey(t, "m1")
+	k, err := Open(path, master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := k.Encrypt("acme", []byte("secret"), []byte("ind-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(ct) || strings.Contains(ct, "secret") {
+		t.Fatalf("ciphertext %q", ct)
+	}
+	if pt, err := k.Decrypt(ct, []byte("ind-1")); err != nil || string(pt) != "secret" {
+		t.Fatalf("decrypt: %q %v", pt, err)
+	}
+	// Data keys are persisted wrapped, so a reopened keyring still decrypts.
+	k2, err := Open(path, master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pt, err := k2.Decrypt(ct, []byte("ind-1")); err != nil || string(pt) != "secret" {
+		t.Fatalf("decrypt after reopen: %q %v", pt, err)
+	}
+	// A different master key under the same id cannot unwrap the data key.
+	k3, err := Open(path, masterKey(t, "m1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k3.Decrypt(ct, []byte("ind-1")); err == nil {
+		t.Fatal("decrypted with a different master key")
+	}
+}
+
+func TestDecryptRejectsWrongContext(t *testing.T) {
+	k, err := Open(filepath.Join(t.TempDir(), "keys.json"), masterKey(t, "m1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := k.Encrypt("acme", []byte("secret"), []byte("ind-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Decrypt(ct, []byte("ind-2")); err == nil {
+		t.Fatal("decrypted with another record's aad")
+	}
+	// Moving the ciphertext to another tenant changes the bound tenant.
+	other := Prefix + base64.RawURLEncoding.EncodeToString([]byte("globex")) + ct[strings.Index(ct, ":1:"):]
+	if _, err := k.Encrypt("globex", []byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Decrypt(other, []byte("ind-1")); err == nil {
+		t.Fatal("decrypted under another tenant")
+	}
+
+	raw, _ := base64.RawURLEncoding.DecodeString(ct[strings.LastIndex(ct, ":")+1:])
+	raw[len(raw)-1] ^= 0xff
+	tampered := ct[:strings.LastIndex(ct, ":")+1] + base64.RawURLEncoding.EncodeToString(raw)
+	if _, err := k.Decrypt(tampered, []byte("ind-1")); err == nil {
+		t.Fatal("decrypted tampered ciphertext")
+	}
+	for _, bad := range []string{"plain", Prefix + "x", Prefix + "YWNtZQ:one:AAAA", Prefix + "YWNtZQ:1:!!", Prefix + "YWNtZQ:1:AA"} {
+		if _, err := k.Decrypt(bad, nil); !errors.Is(err, ErrMalformed) {
+			t.Errorf("%q: %v, want ErrMalformed", bad, err)
+		}
+	}
+}
+
+func TestDecryptUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	m1 := masterKey(t, "m1")
+	k, err := Open(path, m1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := k.Encrypt("acme", []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Decrypt(strings.Replace(ct, ":1:", ":2:", 1), nil); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unknown DEK version: %v", err)
+	}
+	if _, err := k.Decrypt(Prefix+base64.RawURLEncoding.EncodeToString([]byte("globex"))+ct[strings.Index(ct, ":1:"):], nil); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unknown tenant: %v", err)
+	}
+	// Without the master key that wrapped the DEK nothing can be unwrapped.
+	k2, err := Open(path, masterKey(t, "m2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k2.Decrypt(ct, nil); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("unknown KEK: %v", err)
+	}
+}
+
+func TestRotateKeepsOldVersionsReadable(t *testing.T) {
+	k, err := Open(filepath.Join(t.TempDir(), "keys.json"), masterKey(t, "m1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := k.Encrypt("acme", []byte("v1 data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := k.Rotate("acme"); err != nil || v != 2 {
+		t.Fatalf("rotate: %d %v", v, err)
+	}
+	cur, err := k.Encrypt("acme", []byte("v2 data"), nil)
+	if err != nil || !strings.Contains(cur, ":2:") {
+		t.Fatalf("encrypt after rotate: %q %v", cur, err)
+	}
+	for ct, want := range map[string]string{old: "v1 data", cur: "v2 data"} {
+		if pt, err := k.Decrypt(ct, nil); err != nil || string(pt) != want {
+			t.Fatalf("decrypt: %q %v, want %q", pt, err, want)
+		}
+	}
+}
+
+func TestRewrapRetiresOldMasterKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	m1, m2 := masterKey(t, "m1"), masterKey(t, "m2")
+	k, err := Open(path, m1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := k.Encrypt("acme", []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Rotate("globex"); err != nil {
+		t.Fatal(err)
+	}
+	// m2 becomes active; m1 is kept only to unwrap.
+	k, err = Open(path, m2+","+m1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := k.Rewrap(); err != nil || n != 2 {
+		t.Fatalf("rewrap: %d %v", n, err)
+	}
+	if n, err := k.Rewrap(); err != nil || n != 0 {
+		t.Fatalf("second rewrap: %d %v", n, err)
+	}
+	k, err = Open(path, m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pt, err := k.Decrypt(ct, nil); err != nil || !bytes.Equal(pt, []byte("secret")) {
+		t.Fatalf("decrypt with only the new master key: %q %v", pt, err)
+	}
+}
+
+func TestParseMasterKeys(t *testing.T) {
+	m1, m2 := masterKey(t, "m1"), masterKey(t, "m2")
+	active, all, err := ParseMasterKeys(" " + m1 + " , " + m2 + ",")
+	if err != nil || active.ID() != "m1" || len(all) != 2 {
+		t.Fatalf("%v %v %v", active, all, err)
+	}
+	short := base64.StdEncoding.EncodeToString(make([]byte, 16))
+	for _, spec := range []string{"", " , ", "m1", ":" + short, "m1:not base64!", "m1:" + short} {
+		if _, _, err := ParseMasterKeys(spec); err == nil {
+			t.Errorf("ParseMasterKeys(%q) accepted", spec)
+		}
+	}
+}
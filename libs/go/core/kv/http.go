@@ -0,0 +1,158 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxValueBytes caps a single value accepted over HTTP.
+const maxValueBytes = 1 << 20
+
+// Register mounts the swarm-kv HTTP API for m on mux:
+//
+//	PUT    /v1/kv/{ns}/{key}?ttl=30s   body is the raw value; If-None-Match: * for SetNX
+//	GET    /v1/kv/{ns}/{key}
+//	DELETE /v1/kv/{ns}/{key}
+//	GET    /v1/kv                      per-namespace stats
+func Register(mux *http.ServeMux, m *Memory) {
+	mux.HandleFunc("PUT /v1/kv/{ns}/{key}", func(w http.ResponseWriter, r *http.Request) {
+		ttl, err := time.ParseDuration(r.URL.Query().Get("ttl"))
+		if err != nil {
+			http.Error(w, "ttl query parameter required", http.StatusBadRequest)
+			return
+		}
+		value, err := io.ReadAll(io.LimitReader(r.Body, maxValueBytes+1))
+		if err != nil || len(value) > maxValueBytes {
+			http.Error(w, "value too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		set := m.Set
+		if r.Header.Get("If-None-Match") == "*" {
+			set = m.SetNX
+		}
+		if err := set(r.Context(), r.PathValue("ns"), r.PathValue("key"), value, ttl); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /v1/kv/{ns}/{key}", func(w http.ResponseWriter, r *http.Request) {
+		value, err := m.Get(r.Context(), r.PathValue("ns"), r.PathValue("key"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(value)
+	})
+	mux.HandleFunc("DELETE /v1/kv/{ns}/{key}", func(w http.ResponseWriter, r *http.Request) {
+		if err := m.Delete(r.Context(), r.PathValue("ns"), r.PathValue("key")); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET /v1/kv", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"namespaces": m.Stats()})
+	})
+}
+
+var statusByErr = []struct {
+	err    error
+	status int
+}{
+	{ErrNotFound, http.StatusNotFound},
+	{ErrExists, http.StatusPreconditionFailed},
+	{ErrQuota, http.StatusInsufficientStorage},
+	{ErrTTL, http.StatusBadRequest},
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	for _, s := range statusByErr {
+		if errors.Is(err, s.err) {
+			http.Error(w, err.Error(), s.status)
+			return
+		}
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// Client is a Store backed by a swarm-kv service.
+type Client struct {
+	base string
+	http *http.Client
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{base: baseURL, http: &http.Client{Timeout: 2 * time.Second}}
+}
+
+func (c *Client) url(ns, key string) string {
+	return c.base + "/v1/kv/" + url.PathEscape(ns) + "/" + url.PathEscape(key)
+}
+
+func (c *Client) Get(ctx context.Context, ns, key string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.url(ns, key), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (c *Client) Set(ctx context.Context, ns, key string, value []byte, ttl time.Duration) error {
+	return c.put(ctx, ns, key, value, ttl, nil)
+}
+
+func (c *Client) SetNX(ctx context.Context, ns, key string, value []byte, ttl time.Duration) error {
+	return c.put(ctx, ns, key, value, ttl, http.Header{"If-None-Match": {"*"}})
+}
+
+func (c *Client) put(ctx context.Context, ns, key string, value []byte, ttl time.Duration, hdr http.Header) error {
+	resp, err := c.do(ctx, http.MethodPut, c.url(ns, key)+"?ttl="+ttl.String(), value, hdr)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *Client) Delete(ctx context.Context, ns, key string) error {
+	resp, err := c.do(ctx, http.MethodDelete, c.url(ns, key), nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// do maps error statuses back to the package errors.
+func (c *Client) do(ctx context.Context, method, u string, body []byte, hdr http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	resp.Body.Close()
+	for _, s := range statusByErr {
+		if resp.StatusCode == s.status {
+			return nil, s.err
+		}
+	}
+	return nil, fmt.Errorf("kv %s %s: status %d", method, u, resp.StatusCode)
+}
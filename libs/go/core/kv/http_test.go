@@ -0,0 +1,53 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientMapsStatuses(t *testing.T) {
+	mux := http.NewServeMux()
+	Register(mux, NewMemory(Quota{}, map[string]Quota{"tiny": {MaxKeys: 1}}, Quota{}, time.Hour))
+	mux.HandleFunc("GET /v1/kv/broken/{key}", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	var c Store = NewClient(srv.URL)
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "tiny", "a/b", []byte("v"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "tiny", "a/b"); err != nil || string(v) != "v" {
+		t.Fatalf("get: %q %v", v, err)
+	}
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"missing", func() error { _, err := c.Get(ctx, "tiny", "nope"); return err }(), ErrNotFound},
+		{"setnx", c.SetNX(ctx, "tiny", "a/b", nil, time.Minute), ErrExists},
+		{"quota", c.Set(ctx, "tiny", "c", nil, time.Minute), ErrQuota},
+		{"ttl", c.Set(ctx, "tiny", "c", nil, 2*time.Hour), ErrTTL},
+		{"delete missing", c.Delete(ctx, "tiny", "nope"), ErrNotFound},
+	}
+	for _, tc := range cases {
+		if !errors.Is(tc.err, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, tc.err, tc.want)
+		}
+	}
+	_, err := c.Get(ctx, "broken", "k")
+	if err == nil || !strings.Contains(err.Error(), "status 500") {
+		t.Fatalf("unmapped status: %v", err)
+	}
+	if err := c.Delete(ctx, "tiny", "a/b"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,288 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("kv: key not found")
+	ErrExists   = errors.New("kv: key exists")
+	ErrQuota    = errors.New("kv: quota exceeded")
+	ErrTTL      = errors.New("kv: ttl must be positive and within the maximum")
+)
+
+// Store is shared short-lived state: nonce caches, idempotency keys, canary
+// assignments. Every key lives in a namespace and expires after its TTL.
+// Memory implements it in process and Client against a swarm-kv service.
+type Store interface {
+	Get(ctx context.Context, ns, key string) ([]byte, error)
+	Set(ctx context.Context, ns, key string, value []byte, ttl time.Duration) error
+	// SetNX sets key only if it is absent, returning ErrExists otherwise.
+	SetNX(ctx context.Context, ns, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, ns, key string) error
+}
+
+// Quota limits one namespace; zero fields are unlimited.
+type Quota struct {
+	MaxKeys  int `json:"max_keys"`
+	MaxBytes int `json:"max_bytes"`
+}
+
+// ParseQuotas reads "ns=maxKeys/maxBytes;..." as used by KV_QUOTAS.
+func ParseQuotas(spec string) (map[string]Quota, error) {
+	out := map[string]Quota{}
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		ns, limits, ok := strings.Cut(item, "=")
+		keys, size, ok2 := strings.Cut(limits, "/")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("quota %q: want ns=maxKeys/maxBytes", item)
+		}
+		var q Quota
+		var err error
+		if q.MaxKeys, err = strconv.Atoi(strings.TrimSpace(keys)); err != nil {
+			return nil, fmt.Errorf("quota %q: %w", item, err)
+		}
+		if q.MaxBytes, err = strconv.Atoi(strings.TrimSpace(size)); err != nil {
+			return nil, fmt.Errorf("quota %q: %w", item, err)
+		}
+		out[strings.TrimSpace(ns)] = q
+	}
+	return out, nil
+}
+
+// NamespaceStats describes one namespace for metrics.
+type NamespaceStats struct {
+	Keys     int    `json:"keys"`
+	Bytes    int    `json:"bytes"`
+	Quota    Quota  `json:"quota"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+	Sets     uint64 `json:"sets"`
+	Rejected uint64 `json:"rejected"` // quota or SetNX conflicts
+	Expired  uint64 `json:"expired"`
+}
+
+type item struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+type namespace struct {
+	items map[string]item
+	quota Quota
+	stats NamespaceStats
+}
+
+// Memory is an in-process Store. Expired keys read as missing and are
+// removed by Sweep. Namespaces are created by their first successful write
+// only, and unconfigured ones are dropped by Sweep once empty, so callers
+// cannot grow the store by inventing namespace names.
+type Memory struct {
+	maxTTL time.Duration
+	def    Quota
+	quotas map[string]Quota
+	total  Quota
+	now    func() time.Time
+
+	mu       sync.Mutex
+	nss      map[string]*namespace
+	keys     int
+	bytes    int
+	rejected uint64 // writes rejected by the total quota
+}
+
+// NewMemory applies quotas per namespace and def to all others, and total
+// across all namespaces; maxTTL caps every key's lifetime (0 means
+// uncapped).
+func NewMemory(def Quota, quotas map[string]Quota, total Quota, maxTTL time.Duration) *Memory {
+	return &Memory{maxTTL: maxTTL, def: def, quotas: quotas, total: total, now: time.Now, nss: map[string]*namespace{}}
+}
+
+func (m *Memory) quotaFor(name string) Quota {
+	if q, ok := m.quotas[name]; ok {
+		return q
+	}
+	return m.def
+}
+
+func (m *Memory) Get(_ context.Context, ns, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nss[ns]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	it, ok := n.items[key]
+	if !ok || !m.now().Before(it.expiresAt) {
+		n.stats.Misses++
+		return nil, ErrNotFound
+	}
+	n.stats.Hits++
+	return append([]byte(nil), it.value...), nil
+}
+
+func (m *Memory) Set(_ context.Context, ns, key string, value []byte, ttl time.Duration) error {
+	return m.set(ns, key, value, ttl, false)
+}
+
+func (m *Memory) SetNX(_ context.Context, ns, key string, value []byte, ttl time.Duration) error {
+	return m.set(ns, key, value, ttl, true)
+}
+
+func (m *Memory) set(ns, key string, value []byte, ttl time.Duration, nx bool) error {
+	if ttl <= 0 || (m.maxTTL > 0 && ttl > m.maxTTL) {
+		return ErrTTL
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nss[ns]
+	if !ok {
+		n = &namespace{items: map[string]item{}, quota: m.quotaFor(ns)}
+	}
+	now := m.now()
+	old, exists := n.items[key]
+	if exists && !now.Before(old.expiresAt) {
+		m.removeLocked(n, key)
+		n.stats.Expired++
+		exists = false
+	}
+	if exists && nx {
+		n.stats.Rejected++
+		return ErrExists
+	}
+	keys, size := len(n.items)+1, n.stats.Bytes+len(key)+len(value)
+	if exists {
+		keys, size = keys-1, size-len(key)-len(old.value)
+	}
+	if (n.quota.MaxKeys > 0 && keys > n.quota.MaxKeys) || (n.quota.MaxBytes > 0 && size > n.quota.MaxBytes) {
+		n.stats.Rejected++
+		return fmt.Errorf("%w: namespace %s", ErrQuota, ns)
+	}
+	totalKeys, totalBytes := m.keys+keys-len(n.items), m.bytes+size-n.stats.Bytes
+	if (m.total.MaxKeys > 0 && totalKeys > m.total.MaxKeys) || (m.total.MaxBytes > 0 && totalBytes > m.total.MaxBytes) {
+		m.rejected++
+		return fmt.Errorf("%w: store total", ErrQuota)
+	}
+	n.items[key] = item{value: append([]byte(nil), value...), expiresAt: now.Add(ttl)}
+	m.keys, m.bytes = totalKeys, totalBytes
+	n.stats.Bytes = size
+	n.stats.Sets++
+	m.nss[ns] = n
+	return nil
+}
+
+func (m *Memory) Delete(_ context.Context, ns, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nss[ns]
+	if !ok {
+		return ErrNotFound
+	}
+	if _, ok := n.items[key]; !ok {
+		return ErrNotFound
+	}
+	m.removeLocked(n, key)
+	return nil
+}
+
+func (m *Memory) removeLocked(n *namespace, key string) {
+	size := len(key) + len(n.items[key].value)
+	n.stats.Bytes -= size
+	m.bytes -= size
+	m.keys--
+	delete(n.items, key)
+}
+
+// Sweep removes expired keys and returns how many were removed.
+func (m *Memory) Sweep() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now, removed := m.now(), 0
+	for name, n := range m.nss {
+		for k, it := range n.items {
+			if !now.Before(it.expiresAt) {
+				m.removeLocked(n, k)
+				n.stats.Expired++
+				removed++
+			}
+		}
+		if _, configured := m.quotas[name]; len(n.items) == 0 && !configured {
+			delete(m.nss, name)
+		}
+	}
+	return removed
+}
+
+// Run sweeps every interval until ctx is done.
+func (m *Memory) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Sweep()
+		}
+	}
+}
+
+// Stats returns per-namespace stats.
+func (m *Memory) Stats() map[string]NamespaceStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]NamespaceStats, len(m.nss))
+	for name, n := range m.nss {
+		s := n.stats
+		s.Keys, s.Quota = len(n.items), n.quota
+		out[name] = s
+	}
+	return out
+}
+
+// WriteMetrics renders swarm_kv_* series per namespace in Prometheus text
+// format.
+func (m *Memory) WriteMetrics(w io.Writer) {
+	stats := m.Stats()
+	names := make([]string, 0, len(stats))
+	for n := range stats {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	series := []struct {
+		name, typ, help string
+		value           func(NamespaceStats) uint64
+	}{
+		{"swarm_kv_keys", "gauge", "Live keys per namespace.", func(s NamespaceStats) uint64 { return uint64(s.Keys) }},
+		{"swarm_kv_bytes", "gauge", "Key and value bytes per namespace.", func(s NamespaceStats) uint64 { return uint64(s.Bytes) }},
+		{"swarm_kv_hits_total", "counter", "Reads that found a live key.", func(s NamespaceStats) uint64 { return s.Hits }},
+		{"swarm_kv_misses_total", "counter", "Reads of missing or expired keys.", func(s NamespaceStats) uint64 { return s.Misses }},
+		{"swarm_kv_sets_total", "counter", "Successful writes.", func(s NamespaceStats) uint64 { return s.Sets }},
+		{"swarm_kv_rejected_total", "counter", "Writes rejected by quota or an existing key.", func(s NamespaceStats) uint64 { return s.Rejected }},
+		{"swarm_kv_expired_total", "counter", "Keys removed after expiry.", func(s NamespaceStats) uint64 { return s.Expired }},
+	}
+	for _, s := range series {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.typ)
+		for _, n := range names {
+			fmt.Fprintf(w, "%s{namespace=%q} %d\n", s.name, n, s.value(stats[n]))
+		}
+	}
+	m.mu.Lock()
+	keys, bytes, rejected := m.keys, m.bytes, m.rejected
+	m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP swarm_kv_store_keys Live keys across all namespaces.\n# TYPE swarm_kv_store_keys gauge\nswarm_kv_store_keys %d\n", keys)
+	fmt.Fprintf(w, "# HELP swarm_kv_store_bytes Key and value bytes across all namespaces.\n# TYPE swarm_kv_store_bytes gauge\nswarm_kv_store_bytes %d\n", bytes)
+	fmt.Fprintf(w, "# HELP swarm_kv_store_rejected_total Writes rejected by the store-wide quota.\n# TYPE swarm_kv_store_rejected_total counter\nswarm_kv_store_rejected_total %d\n", rejected)
+}
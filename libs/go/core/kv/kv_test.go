@@ -0,0 +1,116 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMemoryTTLAndSetNX(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	m := NewMemory(Quota{}, nil, Quota{}, time.Hour)
+	m.now = func() time.Time { return now }
+	if err := m.Set(ctx, "nonce", "a", []byte("1"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetNX(ctx, "nonce", "a", []byte("2"), time.Minute); !errors.Is(err, ErrExists) {
+		t.Fatalf("SetNX on live key: %v", err)
+	}
+	if v, err := m.Get(ctx, "nonce", "a"); err != nil || string(v) != "1" {
+		t.Fatalf("get: %q %v", v, err)
+	}
+	for _, ttl := range []time.Duration{0, 2 * time.Hour} {
+		if err := m.Set(ctx, "nonce", "b", nil, ttl); !errors.Is(err, ErrTTL) {
+			t.Errorf("ttl %v: %v", ttl, err)
+		}
+	}
+	now = now.Add(time.Minute)
+	if _, err := m.Get(ctx, "nonce", "a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expired key: %v", err)
+	}
+	if err := m.SetNX(ctx, "nonce", "a", []byte("3"), time.Minute); err != nil {
+		t.Fatalf("SetNX over expired key: %v", err)
+	}
+	if err := m.Delete(ctx, "nonce", "a"); err != nil {
+		t.Fatal(err)
+	}
+	s := m.Stats()["nonce"]
+	if s.Keys != 0 || s.Bytes != 0 || s.Hits != 1 || s.Misses != 1 || s.Sets != 2 || s.Rejected != 1 || s.Expired != 1 {
+		t.Fatalf("stats %+v", s)
+	}
+}
+
+func TestMemoryQuotas(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(Quota{MaxKeys: 2}, map[string]Quota{"small": {MaxBytes: 4}}, Quota{MaxKeys: 3}, 0)
+	if err := m.Set(ctx, "small", "k", []byte("1234"), time.Minute); !errors.Is(err, ErrQuota) {
+		t.Fatalf("namespace byte quota: %v", err)
+	}
+	if err := m.Set(ctx, "small", "k", []byte("12"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	// Overwrites count the new size only.
+	if err := m.Set(ctx, "small", "k", []byte("ab"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b"} {
+		if err := m.Set(ctx, "ns1", k, nil, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Set(ctx, "ns1", "c", nil, time.Minute); !errors.Is(err, ErrQuota) {
+		t.Fatalf("default key quota: %v", err)
+	}
+	// A fresh namespace name does not buy room past the store total.
+	if err := m.Set(ctx, "ns2", "a", nil, time.Minute); !errors.Is(err, ErrQuota) {
+		t.Fatalf("total quota: %v", err)
+	}
+	if err := m.Delete(ctx, "ns1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Set(ctx, "ns2", "a", nil, time.Minute); err != nil {
+		t.Fatalf("after delete: %v", err)
+	}
+	if m.keys != 3 || m.bytes != 3+1+1 || m.rejected != 1 {
+		t.Fatalf("totals keys=%d bytes=%d rejected=%d", m.keys, m.bytes, m.rejected)
+	}
+}
+
+func TestMemoryReadsDoNotCreateNamespaces(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	m := NewMemory(Quota{}, map[string]Quota{"configured": {}}, Quota{}, 0)
+	m.now = func() time.Time { return now }
+	for i := 0; i < 100; i++ {
+		ns := string(rune('a' + i%26))
+		_, _ = m.Get(ctx, ns+"-probe", "k")
+		_ = m.Delete(ctx, ns+"-probe", "k")
+	}
+	if n := len(m.Stats()); n != 0 {
+		t.Fatalf("reads created %d namespaces", n)
+	}
+	_ = m.Set(ctx, "configured", "k", nil, time.Second)
+	_ = m.Set(ctx, "adhoc", "k", nil, time.Second)
+	now = now.Add(time.Second)
+	if removed := m.Sweep(); removed != 2 {
+		t.Fatalf("swept %d", removed)
+	}
+	stats := m.Stats()
+	if _, ok := stats["adhoc"]; ok || len(stats) != 1 {
+		t.Fatalf("empty unconfigured namespace kept: %v", stats)
+	}
+}
+
+func TestParseQuotas(t *testing.T) {
+	q, err := ParseQuotas(" nonce=1000/65536; idem = 10/100 ;")
+	if err != nil || len(q) != 2 || q["nonce"] != (Quota{MaxKeys: 1000, MaxBytes: 65536}) || q["idem"] != (Quota{MaxKeys: 10, MaxBytes: 100}) {
+		t.Fatalf("%v %v", q, err)
+	}
+	for _, bad := range []string{"nonce=1000", "nonce", "nonce=x/1", "nonce=1/y"} {
+		if _, err := ParseQuotas(bad); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
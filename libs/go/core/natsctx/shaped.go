@@ -0,0 +1,159 @@
+package natsctx
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel/propagation"
+
+	resilience "github.com/swarmguard/libs/go/core/resilience"
+)
+
+// SubjectStats counts publishes per subject through a ShapedPublisher.
+type SubjectStats struct {
+	Published uint64 `json:"published"`
+	Dropped   uint64 `json:"dropped"`
+}
+
+// msgPublisher is the part of *nats.Conn a ShapedPublisher uses.
+type msgPublisher interface {
+	PublishMsg(*nats.Msg) error
+}
+
+// ShapedPublisher smooths bursts: messages go out at most at rate/s (with
+// burst headroom); the excess waits in a bounded buffer and, when that is
+// full, the oldest buffered message is dropped. Trace context is captured at
+// Publish time so delayed messages keep their parent span.
+type ShapedPublisher struct {
+	nc     msgPublisher
+	bucket *resilience.TokenBucket
+	max    int
+
+	mu     sync.Mutex
+	queue  []*nats.Msg
+	stats  map[string]*SubjectStats
+	wakeup chan struct{}
+}
+
+func NewShapedPublisher(nc *nats.Conn, rate float64, burst, maxBuffer int) *ShapedPublisher {
+	return &ShapedPublisher{
+		nc:     nc,
+		bucket: resilience.NewTokenBucket(rate, float64(max(burst, 1))),
+		max:    max(maxBuffer, 1),
+		stats:  map[string]*SubjectStats{},
+		wakeup: make(chan struct{}, 1),
+	}
+}
+
+// Publish sends immediately when within rate and nothing is queued,
+// otherwise buffers the message for Run to send. It never blocks.
+func (p *ShapedPublisher) Publish(ctx context.Context, subject string, data []byte) error {
+	hdr := nats.Header{}
+	propagator.Inject(ctx, propagation.HeaderCarrier(hdr))
+	msg := &nats.Msg{Subject: subject, Data: data, Header: hdr}
+
+	p.mu.Lock()
+	if len(p.queue) == 0 {
+		if ok, _ := p.bucket.Take(); ok {
+			p.mu.Unlock()
+			return p.send(msg)
+		}
+	}
+	if len(p.queue) >= p.max {
+		dropped := p.queue[0]
+		p.queue = p.queue[1:]
+		p.statsLocked(dropped.Subject).Dropped++
+	}
+	p.queue = append(p.queue, msg)
+	p.mu.Unlock()
+	select {
+	case p.wakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run drains the buffer at the shaped rate until ctx is done. Messages still
+// buffered when it returns are counted as dropped.
+func (p *ShapedPublisher) Run(ctx context.Context) {
+	defer p.dropAll()
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		p.mu.Lock()
+		if len(p.queue) == 0 {
+			p.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-p.wakeup:
+				continue
+			}
+		}
+		ok, wait := p.bucket.Take()
+		if !ok {
+			p.mu.Unlock()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+				continue
+			}
+		}
+		msg := p.queue[0]
+		p.queue = p.queue[1:]
+		p.mu.Unlock()
+		_ = p.send(msg)
+	}
+}
+
+func (p *ShapedPublisher) send(msg *nats.Msg) error {
+	err := p.nc.PublishMsg(msg)
+	p.mu.Lock()
+	if err != nil {
+		p.statsLocked(msg.Subject).Dropped++
+	} else {
+		p.statsLocked(msg.Subject).Published++
+	}
+	p.mu.Unlock()
+	return err
+}
+
+func (p *ShapedPublisher) dropAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.queue {
+		p.statsLocked(m.Subject).Dropped++
+	}
+	p.queue = nil
+}
+
+func (p *ShapedPublisher) statsLocked(subject string) *SubjectStats {
+	s, ok := p.stats[subject]
+	if !ok {
+		s = &SubjectStats{}
+		p.stats[subject] = s
+	}
+	return s
+}
+
+// Buffered returns the number of messages waiting to be sent.
+func (p *ShapedPublisher) Buffered() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.queue)
+}
+
+// Stats returns per-subject publish and drop counts.
+func (p *ShapedPublisher) Stats() map[string]SubjectStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make(map[string]SubjectStats, len(p.stats))
+	for k, v := range p.stats {
+		out[k] = *v
+	}
+	return out
+}
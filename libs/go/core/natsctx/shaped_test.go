@@ -0,0 +1,122 @@
+package natsctx
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+)
+
+type fakeConn struct {
+	mu   sync.Mutex
+	sent []*nats.Msg
+	err  error
+}
+
+func (c *fakeConn) PublishMsg(m *nats.Msg) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.sent = append(c.sent, m)
+	return nil
+}
+
+func (c *fakeConn) subjects() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]string, len(c.sent))
+	for i, m := range c.sent {
+		out[i] = m.Subject + ":" + string(m.Data)
+	}
+	return out
+}
+
+func newTestPublisher(rate float64, burst, maxBuffer int) (*ShapedPublisher, *fakeConn) {
+	p := NewShapedPublisher(nil, rate, burst, maxBuffer)
+	conn := &fakeConn{}
+	p.nc = conn
+	return p, conn
+}
+
+func TestShapedPublisherDropsOldest(t *testing.T) {
+	p, conn := newTestPublisher(0, 1, 2)
+	ctx := context.Background()
+	for i, subj := range []string{"a", "a", "b", "a"} {
+		if err := p.Publish(ctx, subj, []byte{byte('0' + i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first message used the burst; of the three buffered ones the
+	// oldest ("a:1") made room for the last.
+	if got := conn.subjects(); len(got) != 1 || got[0] != "a:0" {
+		t.Fatalf("sent %v", got)
+	}
+	if p.Buffered() != 2 {
+		t.Fatalf("buffered %d", p.Buffered())
+	}
+	if s := p.Stats(); s["a"] != (SubjectStats{Published: 1, Dropped: 1}) || s["b"] != (SubjectStats{}) {
+		t.Fatalf("stats %+v", s)
+	}
+
+	conn.err = errors.New("disconnected")
+	p2, _ := newTestPublisher(100, 1, 1)
+	p2.nc = conn
+	if err := p2.Publish(ctx, "c", nil); err == nil || p2.Stats()["c"].Dropped != 1 {
+		t.Fatalf("failed send: %v %+v", err, p2.Stats())
+	}
+}
+
+func TestShapedPublisherDropsBufferedOnShutdown(t *testing.T) {
+	// With and without tokens left, Run must stop sending once ctx is done
+	// and count what is still buffered as dropped.
+	for _, burst := range []int{1, 5} {
+		p, conn := newTestPublisher(0, burst, 10)
+		p.bucket.Take()
+		for i := 0; i < 3; i++ {
+			p.queue = append(p.queue, &nats.Msg{Subject: "s"})
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		p.Run(ctx)
+		if p.Buffered() != 0 || p.Stats()["s"].Dropped != 3 || len(conn.subjects()) != 0 {
+			t.Fatalf("burst %d: buffered %d, sent %d, stats %+v", burst, p.Buffered(), len(conn.subjects()), p.Stats())
+		}
+	}
+}
+
+func TestShapedPublisherPacesAtRate(t *testing.T) {
+	p, conn := newTestPublisher(50, 1, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() { p.Run(ctx); close(done) }()
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		_ = p.Publish(ctx, "s", []byte{byte('0' + i)})
+	}
+	for p.Buffered() > 0 {
+		if time.Since(start) > 2*time.Second {
+			t.Fatal("buffer not drained")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// One message rides the burst, the other nine wait for 50/s refills.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("10 messages at 50/s sent in %v", elapsed)
+	}
+	time.Sleep(10 * time.Millisecond) // let the last send finish
+	got := conn.subjects()
+	if len(got) != 10 || got[0] != "s:0" || got[9] != "s:9" {
+		t.Fatalf("sent %v", got)
+	}
+	cancel()
+	<-done
+	if s := p.Stats()["s"]; s.Published != 10 || s.Dropped != 0 {
+		t.Fatalf("stats %+v", s)
+	}
+}
@@ -0,0 +1,133 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BackendFromSpec builds a backend from a URL-like spec:
+//
+//	file:///etc/swarm/services.json  static file, reloaded when modified
+//	dns://svc.cluster.local          SRV lookup of _http._tcp.<service>.<domain>
+//	consul://127.0.0.1:8500          Consul health API, passing instances only
+func BackendFromSpec(spec string) (Backend, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		return NewStaticFile(u.Path), nil
+	case "dns":
+		return &DNSBackend{Domain: u.Host, Scheme: "http"}, nil
+	case "consul":
+		return &ConsulBackend{Addr: "http://" + u.Host, http: &http.Client{Timeout: 5 * time.Second}}, nil
+	}
+	return nil, fmt.Errorf("registry: unsupported backend %q", spec)
+}
+
+// StaticFile reads {"service": ["http://host:port", ...]} from a JSON file.
+type StaticFile struct {
+	path string
+
+	mu       sync.Mutex
+	modTime  time.Time
+	services map[string][]string
+}
+
+func NewStaticFile(path string) *StaticFile { return &StaticFile{path: path} }
+
+func (s *StaticFile) Resolve(_ context.Context, service string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.ModTime().Equal(s.modTime) {
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			return nil, err
+		}
+		var services map[string][]string
+		if err := json.Unmarshal(data, &services); err != nil {
+			return nil, fmt.Errorf("decode %s: %w", s.path, err)
+		}
+		s.services, s.modTime = services, fi.ModTime()
+	}
+	return s.services[service], nil
+}
+
+// DNSBackend resolves SRV records _http._tcp.<service>.<Domain>.
+type DNSBackend struct {
+	Domain string
+	Scheme string
+}
+
+func (d *DNSBackend) Resolve(ctx context.Context, service string) ([]string, error) {
+	name := service
+	if d.Domain != "" {
+		name += "." + d.Domain
+	}
+	_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "http", "tcp", name)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(srvs))
+	for _, srv := range srvs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		out = append(out, d.Scheme+"://"+net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+	}
+	return out, nil
+}
+
+// ConsulBackend lists passing instances from the Consul health API.
+type ConsulBackend struct {
+	Addr string
+	http *http.Client
+}
+
+func (c *ConsulBackend) Resolve(ctx context.Context, service string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.Addr+"/v1/health/service/"+url.PathEscape(service)+"?passing=true", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("consul: status %d", resp.StatusCode)
+	}
+	var entries []struct {
+		Node struct {
+			Address string
+		}
+		Service struct {
+			Address string
+			Port    int
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		out = append(out, "http://"+net.JoinHostPort(host, strconv.Itoa(e.Service.Port)))
+	}
+	return out, nil
+}
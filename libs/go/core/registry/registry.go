@@ -0,0 +1,197 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNoEndpoint = errors.New("registry: no healthy endpoint")
+
+// Backend resolves the current endpoint base URLs of a service.
+type Backend interface {
+	Resolve(ctx context.Context, service string) ([]string, error)
+}
+
+// Endpoint is one instance of a service as last seen by the health checker.
+type Endpoint struct {
+	URL       string    `json:"url"`
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+type Options struct {
+	HealthPath string        // GET path probed on each endpoint; "" disables probing
+	Interval   time.Duration // resolve + probe period
+	Timeout    time.Duration // per probe
+}
+
+type serviceState struct {
+	endpoints []Endpoint
+	next      int // round-robin cursor
+}
+
+// Registry tracks services from a Backend, health checks their endpoints and
+// hands out healthy ones round-robin.
+type Registry struct {
+	backend Backend
+	opts    Options
+	http    *http.Client
+
+	mu       sync.Mutex
+	services map[string]*serviceState
+	subs     []func(service string, healthy []string)
+}
+
+func New(backend Backend, opts Options) *Registry {
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	return &Registry{backend: backend, opts: opts, http: &http.Client{Timeout: opts.Timeout}, services: map[string]*serviceState{}}
+}
+
+// Subscribe registers f to be called whenever the healthy set of a service
+// changes.
+func (r *Registry) Subscribe(f func(service string, healthy []string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.subs = append(r.subs, f)
+}
+
+// Watch starts tracking service and resolves it once synchronously.
+func (r *Registry) Watch(ctx context.Context, service string) error {
+	r.mu.Lock()
+	if _, ok := r.services[service]; !ok {
+		r.services[service] = &serviceState{}
+	}
+	r.mu.Unlock()
+	return r.refresh(ctx, service)
+}
+
+// Run refreshes every watched service each interval until ctx is done.
+func (r *Registry) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		names := make([]string, 0, len(r.services))
+		for name := range r.services {
+			names = append(names, name)
+		}
+		r.mu.Unlock()
+		for _, name := range names {
+			_ = r.refresh(ctx, name)
+		}
+	}
+}
+
+// Pick returns the next healthy endpoint of service.
+func (r *Registry) Pick(service string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st, ok := r.services[service]
+	if !ok {
+		return "", fmt.Errorf("%w: %s is not watched", ErrNoEndpoint, service)
+	}
+	for range st.endpoints {
+		ep := st.endpoints[st.next%len(st.endpoints)]
+		st.next++
+		if ep.Healthy {
+			return ep.URL, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrNoEndpoint, service)
+}
+
+// Endpoints returns the last known endpoints of service.
+func (r *Registry) Endpoints(service string) []Endpoint {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if st, ok := r.services[service]; ok {
+		return slices.Clone(st.endpoints)
+	}
+	return nil
+}
+
+func (r *Registry) refresh(ctx context.Context, service string) error {
+	urls, err := r.backend.Resolve(ctx, service)
+	if err != nil {
+		// Keep serving the last known endpoints while the backend is unavailable.
+		return err
+	}
+	endpoints := make([]Endpoint, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		endpoints[i] = Endpoint{URL: strings.TrimRight(u, "/"), Healthy: true, LastCheck: time.Now().UTC()}
+		if r.opts.HealthPath == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(ep *Endpoint) {
+			defer wg.Done()
+			if err := r.probe(ctx, ep.URL); err != nil {
+				ep.Healthy, ep.LastError = false, err.Error()
+			}
+		}(&endpoints[i])
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	st, ok := r.services[service]
+	if !ok {
+		r.mu.Unlock()
+		return nil
+	}
+	before := healthyURLs(st.endpoints)
+	st.endpoints = endpoints
+	after := healthyURLs(endpoints)
+	subs := slices.Clone(r.subs)
+	r.mu.Unlock()
+	if !slices.Equal(before, after) {
+		for _, f := range subs {
+			f(service, after)
+		}
+	}
+	return nil
+}
+
+func (r *Registry) probe(ctx context.Context, base string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+r.opts.HealthPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("health status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func healthyURLs(eps []Endpoint) []string {
+	var out []string
+	for _, ep := range eps {
+		if ep.Healthy {
+			out = append(out, ep.URL)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
@@ -0,0 +1,123 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeBackend struct {
+	mu   sync.Mutex
+	urls []string
+	err  error
+}
+
+func (f *fakeBackend) Resolve(context.Context, string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.urls), f.err
+}
+
+func TestPickRoundRobinsHealthyEndpoints(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer healthy.Close()
+	sick := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusServiceUnavailable) }))
+	defer sick.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer other.Close()
+
+	r := New(&fakeBackend{urls: []string{healthy.URL + "/", sick.URL, other.URL}}, Options{HealthPath: "/health"})
+	if _, err := r.Pick("orchestrator"); !errors.Is(err, ErrNoEndpoint) {
+		t.Fatalf("unwatched service: %v", err)
+	}
+	if err := r.Watch(context.Background(), "orchestrator"); err != nil {
+		t.Fatal(err)
+	}
+	var picks []string
+	for i := 0; i < 4; i++ {
+		u, err := r.Pick("orchestrator")
+		if err != nil {
+			t.Fatal(err)
+		}
+		picks = append(picks, u)
+	}
+	if want := []string{healthy.URL, other.URL, healthy.URL, other.URL}; !slices.Equal(picks, want) {
+		t.Fatalf("picks %v, want %v", picks, want)
+	}
+	eps := r.Endpoints("orchestrator")
+	if len(eps) != 3 || eps[1].Healthy || eps[1].LastError != "health status 503" {
+		t.Fatalf("endpoints %+v", eps)
+	}
+}
+
+func TestRefreshKeepsEndpointsWhenBackendDown(t *testing.T) {
+	b := &fakeBackend{urls: []string{"http://a:1", "http://b:1"}}
+	r := New(b, Options{})
+	var mu sync.Mutex
+	var notified [][]string
+	r.Subscribe(func(service string, healthy []string) {
+		mu.Lock()
+		notified = append(notified, healthy)
+		mu.Unlock()
+	})
+	ctx := context.Background()
+	if err := r.Watch(ctx, "svc"); err != nil {
+		t.Fatal(err)
+	}
+	b.mu.Lock()
+	b.err = errors.New("backend down")
+	b.mu.Unlock()
+	if err := r.refresh(ctx, "svc"); err == nil {
+		t.Fatal("want backend error")
+	}
+	if u, err := r.Pick("svc"); err != nil || u != "http://a:1" {
+		t.Fatalf("last known endpoints dropped: %q %v", u, err)
+	}
+	b.mu.Lock()
+	b.urls, b.err = []string{"http://b:1", "http://a:1"}, nil
+	b.mu.Unlock()
+	_ = r.refresh(ctx, "svc") // same set in another order: no notification
+	b.mu.Lock()
+	b.urls = []string{"http://c:1"}
+	b.mu.Unlock()
+	_ = r.refresh(ctx, "svc")
+	mu.Lock()
+	defer mu.Unlock()
+	if len(notified) != 2 || !slices.Equal(notified[0], []string{"http://a:1", "http://b:1"}) || !slices.Equal(notified[1], []string{"http://c:1"}) {
+		t.Fatalf("notifications %v", notified)
+	}
+}
+
+func TestStaticFileReloadsOnChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.json")
+	write := func(m map[string][]string, mod time.Time) {
+		data, _ := json.Marshal(m)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Chtimes(path, mod, mod)
+	}
+	write(map[string][]string{"svc": {"http://a:1"}}, time.Unix(1000, 0))
+	b, err := BackendFromSpec("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if urls, err := b.Resolve(context.Background(), "svc"); err != nil || !slices.Equal(urls, []string{"http://a:1"}) {
+		t.Fatalf("%v %v", urls, err)
+	}
+	write(map[string][]string{"svc": {"http://b:1"}}, time.Unix(2000, 0))
+	if urls, _ := b.Resolve(context.Background(), "svc"); !slices.Equal(urls, []string{"http://b:1"}) {
+		t.Fatalf("not reloaded: %v", urls)
+	}
+	if _, err := BackendFromSpec("etcd://x"); err == nil {
+		t.Fatal("want error for unsupported backend")
+	}
+}
@@ -0,0 +1,172 @@
+package resilience
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyLimit is the rate (tokens per second) and burst of one key's bucket.
+type KeyLimit struct {
+	Rate  float64 `json:"rate"`
+	Burst float64 `json:"burst"`
+}
+
+// ParseKeyLimits reads overrides of the form "tenant:acme=50/100;ip:10.0.0.5=5/5".
+func ParseKeyLimits(spec string) (map[string]KeyLimit, error) {
+	out := map[string]KeyLimit{}
+	for _, item := range strings.Split(spec, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, limit, ok := strings.Cut(item, "=")
+		rate, burst, ok2 := strings.Cut(limit, "/")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("rate limit %q: want key=rate/burst", item)
+		}
+		r, err := strconv.ParseFloat(rate, 64)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit %q: %w", item, err)
+		}
+		b, err := strconv.ParseFloat(burst, 64)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit %q: %w", item, err)
+		}
+		out[strings.TrimSpace(key)] = KeyLimit{Rate: r, Burst: b}
+	}
+	return out, nil
+}
+
+// KeyStats describes one tracked key.
+type KeyStats struct {
+	Key      string    `json:"key"`
+	Rate     float64   `json:"rate"`
+	Burst    float64   `json:"burst"`
+	Tokens   float64   `json:"tokens"`
+	Allowed  uint64    `json:"allowed"`
+	Rejected uint64    `json:"rejected"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+type keyState struct {
+	bucket *TokenBucket
+	limit  KeyLimit
+	stats  KeyStats
+}
+
+// OverflowKey is the bucket shared by new keys once a PerKeyLimiter tracks
+// its maximum number of keys.
+const OverflowKey = "other"
+
+// PerKeyLimiter keeps one token bucket per key (API key, tenant) so a single
+// noisy caller cannot exhaust a shared budget. Keys use their override limit
+// or the default; buckets idle longer than idleTTL are dropped by Sweep. At
+// most maxKeys buckets are tracked: further keys share the OverflowKey
+// bucket, so callers inventing keys cannot grow memory or get fresh budgets.
+type PerKeyLimiter struct {
+	def       KeyLimit
+	overrides map[string]KeyLimit
+	idleTTL   time.Duration
+	maxKeys   int
+
+	mu   sync.Mutex
+	keys map[string]*keyState
+}
+
+// NewPerKeyLimiter tracks up to maxKeys keys; maxKeys <= 0 means no cap.
+func NewPerKeyLimiter(def KeyLimit, overrides map[string]KeyLimit, idleTTL time.Duration, maxKeys int) *PerKeyLimiter {
+	return &PerKeyLimiter{def: def, overrides: overrides, idleTTL: idleTTL, maxKeys: maxKeys, keys: map[string]*keyState{}}
+}
+
+// Allow takes a token for key; when rejected it reports how long until the
+// next token.
+func (l *PerKeyLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	st, ok := l.keys[key]
+	if !ok && l.maxKeys > 0 && len(l.keys) >= l.maxKeys {
+		key = OverflowKey
+		st, ok = l.keys[key]
+	}
+	if !ok {
+		limit, ok := l.overrides[key]
+		if !ok {
+			limit = l.def
+		}
+		st = &keyState{bucket: NewTokenBucket(limit.Rate, max(limit.Burst, 1)), limit: limit, stats: KeyStats{Key: key}}
+		l.keys[key] = st
+	}
+	l.mu.Unlock()
+	allowed, wait := st.bucket.Take()
+	l.mu.Lock()
+	if allowed {
+		st.stats.Allowed++
+	} else {
+		st.stats.Rejected++
+	}
+	st.stats.LastSeen = time.Now().UTC()
+	l.mu.Unlock()
+	return allowed, wait
+}
+
+// Refund returns the token Allow took for key when the request was then
+// rejected by a later check, so it does not count against the caller.
+func (l *PerKeyLimiter) Refund(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	st, ok := l.keys[key]
+	if !ok && l.maxKeys > 0 && len(l.keys) >= l.maxKeys {
+		st, ok = l.keys[OverflowKey]
+	}
+	if !ok {
+		return
+	}
+	st.bucket.refund()
+	st.stats.Allowed--
+}
+
+// Sweep drops keys idle longer than idleTTL and returns how many.
+func (l *PerKeyLimiter) Sweep() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff, n := time.Now().Add(-l.idleTTL), 0
+	for k, st := range l.keys {
+		if st.stats.LastSeen.Before(cutoff) {
+			delete(l.keys, k)
+			n++
+		}
+	}
+	return n
+}
+
+// Run sweeps every idleTTL until ctx is done.
+func (l *PerKeyLimiter) Run(ctx context.Context) {
+	ticker := time.NewTicker(max(l.idleTTL, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.Sweep()
+		}
+	}
+}
+
+// Stats returns tracked keys with their current token levels, sorted by key.
+func (l *PerKeyLimiter) Stats() []KeyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]KeyStats, 0, len(l.keys))
+	for _, st := range l.keys {
+		s := st.stats
+		s.Rate, s.Burst, s.Tokens = st.limit.Rate, max(st.limit.Burst, 1), st.bucket.Tokens()
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
@@ -0,0 +1,85 @@
+package resilience
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPerKeyLimiterIsolatesKeys(t *testing.T) {
+	l := NewPerKeyLimiter(KeyLimit{Rate: 0, Burst: 2}, map[string]KeyLimit{"tenant:big": {Rate: 0, Burst: 4}}, time.Minute, 0)
+	allowed := func(key string, n int) int {
+		got := 0
+		for i := 0; i < n; i++ {
+			if ok, _ := l.Allow(key); ok {
+				got++
+			}
+		}
+		return got
+	}
+	if a, b, big := allowed("tenant:a", 5), allowed("tenant:b", 5), allowed("tenant:big", 5); a != 2 || b != 2 || big != 4 {
+		t.Fatalf("allowed a=%d b=%d big=%d", a, b, big)
+	}
+	if ok, wait := l.Allow("tenant:a"); ok || wait != time.Hour {
+		t.Fatalf("zero-rate bucket: ok=%v wait=%v", ok, wait)
+	}
+	stats := l.Stats()
+	if len(stats) != 3 || stats[0].Key != "tenant:a" || stats[0].Allowed != 2 || stats[0].Rejected != 4 || stats[2].Burst != 4 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestPerKeyLimiterCapsKeysAndSweeps(t *testing.T) {
+	l := NewPerKeyLimiter(KeyLimit{Rate: 0, Burst: 1}, nil, time.Hour, 2)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		l.Allow(k)
+	}
+	stats := l.Stats()
+	// c and d share the overflow bucket, so d was rejected.
+	if len(stats) != 3 || stats[2].Key != OverflowKey || stats[2].Allowed != 1 || stats[2].Rejected != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+	if n := l.Sweep(); n != 0 {
+		t.Fatalf("swept %d active keys", n)
+	}
+	l.idleTTL = -time.Second
+	if n := l.Sweep(); n != 3 || len(l.Stats()) != 0 {
+		t.Fatalf("swept %d, left %v", n, l.Stats())
+	}
+}
+
+func TestParseKeyLimits(t *testing.T) {
+	got, err := ParseKeyLimits(" tenant:acme=50/100; ip:10.0.0.5=5/5 ;")
+	if err != nil || len(got) != 2 || got["tenant:acme"] != (KeyLimit{Rate: 50, Burst: 100}) || got["ip:10.0.0.5"] != (KeyLimit{Rate: 5, Burst: 5}) {
+		t.Fatalf("%v %v", got, err)
+	}
+	for _, bad := range []string{"tenant:acme", "tenant:acme=50", "tenant:acme=x/1", "tenant:acme=1/y"} {
+		if _, err := ParseKeyLimits(bad); err == nil {
+			t.Errorf("%q: want error", bad)
+		}
+	}
+}
+
+func TestPerKeyLimiterRefund(t *testing.T) {
+	l := NewPerKeyLimiter(KeyLimit{Rate: 0, Burst: 1}, nil, time.Minute, 1)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("first request rejected")
+	}
+	l.Refund("a")
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("refunded token not available")
+	}
+	if ok, _ := l.Allow("a"); ok {
+		t.Fatal("refund must not exceed the burst")
+	}
+	// Keys beyond the cap are refunded to the overflow bucket.
+	l.Allow("b")
+	l.Refund("b")
+	if ok, _ := l.Allow("c"); !ok {
+		t.Fatal("overflow refund lost")
+	}
+	l.Refund("unknown-but-capped")
+	stats := l.Stats()
+	if stats[0].Key != "a" || stats[0].Allowed != 1 || stats[0].Rejected != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
@@ -0,0 +1,275 @@
+package resilience
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a request is rejected, either immediately
+// (OverflowReject) or after waiting MaxWait without getting a token.
+var ErrRateLimited = errors.New("rate limited")
+
+type OverflowPolicy int
+
+const (
+	OverflowReject OverflowPolicy = iota // fail fast when the class bucket is empty
+	OverflowQueue                        // wait up to MaxWait for a token
+)
+
+// PriorityClass is a traffic class with a weighted share of the total rate.
+// Keys are matched against Patterns with path.Match (e.g. "internal/*",
+// "batch:*"); the first matching class wins.
+type PriorityClass struct {
+	Name     string
+	Patterns []string
+	Weight   int
+	Overflow OverflowPolicy
+	MaxWait  time.Duration
+}
+
+// TokenBucket is a basic token bucket refilled continuously at rate per second.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate, burst float64) *TokenBucket {
+	return &TokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// Take consumes one token if available; otherwise it reports how long until
+// the next token will be.
+func (b *TokenBucket) Take() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if b.rate <= 0 {
+		return false, time.Hour
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// takeAbove consumes one token only if at least floor tokens remain
+// afterwards, so a lender keeps a reserve for its own traffic.
+func (b *TokenBucket) takeAbove(floor float64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens-1 < floor {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refund returns a token taken for a request that was rejected later on.
+func (b *TokenBucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.burst, b.tokens+1)
+}
+
+// Tokens returns the current token level without consuming.
+func (b *TokenBucket) Tokens() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return min(b.burst, b.tokens+time.Since(b.last).Seconds()*b.rate)
+}
+
+// ParsePriorityClasses decodes a JSON class list, e.g.
+//
+//	[{"name":"interactive","patterns":["internal/*"],"weight":4,"overflow":"queue","max_wait":"50ms"},
+//	 {"name":"batch","patterns":["batch:*"],"weight":1},
+//	 {"name":"default","weight":2}]
+func ParsePriorityClasses(data []byte) ([]PriorityClass, error) {
+	var raw []struct {
+		Name     string   `json:"name"`
+		Patterns []string `json:"patterns"`
+		Weight   int      `json:"weight"`
+		Overflow string   `json:"overflow"`
+		MaxWait  string   `json:"max_wait"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	out := make([]PriorityClass, 0, len(raw))
+	for _, r := range raw {
+		c := PriorityClass{Name: r.Name, Patterns: r.Patterns, Weight: r.Weight}
+		switch r.Overflow {
+		case "", "reject":
+		case "queue":
+			c.Overflow = OverflowQueue
+		default:
+			return nil, fmt.Errorf("class %s: unknown overflow %q", r.Name, r.Overflow)
+		}
+		if r.MaxWait != "" {
+			d, err := time.ParseDuration(r.MaxWait)
+			if err != nil {
+				return nil, fmt.Errorf("class %s: max_wait: %w", r.Name, err)
+			}
+			c.MaxWait = d
+		}
+		out = append(out, c)
+	}
+	return out, nil
+}
+
+// ClassStats are cumulative counters per class.
+type ClassStats struct {
+	Class    string  `json:"class"`
+	Allowed  uint64  `json:"allowed"`
+	Queued   uint64  `json:"queued"`
+	Rejected uint64  `json:"rejected"`
+	Borrowed uint64  `json:"borrowed"` // allowed on another class's unused share
+	Tokens   float64 `json:"tokens"`
+}
+
+type classState struct {
+	PriorityClass
+	bucket *TokenBucket
+	// reserve is the part of the burst a class never lends out.
+	reserve float64
+	stats   ClassStats
+}
+
+// PriorityLimiter splits a total rate across priority classes by weight so a
+// burst in a low-priority class (batch, external) cannot consume the tokens
+// reserved for interactive or internal traffic. It is work-conserving: a
+// class whose own bucket is empty borrows from classes holding more than
+// half their burst, so the share of idle classes is not wasted, while every
+// class keeps half its burst for its own next spike.
+type PriorityLimiter struct {
+	mu      sync.Mutex
+	classes []*classState
+}
+
+// NewPriorityLimiter builds per-class buckets with rate*weight/sum(weights)
+// tokens per second. The last class acts as the default for unmatched keys.
+func NewPriorityLimiter(rate, burst float64, classes []PriorityClass) *PriorityLimiter {
+	if len(classes) == 0 {
+		classes = []PriorityClass{{Name: "default", Weight: 1}}
+	}
+	total := 0
+	for _, c := range classes {
+		total += max(c.Weight, 1)
+	}
+	l := &PriorityLimiter{}
+	for _, c := range classes {
+		share := float64(max(c.Weight, 1)) / float64(total)
+		classBurst := max(1, burst*share)
+		l.classes = append(l.classes, &classState{
+			PriorityClass: c,
+			bucket:        NewTokenBucket(rate*share, classBurst),
+			reserve:       max(1, classBurst/2),
+			stats:         ClassStats{Class: c.Name},
+		})
+	}
+	return l
+}
+
+func (l *PriorityLimiter) classFor(key string) *classState {
+	for _, c := range l.classes {
+		for _, p := range c.Patterns {
+			if ok, _ := path.Match(p, key); ok {
+				return c
+			}
+		}
+	}
+	return l.classes[len(l.classes)-1]
+}
+
+// Class returns the name of the class key maps to.
+func (l *PriorityLimiter) Class(key string) string { return l.classFor(key).Name }
+
+// take takes a token from c's bucket or, failing that, from the unused
+// share of another class.
+func (l *PriorityLimiter) take(c *classState) (bool, time.Duration) {
+	ok, wait := c.bucket.Take()
+	if ok {
+		l.count(c, func(s *ClassStats) { s.Allowed++ })
+		return true, 0
+	}
+	for _, o := range l.classes {
+		if o != c && o.bucket.takeAbove(o.reserve) {
+			l.count(c, func(s *ClassStats) { s.Allowed++; s.Borrowed++ })
+			return true, 0
+		}
+	}
+	return false, wait
+}
+
+// Wait admits one request for key according to its class overflow policy.
+func (l *PriorityLimiter) Wait(ctx context.Context, key string) error {
+	c := l.classFor(key)
+	ok, wait := l.take(c)
+	if ok {
+		return nil
+	}
+	if c.Overflow != OverflowQueue || wait > c.MaxWait {
+		l.count(c, func(s *ClassStats) { s.Rejected++ })
+		return ErrRateLimited
+	}
+	l.count(c, func(s *ClassStats) { s.Queued++ })
+	deadline := time.Now().Add(c.MaxWait)
+	for {
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			l.count(c, func(s *ClassStats) { s.Rejected++ })
+			return ctx.Err()
+		case <-t.C:
+		}
+		if ok, wait = l.take(c); ok {
+			return nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			l.count(c, func(s *ClassStats) { s.Rejected++ })
+			return ErrRateLimited
+		}
+	}
+}
+
+// Allow is the non-blocking form of Wait: queueing classes are treated as reject.
+func (l *PriorityLimiter) Allow(key string) bool {
+	c := l.classFor(key)
+	ok, _ := l.take(c)
+	if !ok {
+		l.count(c, func(s *ClassStats) { s.Rejected++ })
+	}
+	return ok
+}
+
+func (l *PriorityLimiter) count(c *classState, f func(*ClassStats)) {
+	l.mu.Lock()
+	f(&c.stats)
+	l.mu.Unlock()
+}
+
+func (l *PriorityLimiter) Stats() []ClassStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]ClassStats, 0, len(l.classes))
+	for _, c := range l.classes {
+		s := c.stats
+		s.Tokens = c.bucket.Tokens()
+		out = append(out, s)
+	}
+	return out
+}
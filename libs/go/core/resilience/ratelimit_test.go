@@ -0,0 +1,111 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPriorityLimiterSplitsByWeight(t *testing.T) {
+	l := NewPriorityLimiter(0, 8, []PriorityClass{
+		{Name: "interactive", Patterns: []string{"internal/*"}, Weight: 3},
+		{Name: "default", Weight: 1},
+	})
+	if l.Class("internal/evaluate") != "interactive" || l.Class("batch:nightly") != "default" {
+		t.Fatalf("classes %q %q", l.Class("internal/evaluate"), l.Class("batch:nightly"))
+	}
+	allowed := map[string]int{}
+	for i := 0; i < 10; i++ {
+		for _, key := range []string{"internal/evaluate", "batch:nightly"} {
+			if l.Allow(key) {
+				allowed[l.Class(key)]++
+			}
+		}
+	}
+	// A burst of 8 split 3:1; the batch burst cannot take interactive tokens.
+	if allowed["interactive"] != 6 || allowed["default"] != 2 {
+		t.Fatalf("allowed %v", allowed)
+	}
+	stats := l.Stats()
+	if stats[0].Allowed != 6 || stats[0].Rejected != 4 || stats[1].Allowed != 2 || stats[1].Rejected != 8 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestPriorityLimiterOverflow(t *testing.T) {
+	l := NewPriorityLimiter(20, 2, []PriorityClass{
+		{Name: "queued", Patterns: []string{"q"}, Weight: 1, Overflow: OverflowQueue, MaxWait: 200 * time.Millisecond},
+		{Name: "short", Patterns: []string{"s"}, Weight: 1, Overflow: OverflowQueue, MaxWait: time.Millisecond},
+		{Name: "reject", Weight: 1},
+	})
+	ctx := context.Background()
+	for _, key := range []string{"q", "s", "r"} {
+		if err := l.Wait(ctx, key); err != nil {
+			t.Fatalf("%s: first token: %v", key, err)
+		}
+	}
+	// Each class refills at 20/3 tokens/s, about one per 150ms.
+	if err := l.Wait(ctx, "r"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("reject class: %v", err)
+	}
+	if err := l.Wait(ctx, "s"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("wait beyond MaxWait must be rejected: %v", err)
+	}
+	start := time.Now()
+	if err := l.Wait(ctx, "q"); err != nil {
+		t.Fatalf("queue class: %v", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Fatalf("queued request admitted after %v", waited)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := l.Wait(cancelled, "q"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled wait: %v", err)
+	}
+	stats := l.Stats()
+	if stats[0].Queued != 2 || stats[0].Allowed != 2 || stats[0].Rejected != 1 || stats[1].Rejected != 1 || stats[2].Rejected != 1 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestParsePriorityClasses(t *testing.T) {
+	cs, err := ParsePriorityClasses([]byte(`[{"name":"i","patterns":["internal/*"],"weight":4,"overflow":"queue","max_wait":"50ms"},{"name":"d","weight":1}]`))
+	if err != nil || len(cs) != 2 || cs[0].Overflow != OverflowQueue || cs[0].MaxWait != 50*time.Millisecond || cs[1].Overflow != OverflowReject {
+		t.Fatalf("%+v %v", cs, err)
+	}
+	for _, bad := range []string{`[{"name":"x","overflow":"drop"}]`, `[{"name":"x","max_wait":"soon"}]`, `{}`} {
+		if _, err := ParsePriorityClasses([]byte(bad)); err == nil {
+			t.Errorf("%s: want error", bad)
+		}
+	}
+}
+
+func TestPriorityLimiterLendsIdleShare(t *testing.T) {
+	l := NewPriorityLimiter(0, 8, []PriorityClass{
+		{Name: "interactive", Patterns: []string{"internal/*"}, Weight: 3},
+		{Name: "default", Weight: 1},
+	})
+	// With interactive idle, batch gets its own 2 tokens plus the 3 of
+	// interactive's 6 above its reserve.
+	n := 0
+	for i := 0; i < 10; i++ {
+		if l.Allow("batch:nightly") {
+			n++
+		}
+	}
+	if n != 5 {
+		t.Fatalf("idle share: batch allowed %d, want 5", n)
+	}
+	stats := l.Stats()
+	if stats[1].Allowed != 5 || stats[1].Borrowed != 3 || stats[1].Rejected != 5 || stats[0].Tokens != 3 {
+		t.Fatalf("stats %+v", stats)
+	}
+	// The reserve is still there for interactive traffic.
+	for i := 0; i < 3; i++ {
+		if !l.Allow("internal/evaluate") {
+			t.Fatalf("interactive request %d rejected after lending", i)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package warmup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StepResult is the outcome of one warmup step.
+type StepResult struct {
+	Name     string        `json:"name"`
+	Done     bool          `json:"done"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+type step struct {
+	name string
+	run  func(context.Context) error
+}
+
+// Warmup runs registered cold-start steps (compiling policies, building
+// matchers, opening connections) concurrently and flips readiness only once
+// all of them succeeded.
+type Warmup struct {
+	mu      sync.Mutex
+	steps   []step
+	results map[string]*StepResult
+	ready   atomic.Bool
+}
+
+func New() *Warmup { return &Warmup{results: map[string]*StepResult{}} }
+
+// Register adds a step; steps must be registered before Run.
+func (w *Warmup) Register(name string, run func(context.Context) error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.steps = append(w.steps, step{name: name, run: run})
+	w.results[name] = &StepResult{Name: name}
+}
+
+// Run executes all steps within deadline (0 means no deadline). Readiness
+// flips only if every step succeeds; the returned error joins step failures.
+func (w *Warmup) Run(ctx context.Context, deadline time.Duration) error {
+	if deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+	w.mu.Lock()
+	steps := append([]step(nil), w.steps...)
+	w.mu.Unlock()
+
+	errs := make([]error, len(steps))
+	var wg sync.WaitGroup
+	for i, s := range steps {
+		wg.Add(1)
+		go func(i int, s step) {
+			defer wg.Done()
+			start := time.Now()
+			err := runStep(ctx, s)
+			d := time.Since(start)
+			w.mu.Lock()
+			r := w.results[s.name]
+			r.Duration, r.Done = d, err == nil
+			if err != nil {
+				r.Error = err.Error()
+				errs[i] = fmt.Errorf("warmup %s: %w", s.name, err)
+			}
+			w.mu.Unlock()
+			slog.Info("warmup step finished", "step", s.name, "duration", d, "error", err)
+		}(i, s)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	w.ready.Store(true)
+	return nil
+}
+
+// runStep stops waiting for a step once ctx expires, even if the step ignores
+// its context.
+func runStep(ctx context.Context, s step) error {
+	done := make(chan error, 1)
+	go func() { done <- s.run(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Warmup) Ready() bool { return w.ready.Load() }
+
+// Results returns step outcomes in registration order.
+func (w *Warmup) Results() []StepResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := make([]StepResult, 0, len(w.steps))
+	for _, s := range w.steps {
+		out = append(out, *w.results[s.name])
+	}
+	return out
+}
+
+// Durations returns step durations in seconds keyed by step name, for
+// exporting as swarm_warmup_step_duration_seconds.
+func (w *Warmup) Durations() map[string]float64 {
+	out := map[string]float64{}
+	for _, r := range w.Results() {
+		out[r.Name] = r.Duration.Seconds()
+	}
+	return out
+}
+
+// ReadyHandler answers 200 once warmed up and 503 with step status before.
+func (w *Warmup) ReadyHandler(rw http.ResponseWriter, _ *http.Request) {
+	status := http.StatusOK
+	if !w.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(map[string]any{"ready": w.Ready(), "steps": w.Results()})
+}
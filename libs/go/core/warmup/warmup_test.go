@@ -0,0 +1,72 @@
+package warmup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ready(t *testing.T, w *Warmup) (int, []StepResult) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	w.ReadyHandler(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	var body struct {
+		Ready bool         `json:"ready"`
+		Steps []StepResult `json:"steps"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, body.Steps
+}
+
+func TestWarmupFlipsReadinessAfterAllSteps(t *testing.T) {
+	w := New()
+	release := make(chan struct{})
+	w.Register("compile", func(context.Context) error { <-release; return nil })
+	w.Register("connect", func(context.Context) error { return nil })
+	done := make(chan error)
+	go func() { done <- w.Run(context.Background(), 0) }()
+	if code, steps := ready(t, w); code != http.StatusServiceUnavailable || len(steps) != 2 || steps[0].Name != "compile" {
+		t.Fatalf("before: %d %+v", code, steps)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	code, steps := ready(t, w)
+	if code != http.StatusOK || !steps[0].Done || !steps[1].Done {
+		t.Fatalf("after: %d %+v", code, steps)
+	}
+	if d := w.Durations(); len(d) != 2 {
+		t.Fatalf("durations %v", d)
+	}
+}
+
+func TestWarmupFailuresAndDeadline(t *testing.T) {
+	w := New()
+	w.Register("ok", func(context.Context) error { return nil })
+	w.Register("broken", func(context.Context) error { return errors.New("no backend") })
+	// A step that ignores its context must not hold up the deadline.
+	w.Register("stuck", func(context.Context) error { time.Sleep(time.Second); return nil })
+	start := time.Now()
+	err := w.Run(context.Background(), 50*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("run took %v past its deadline", elapsed)
+	}
+	if err == nil || !strings.Contains(err.Error(), "warmup broken: no backend") || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err %v", err)
+	}
+	if w.Ready() {
+		t.Fatal("failed warmup must not be ready")
+	}
+	res := w.Results()
+	if !res[0].Done || res[1].Done || res[1].Error != "no backend" || res[2].Done {
+		t.Fatalf("results %+v", res)
+	}
+}
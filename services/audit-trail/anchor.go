@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Anchor records an RFC 3161 timestamp token obtained over the audit Merkle
+// root at a given log size.
+type Anchor struct {
+	Root    string    `json:"root"`
+	Size    uint64    `json:"size"`
+	TSA     string    `json:"tsa"`
+	GenTime time.Time `json:"gen_time"`
+	Serial  string    `json:"serial"`
+	Token   []byte    `json:"token"` // DER TimeStampToken (base64 in JSON)
+}
+
+// AnchorStore appends anchors to anchors.jsonl in the audit data dir.
+type AnchorStore struct {
+	mu      sync.RWMutex
+	path    string
+	anchors []Anchor
+}
+
+func OpenAnchorStore(dir string) (*AnchorStore, error) {
+	s := &AnchorStore{path: filepath.Join(dir, "anchors.jsonl")}
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		var a Anchor
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			return nil, fmt.Errorf("anchors.jsonl: %w", err)
+		}
+		s.anchors = append(s.anchors, a)
+	}
+	return s, sc.Err()
+}
+
+func (s *AnchorStore) Add(a Anchor) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	s.anchors = append(s.anchors, a)
+	return f.Sync()
+}
+
+func (s *AnchorStore) List() []Anchor {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Anchor(nil), s.anchors...)
+}
+
+func (s *AnchorStore) Last() (Anchor, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.anchors) == 0 {
+		return Anchor{}, false
+	}
+	return s.anchors[len(s.anchors)-1], true
+}
+
+// Anchorer periodically timestamps the current Merkle root with a TSA.
+type Anchorer struct {
+	log    *AuditLog
+	store  *AnchorStore
+	client *TSAClient
+	url    string
+	roots  *x509.CertPool
+}
+
+func NewAnchorer(log *AuditLog, store *AnchorStore, url string, roots *x509.CertPool) *Anchorer {
+	return &Anchorer{log: log, store: store, client: NewTSAClient(url, roots), url: url, roots: roots}
+}
+
+func (a *Anchorer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := a.AnchorNow(ctx); err != nil {
+			slog.Warn("tsa anchoring failed", "tsa", a.url, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// AnchorNow timestamps the current root unless it was already anchored.
+func (a *Anchorer) AnchorNow(ctx context.Context) (Anchor, error) {
+	root, size := a.log.Root()
+	if size == 0 {
+		return Anchor{}, nil
+	}
+	if last, ok := a.store.Last(); ok && last.Root == root {
+		return last, nil
+	}
+	digest, _ := hex.DecodeString(root)
+	token, info, err := a.client.Timestamp(ctx, digest)
+	if err != nil {
+		return Anchor{}, err
+	}
+	anchor := Anchor{Root: root, Size: size, TSA: a.url, GenTime: info.GenTime.UTC(), Serial: info.Serial.String(), Token: token}
+	if err := a.store.Add(anchor); err != nil {
+		return Anchor{}, err
+	}
+	slog.Info("audit root anchored", "root", root, "size", size, "gen_time", anchor.GenTime)
+	return anchor, nil
+}
+
+// AnchorProof is the answer to "did root R exist before time T".
+type AnchorProof struct {
+	Valid   bool      `json:"valid"`
+	Root    string    `json:"root"`
+	Size    uint64    `json:"size,omitempty"`
+	GenTime time.Time `json:"gen_time,omitempty"`
+	TSA     string    `json:"tsa,omitempty"`
+	Signer  string    `json:"signer,omitempty"`
+	// Consistent is true when the anchored root still matches the current log prefix.
+	Consistent bool   `json:"consistent"`
+	Error      string `json:"error,omitempty"`
+}
+
+var errNoTSARoots = errors.New("no TSA trust root configured (AUDIT_TSA_CA_FILE), anchors cannot be verified")
+
+// Prove finds an anchor for root whose token verifies and whose time is
+// strictly before the given time (zero before means any time). Without trust
+// roots a token only proves it was signed by the certificate it carries
+// itself, so no proof is reported valid.
+func (a *Anchorer) Prove(root string, before time.Time) AnchorProof {
+	proof := AnchorProof{Root: root}
+	if a.roots == nil {
+		proof.Error = errNoTSARoots.Error()
+		return proof
+	}
+	digest, err := hex.DecodeString(root)
+	if err != nil {
+		proof.Error = "root must be hex"
+		return proof
+	}
+	for _, an := range a.store.List() {
+		if an.Root != root {
+			continue
+		}
+		info, err := VerifyToken(an.Token, digest, a.roots)
+		if err != nil {
+			proof.Error = err.Error()
+			continue
+		}
+		if !before.IsZero() && !info.GenTime.Before(before) {
+			proof.Error = "earliest timestamp is not before requested time"
+			continue
+		}
+		cur, ok := a.log.RootAt(an.Size)
+		return AnchorProof{
+			Valid: true, Root: root, Size: an.Size, GenTime: info.GenTime.UTC(), TSA: an.TSA,
+			Signer: info.Signer.Subject.String(), Consistent: ok && cur == root,
+		}
+	}
+	if proof.Error == "" {
+		proof.Error = "no anchor for root"
+	}
+	return proof
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const manifestName = "manifest.json"
+
+// keyringName is the backup file holding the wrapped data keys of an
+// encrypted log; restores write it to the keyring path, not the data dir.
+const keyringName = "keyring.json"
+
+// BackupManifest describes one consistent snapshot of the audit data dir.
+type BackupManifest struct {
+	ID        string       `json:"id"`
+	CreatedAt time.Time    `json:"created_at"`
+	Entries   uint64       `json:"entries"`
+	Root      string       `json:"root"`
+	Files     []BackupFile `json:"files"`
+}
+
+type BackupFile struct {
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// BackupSink stores backup objects under a key ("<backup id>/<file>").
+type BackupSink interface {
+	Put(ctx context.Context, key string, data []byte) error
+}
+
+// BackupSource reads backup objects by name relative to one backup.
+type BackupSource interface {
+	Get(ctx context.Context, name string) ([]byte, error)
+}
+
+type backupStore interface {
+	BackupSink
+	BackupSource
+}
+
+// openBackupStore resolves file:///path (or a plain path) to a local/mounted
+// directory and http(s)://... to an object store accepting PUT and GET (e.g.
+// a bucket endpoint behind a signing proxy).
+func openBackupStore(target string) (backupStore, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file", "":
+		return dirSink{root: u.Path}, nil
+	case "http", "https":
+		return httpSink{base: strings.TrimRight(target, "/"), client: &http.Client{Timeout: time.Minute}}, nil
+	}
+	return nil, fmt.Errorf("unsupported backup target scheme %q", u.Scheme)
+}
+
+// NewBackupSink builds a sink from AUDIT_BACKUP_TARGET.
+func NewBackupSink(target string) (BackupSink, error) { return openBackupStore(target) }
+
+// NewBackupSource opens one backup from AUDIT_RESTORE_FROM, which is the
+// backup target of any supported scheme followed by /<backup id>.
+func NewBackupSource(location string) (BackupSource, error) { return openBackupStore(location) }
+
+type dirSink struct{ root string }
+
+func (s dirSink) Put(_ context.Context, key string, data []byte) error {
+	path := filepath.Join(s.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s dirSink) Get(_ context.Context, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.root, filepath.FromSlash(name)))
+}
+
+type httpSink struct {
+	base   string
+	client *http.Client
+}
+
+func (s httpSink) Put(ctx context.Context, key string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.base+"/"+key, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("put %s: status %d", key, resp.StatusCode)
+	}
+	return nil
+}
+
+func (s httpSink) Get(ctx context.Context, name string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.base+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("get %s: status %d", name, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// snapshot returns a point-in-time copy of the log and its exported
+// keyring, if any. Entries are immutable once appended, so a shallow copy
+// under the read lock is consistent; keys are exported under the same lock
+// so every entry in the copy can be decrypted with them.
+func (l *AuditLog) snapshot() ([]Entry, []byte, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	entries := append([]Entry(nil), l.entries...)
+	if l.keys == nil {
+		return entries, nil, nil
+	}
+	keyring, err := l.keys.Export()
+	return entries, keyring, err
+}
+
+// WriteBackup re-encodes a snapshot of the log into segment files plus the
+// anchors file and, for an encrypted log, the wrapped keyring, and uploads
+// them, writing the manifest last so a backup is only usable once complete.
+// Restoring an encrypted backup still needs the master keys.
+func WriteBackup(ctx context.Context, sink BackupSink, log *AuditLog, anchors *AnchorStore) (BackupManifest, error) {
+	entries, keyring, err := log.snapshot()
+	if err != nil {
+		return BackupManifest{}, fmt.Errorf("export keyring: %w", err)
+	}
+	anchorList := anchors.List()
+	// Nanoseconds keep IDs unique for backups taken in the same second while
+	// still sorting by time.
+	now := time.Now().UTC()
+	m := BackupManifest{ID: now.Format("20060102T150405.000000000Z"), CreatedAt: now, Entries: uint64(len(entries))}
+	m.Root, _ = log.RootAt(m.Entries)
+
+	put := func(name string, data []byte) error {
+		sum := sha256.Sum256(data)
+		if err := sink.Put(ctx, m.ID+"/"+name, data); err != nil {
+			return err
+		}
+		m.Files = append(m.Files, BackupFile{Name: name, Size: len(data), SHA256: hex.EncodeToString(sum[:])})
+		return nil
+	}
+	for start := 0; start < len(entries); start += log.segmentSize {
+		var buf bytes.Buffer
+		for _, e := range entries[start:min(len(entries), start+log.segmentSize)] {
+			b, err := json.Marshal(e)
+			if err != nil {
+				return m, err
+			}
+			buf.Write(append(b, '\n'))
+		}
+		if err := put(fmt.Sprintf("segment-%06d.jsonl", start/log.segmentSize+1), buf.Bytes()); err != nil {
+			return m, err
+		}
+	}
+	var buf bytes.Buffer
+	for _, a := range anchorList {
+		b, err := json.Marshal(a)
+		if err != nil {
+			return m, err
+		}
+		buf.Write(append(b, '\n'))
+	}
+	if err := put("anchors.jsonl", buf.Bytes()); err != nil {
+		return m, err
+	}
+	if keyring != nil {
+		if err := put(keyringName, keyring); err != nil {
+			return m, err
+		}
+	}
+	mb, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return m, err
+	}
+	return m, sink.Put(ctx, m.ID+"/"+manifestName, mb)
+}
+
+var errRestoreRefused = errors.New("restore refused: data dir already contains audit segments")
+
+var errKeyringConflict = errors.New("restore refused: keyring path holds different keys")
+
+// restoredMarker records the manifest of the backup a data dir was restored
+// from; it is written after every restored file is in place.
+const restoredMarker = "restored.json"
+
+// RestoreBackup restores the backup read from src into a data dir without
+// audit segments. Every file is staged in a temporary directory and checked against
+// the manifest before any of them is moved into dataDir, so a bad backup
+// leaves dataDir untouched. Restoring the backup named in restored.json again
+// is a no-op, so AUDIT_RESTORE_FROM can stay set across restarts; any other
+// restore over existing segments is refused. The keyring of an encrypted
+// backup goes to keyringPath, which must be absent or already hold the same
+// keys. The caller must reopen the log and compare its root at the manifest
+// size with the returned manifest.
+func RestoreBackup(ctx context.Context, src BackupSource, dataDir, keyringPath string) (BackupManifest, error) {
+	var m BackupManifest
+	mb, err := src.Get(ctx, manifestName)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(mb, &m); err != nil {
+		return m, fmt.Errorf("manifest: %w", err)
+	}
+	existing, _ := filepath.Glob(filepath.Join(dataDir, "segment-*.jsonl"))
+	if len(existing) > 0 {
+		var prev BackupManifest
+		if b, err := os.ReadFile(filepath.Join(dataDir, restoredMarker)); err == nil && json.Unmarshal(b, &prev) == nil && prev.ID == m.ID {
+			return m, nil
+		}
+		return m, errRestoreRefused
+	}
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return m, err
+	}
+	stage, err := os.MkdirTemp(dataDir, ".restore-")
+	if err != nil {
+		return m, err
+	}
+	defer os.RemoveAll(stage)
+	var keyring []byte
+	for _, f := range m.Files {
+		if f.Name != filepath.Base(f.Name) || f.Name == restoredMarker {
+			return m, fmt.Errorf("manifest: invalid file name %q", f.Name)
+		}
+		data, err := src.Get(ctx, f.Name)
+		if err != nil {
+			return m, err
+		}
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != f.SHA256 || len(data) != f.Size {
+			return m, fmt.Errorf("backup file %s fails checksum", f.Name)
+		}
+		if f.Name == keyringName {
+			keyring = data
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(stage, f.Name), data, 0o600); err != nil {
+			return m, err
+		}
+	}
+	// The keyring goes first: a restore interrupted after it is retried
+	// against the same keys, while segments without their keys would be
+	// unreadable.
+	if keyring != nil {
+		if err := restoreKeyring(keyringPath, keyring); err != nil {
+			return m, err
+		}
+	}
+	for _, f := range m.Files {
+		if f.Name == keyringName {
+			continue
+		}
+		if err := os.Rename(filepath.Join(stage, f.Name), filepath.Join(dataDir, f.Name)); err != nil {
+			return m, err
+		}
+	}
+	marker := filepath.Join(dataDir, restoredMarker)
+	if err := os.WriteFile(marker+".tmp", mb, 0o600); err != nil {
+		return m, err
+	}
+	return m, os.Rename(marker+".tmp", marker)
+}
+
+// restoreKeyring writes a backed up keyring to path unless path already
+// holds one. The same keys are accepted so an interrupted restore can be
+// retried; different keys are never overwritten.
+func restoreKeyring(path string, data []byte) error {
+	if path == "" {
+		return errors.New("backup contains a keyring but no keyring path is set")
+	}
+	if existing, err := os.ReadFile(path); err == nil {
+		if !bytes.Equal(existing, data) {
+			return errKeyringConflict
+		}
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
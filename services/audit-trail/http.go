@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	envelope "github.com/swarmguard/libs/go/core/envelope"
+)
+
+const maxPageSize = 1000
+
+func registerRoutes(mux *http.ServeMux, log *AuditLog) {
+	mux.HandleFunc("POST /v1/entries", func(w http.ResponseWriter, r *http.Request) {
+		var e Entry
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil || e.Producer == "" || e.Action == "" {
+			writeError(w, http.StatusBadRequest, "producer and action required")
+			return
+		}
+		stored, err := log.Append(Entry{
+			Stream: e.Stream, Tenant: e.Tenant, Producer: e.Producer, Actor: e.Actor,
+			Action: e.Action, Resource: e.Resource, Data: e.Data,
+		})
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, stored)
+	})
+	mux.HandleFunc("GET /v1/entries", func(w http.ResponseWriter, r *http.Request) {
+		from, limit := pageParams(r)
+		writeJSON(w, http.StatusOK, log.Entries(from, limit))
+	})
+	mux.HandleFunc("GET /v1/root", func(w http.ResponseWriter, _ *http.Request) {
+		root, size := log.Root()
+		writeJSON(w, http.StatusOK, map[string]any{"root": root, "size": size})
+	})
+	mux.HandleFunc("GET /v1/verify", func(w http.ResponseWriter, _ *http.Request) {
+		writeVerify(w, log.Verify())
+	})
+	mux.HandleFunc("GET /v1/streams", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, log.Streams())
+	})
+	mux.HandleFunc("GET /v1/streams/{stream}/entries", func(w http.ResponseWriter, r *http.Request) {
+		from, limit := pageParams(r)
+		entries, ok := log.StreamEntries(r.PathValue("stream"), from, limit)
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown stream")
+			return
+		}
+		writeJSON(w, http.StatusOK, entries)
+	})
+	mux.HandleFunc("GET /v1/streams/{stream}/root", func(w http.ResponseWriter, r *http.Request) {
+		root, size, ok := log.StreamRoot(r.PathValue("stream"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown stream")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"stream": r.PathValue("stream"), "root": root, "size": size})
+	})
+	mux.HandleFunc("GET /v1/streams/{stream}/verify", func(w http.ResponseWriter, r *http.Request) {
+		ok, err := log.VerifyStream(r.PathValue("stream"))
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown stream")
+			return
+		}
+		writeVerify(w, err)
+	})
+}
+
+// registerAnchorRoutes exposes TSA anchors; anchorer is nil when no TSA is configured.
+func registerAnchorRoutes(mux *http.ServeMux, anchors *AnchorStore, anchorer *Anchorer) {
+	mux.HandleFunc("GET /v1/anchors", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, anchors.List())
+	})
+	if anchorer == nil {
+		return
+	}
+	mux.HandleFunc("POST /v1/anchors", func(w http.ResponseWriter, r *http.Request) {
+		a, err := anchorer.AnchorNow(r.Context())
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, a)
+	})
+	// GET /v1/anchors/verify?root=<hex>&before=<RFC3339>
+	mux.HandleFunc("GET /v1/anchors/verify", func(w http.ResponseWriter, r *http.Request) {
+		var before time.Time
+		if v := r.URL.Query().Get("before"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "before must be RFC3339")
+				return
+			}
+			before = t
+		}
+		proof := anchorer.Prove(r.URL.Query().Get("root"), before)
+		status := http.StatusOK
+		if !proof.Valid {
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, proof)
+	})
+}
+
+func pageParams(r *http.Request) (uint64, int) {
+	from, _ := strconv.ParseUint(r.URL.Query().Get("from"), 10, 64)
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		limit = maxPageSize
+	}
+	return from, limit
+}
+
+func writeVerify(w http.ResponseWriter, err error) {
+	if err != nil {
+		writeJSON(w, http.StatusConflict, map[string]any{"valid": false, "error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"valid": true})
+}
+
+// registerKeyRoutes exposes data key rotation. Entries are immutable, so a
+// rotated key only applies to new entries; older key versions stay in the
+// keyring for reads.
+func registerKeyRoutes(mux *http.ServeMux, keys *envelope.Keyring) {
+	mux.HandleFunc("POST /internal/keys/rotate", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Tenant string `json:"tenant"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Tenant == "" {
+			writeError(w, http.StatusBadRequest, "tenant required")
+			return
+		}
+		version, err := keys.Rotate(req.Tenant)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"tenant": req.Tenant, "version": version})
+	})
+	mux.HandleFunc("POST /internal/keys/rewrap", func(w http.ResponseWriter, _ *http.Request) {
+		n, err := keys.Rewrap()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"rewrapped": n})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
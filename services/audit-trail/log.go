@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	envelope "github.com/swarmguard/libs/go/core/envelope"
+)
+
+const defaultStream = "default"
+
+// Entry is one audit record. It is linked into two hash chains: the global
+// chain (Seq/PrevHash) across all producers, and a stream chain
+// (StreamSeq/StreamPrevHash) scoped to one resource or tenant so per-stream
+// ordering can be proven without trusting the interleaving of other producers.
+type Entry struct {
+	Seq            uint64          `json:"seq"`
+	Stream         string          `json:"stream"`
+	Tenant         string          `json:"tenant,omitempty"`
+	StreamSeq      uint64          `json:"stream_seq"`
+	Timestamp      time.Time       `json:"timestamp"`
+	Producer       string          `json:"producer"`
+	Actor          string          `json:"actor,omitempty"`
+	Action         string          `json:"action"`
+	Resource       string          `json:"resource,omitempty"`
+	Data           json.RawMessage `json:"data,omitempty"`
+	PrevHash       string          `json:"prev_hash"`
+	StreamPrevHash string          `json:"stream_prev_hash"`
+	Hash           string          `json:"hash"`
+}
+
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type streamState struct {
+	seq      uint64
+	lastHash string
+	indices  []int // positions in AuditLog.entries
+}
+
+// StreamInfo summarizes one stream for listing endpoints.
+type StreamInfo struct {
+	Stream   string `json:"stream"`
+	Seq      uint64 `json:"seq"`
+	HeadHash string `json:"head_hash"`
+}
+
+// AuditLog is an append-only log persisted as JSONL segment files of at most
+// segmentSize entries each (segment-000001.jsonl, ...).
+type AuditLog struct {
+	mu          sync.RWMutex
+	dir         string
+	segmentSize int
+	entries     []Entry
+	streams     map[string]*streamState
+	seg         *os.File
+	segCount    int
+	keys        *envelope.Keyring // encrypts Data at rest when set
+	failed      error             // set when a failed write could not be undone
+}
+
+func OpenAuditLog(dir string, segmentSize int) (*AuditLog, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	l := &AuditLog{dir: dir, segmentSize: segmentSize, streams: map[string]*streamState{}}
+	if err := l.replay(); err != nil {
+		return nil, err
+	}
+	if err := l.Verify(); err != nil {
+		return nil, fmt.Errorf("audit log corrupt: %w", err)
+	}
+	return l, nil
+}
+
+func (l *AuditLog) segmentFiles() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(l.dir, "segment-*.jsonl"))
+	sort.Strings(files)
+	return files, err
+}
+
+func (l *AuditLog) replay() error {
+	files, err := l.segmentFiles()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := l.replaySegment(f); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+	}
+	return nil
+}
+
+func (l *AuditLog) replaySegment(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return err
+		}
+		l.index(e)
+	}
+	return sc.Err()
+}
+
+func (l *AuditLog) index(e Entry) {
+	st := l.streams[e.Stream]
+	if st == nil {
+		st = &streamState{}
+		l.streams[e.Stream] = st
+	}
+	st.seq = e.StreamSeq
+	st.lastHash = e.Hash
+	st.indices = append(st.indices, len(l.entries))
+	l.entries = append(l.entries, e)
+}
+
+// SetKeyring enables envelope encryption of Data for entries appended from
+// now on. Hashes cover the ciphertext, so chains and proofs verify without
+// the keys, and readers get Data decrypted.
+func (l *AuditLog) SetKeyring(k *envelope.Keyring) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.keys = k
+}
+
+// entryAAD binds encrypted Data to its position in the stream chain.
+func entryAAD(e Entry) []byte {
+	return []byte(e.Stream + "\x00" + strconv.FormatUint(e.StreamSeq, 10))
+}
+
+func (l *AuditLog) sealLocked(e *Entry) error {
+	if l.keys == nil || len(e.Data) == 0 {
+		return nil
+	}
+	tenant := e.Tenant
+	if tenant == "" {
+		tenant = defaultStream
+	}
+	enc, err := l.keys.Encrypt(tenant, e.Data, entryAAD(*e))
+	if err != nil {
+		return fmt.Errorf("encrypt data: %w", err)
+	}
+	e.Data, err = json.Marshal(enc)
+	return err
+}
+
+// openLocked returns e with Data decrypted. Entries whose key is unavailable
+// keep the ciphertext.
+func (l *AuditLog) openLocked(e Entry) Entry {
+	var enc string
+	if l.keys == nil || len(e.Data) == 0 || e.Data[0] != '"' || json.Unmarshal(e.Data, &enc) != nil || !envelope.IsEncrypted(enc) {
+		return e
+	}
+	if plain, err := l.keys.Decrypt(enc, entryAAD(e)); err == nil {
+		e.Data = plain
+	}
+	return e
+}
+
+// Append assigns global and stream sequence numbers, links both chains and
+// persists the entry before it becomes visible to readers.
+func (l *AuditLog) Append(e Entry) (Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e.Stream == "" {
+		e.Stream = e.Resource
+	}
+	if e.Stream == "" {
+		e.Stream = defaultStream
+	}
+	if len(e.Data) > 0 {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, e.Data); err != nil {
+			return Entry{}, fmt.Errorf("data: %w", err)
+		}
+		e.Data = buf.Bytes()
+	}
+	e.Timestamp = time.Now().UTC()
+	e.Seq = uint64(len(l.entries)) + 1
+	if n := len(l.entries); n > 0 {
+		e.PrevHash = l.entries[n-1].Hash
+	}
+	e.StreamSeq = 1
+	e.StreamPrevHash = ""
+	if st := l.streams[e.Stream]; st != nil {
+		e.StreamSeq = st.seq + 1
+		e.StreamPrevHash = st.lastHash
+	}
+	plain := e.Data
+	if err := l.sealLocked(&e); err != nil {
+		return Entry{}, err
+	}
+	h, err := e.computeHash()
+	if err != nil {
+		return Entry{}, err
+	}
+	e.Hash = h
+	if err := l.write(e); err != nil {
+		return Entry{}, err
+	}
+	l.index(e)
+	e.Data = plain
+	return e, nil
+}
+
+// write appends e to the current segment. A failed or partial write, or a
+// failed Sync, is truncated away so the segment never holds an entry that is
+// not indexed; if that fails too the log refuses further appends, since the
+// next entry would reuse the Seq or follow a torn line.
+func (l *AuditLog) write(e Entry) error {
+	if l.failed != nil {
+		return l.failed
+	}
+	if l.seg == nil || l.segCount >= l.segmentSize {
+		if err := l.rotate(e.Seq); err != nil {
+			return err
+		}
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	info, err := l.seg.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = l.seg.Write(append(b, '\n'))
+	if err == nil {
+		err = l.seg.Sync()
+	}
+	if err != nil {
+		if terr := l.seg.Truncate(info.Size()); terr != nil {
+			l.failed = fmt.Errorf("%w: %s may hold a partial entry: %v", errLogFailed, l.seg.Name(), terr)
+		} else if serr := l.seg.Sync(); serr != nil {
+			l.failed = fmt.Errorf("%w: %s may hold a partial entry: %v", errLogFailed, l.seg.Name(), serr)
+		}
+		return err
+	}
+	l.segCount++
+	return nil
+}
+
+// rotate opens the segment that entry seq belongs to, so restarts continue
+// appending to the partially filled last segment.
+func (l *AuditLog) rotate(seq uint64) error {
+	if l.seg != nil {
+		_ = l.seg.Close()
+	}
+	idx := (seq-1)/uint64(l.segmentSize) + 1
+	path := filepath.Join(l.dir, fmt.Sprintf("segment-%06d.jsonl", idx))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	l.seg = f
+	l.segCount = int((seq - 1) % uint64(l.segmentSize))
+	return nil
+}
+
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seg == nil {
+		return nil
+	}
+	return l.seg.Close()
+}
+
+// Entries returns up to limit entries with Seq >= from.
+func (l *AuditLog) Entries(from uint64, limit int) []Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if from == 0 {
+		from = 1
+	}
+	if from > uint64(len(l.entries)) {
+		return []Entry{}
+	}
+	end := min(len(l.entries), int(from-1)+limit)
+	out := make([]Entry, 0, end-int(from-1))
+	for _, e := range l.entries[from-1 : end] {
+		out = append(out, l.openLocked(e))
+	}
+	return out
+}
+
+// StreamEntries returns up to limit entries of one stream with StreamSeq >= from.
+func (l *AuditLog) StreamEntries(stream string, from uint64, limit int) ([]Entry, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	st, ok := l.streams[stream]
+	if !ok {
+		return nil, false
+	}
+	if from == 0 {
+		from = 1
+	}
+	if from > uint64(len(st.indices)) {
+		return []Entry{}, true
+	}
+	out := []Entry{}
+	for i := int(from - 1); i < len(st.indices) && len(out) < limit; i++ {
+		out = append(out, l.openLocked(l.entries[st.indices[i]]))
+	}
+	return out, true
+}
+
+func (l *AuditLog) Streams() []StreamInfo {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	out := make([]StreamInfo, 0, len(l.streams))
+	for name, st := range l.streams {
+		out = append(out, StreamInfo{Stream: name, Seq: st.seq, HeadHash: st.lastHash})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Stream < out[j].Stream })
+	return out
+}
+
+// Root returns the Merkle root over all entry hashes and the log size.
+func (l *AuditLog) Root() (string, uint64) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	leaves := make([][32]byte, len(l.entries))
+	for i, e := range l.entries {
+		leaves[i] = leafHash(e.Hash)
+	}
+	r := merkleRoot(leaves)
+	return hex.EncodeToString(r[:]), uint64(len(l.entries))
+}
+
+// RootAt returns the Merkle root over the first size entries, so a root
+// anchored earlier can be checked against the current log prefix.
+func (l *AuditLog) RootAt(size uint64) (string, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if size > uint64(len(l.entries)) {
+		return "", false
+	}
+	leaves := make([][32]byte, size)
+	for i := range leaves {
+		leaves[i] = leafHash(l.entries[i].Hash)
+	}
+	r := merkleRoot(leaves)
+	return hex.EncodeToString(r[:]), true
+}
+
+// StreamRoot returns the Merkle root over one stream's entry hashes.
+func (l *AuditLog) StreamRoot(stream string) (string, uint64, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	st, ok := l.streams[stream]
+	if !ok {
+		return "", 0, false
+	}
+	leaves := make([][32]byte, len(st.indices))
+	for i, idx := range st.indices {
+		leaves[i] = leafHash(l.entries[idx].Hash)
+	}
+	r := merkleRoot(leaves)
+	return hex.EncodeToString(r[:]), st.seq, true
+}
+
+func leafHash(h string) [32]byte {
+	var buf [33]byte
+	b, _ := hex.DecodeString(h)
+	copy(buf[1:], b)
+	return sha256.Sum256(buf[:])
+}
+
+var (
+	errChainBroken = errors.New("hash chain broken")
+	errLogFailed   = errors.New("audit log failed, restart required")
+)
+
+// Verify recomputes every entry hash and checks both the global and all
+// stream chains.
+func (l *AuditLog) Verify() error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	prev := ""
+	for i, e := range l.entries {
+		if e.Seq != uint64(i)+1 || e.PrevHash != prev {
+			return fmt.Errorf("%w at seq %d", errChainBroken, i+1)
+		}
+		if err := checkHash(e); err != nil {
+			return err
+		}
+		prev = e.Hash
+	}
+	for name := range l.streams {
+		if err := l.verifyStreamLocked(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyStream checks only the chain of one stream.
+func (l *AuditLog) VerifyStream(stream string) (bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if _, ok := l.streams[stream]; !ok {
+		return false, nil
+	}
+	return true, l.verifyStreamLocked(stream)
+}
+
+func (l *AuditLog) verifyStreamLocked(stream string) error {
+	prev := ""
+	for i, idx := range l.streams[stream].indices {
+		e := l.entries[idx]
+		if e.StreamSeq != uint64(i)+1 || e.StreamPrevHash != prev {
+			return fmt.Errorf("%w: stream %q at stream_seq %d", errChainBroken, stream, i+1)
+		}
+		if err := checkHash(e); err != nil {
+			return err
+		}
+		prev = e.Hash
+	}
+	return nil
+}
+
+func checkHash(e Entry) error {
+	h, err := e.computeHash()
+	if err != nil {
+		return err
+	}
+	if h != e.Hash {
+		return fmt.Errorf("%w: hash mismatch at seq %d", errChainBroken, e.Seq)
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	envelope "github.com/swarmguard/libs/go/core/envelope"
+)
+
+func TestStreamChainsSurviveReopen(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenAuditLog(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"tenant:a", "tenant:b", "tenant:a", "tenant:a", "tenant:b"} {
+		if _, err := l.Append(Entry{Stream: s, Producer: "test", Action: "write", Data: json.RawMessage(`{ "k": 1 }`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rootBefore, _ := l.Root()
+	l.Close()
+
+	l, err = OpenAuditLog(dir, 2)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer l.Close()
+	if root, size := l.Root(); root != rootBefore || size != 5 {
+		t.Fatalf("root changed across reopen: %s/%d vs %s", root, size, rootBefore)
+	}
+	entries, _ := l.StreamEntries("tenant:a", 1, 10)
+	if len(entries) != 3 || entries[2].StreamSeq != 3 || entries[2].Seq != 4 {
+		t.Fatalf("unexpected stream entries: %+v", entries)
+	}
+	for _, from := range []uint64{4, 1<<63 + 1, ^uint64(0)} {
+		if entries, ok := l.StreamEntries("tenant:a", from, 10); !ok || len(entries) != 0 {
+			t.Fatalf("from=%d: %+v", from, entries)
+		}
+	}
+	e, err := l.Append(Entry{Stream: "tenant:b", Producer: "test", Action: "write"})
+	if err != nil || e.StreamSeq != 3 || e.Seq != 6 {
+		t.Fatalf("append after reopen: %+v %v", e, err)
+	}
+	if err := l.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	l.entries[1].Action = "tampered"
+	if ok, err := l.VerifyStream("tenant:b"); !ok || err == nil {
+		t.Fatal("tampering not detected in stream chain")
+	}
+}
+
+func TestBackupRestoreRoundTrip(t *testing.T) {
+	l, err := OpenAuditLog(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := 0; i < 5; i++ {
+		if _, err := l.Append(Entry{Producer: "test", Action: "write"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	anchors, _ := OpenAnchorStore(t.TempDir())
+	ctx, backupDir := context.Background(), t.TempDir()
+	m, err := WriteBackup(ctx, dirSink{root: backupDir}, l, anchors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dataDir := t.TempDir()
+	if _, err := RestoreBackup(ctx, dirSink{root: filepath.Join(backupDir, m.ID)}, dataDir, ""); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenAuditLog(dataDir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if root, size := restored.Root(); root != m.Root || size != m.Entries {
+		t.Fatalf("restored root %s/%d, manifest %s/%d", root, size, m.Root, m.Entries)
+	}
+	if _, err := RestoreBackup(ctx, dirSink{root: filepath.Join(backupDir, m.ID)}, dataDir, ""); err != nil {
+		t.Fatalf("repeating the same restore: %v", err)
+	}
+
+	// A corrupt file fails the restore before anything reaches the data dir.
+	if _, err := l.Append(Entry{Producer: "test", Action: "write"}); err != nil {
+		t.Fatal(err)
+	}
+	m2, err := WriteBackup(ctx, dirSink{root: backupDir}, l, anchors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m2.ID == m.ID {
+		t.Fatalf("backups share ID %s", m.ID)
+	}
+	if _, err := RestoreBackup(ctx, dirSink{root: filepath.Join(backupDir, m2.ID)}, dataDir, ""); err != errRestoreRefused {
+		t.Fatalf("restore of another backup over existing data: %v", err)
+	}
+	last := filepath.Join(backupDir, m2.ID, m2.Files[len(m2.Files)-2].Name)
+	if err := os.WriteFile(last, []byte("{}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	empty := t.TempDir()
+	if _, err := RestoreBackup(ctx, dirSink{root: filepath.Join(backupDir, m2.ID)}, empty, ""); err == nil {
+		t.Fatal("corrupt backup restored")
+	}
+	if left, _ := os.ReadDir(empty); len(left) != 0 {
+		t.Fatalf("failed restore left %d files in the data dir", len(left))
+	}
+}
+
+func TestRestoreFromHTTPTarget(t *testing.T) {
+	objects := map[string][]byte{}
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write(data)
+		}
+	}))
+	defer srv.Close()
+	l, err := OpenAuditLog(t.TempDir(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := l.Append(Entry{Producer: "test", Action: "write"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	anchors, _ := OpenAnchorStore(t.TempDir())
+	sink, err := NewBackupSink(srv.URL + "/backups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := WriteBackup(context.Background(), sink, l, anchors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewBackupSource(srv.URL + "/backups/" + m.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dataDir := t.TempDir()
+	if _, err := RestoreBackup(context.Background(), src, dataDir, ""); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenAuditLog(dataDir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	if root, size := restored.Root(); root != m.Root || size != m.Entries {
+		t.Fatalf("restored root %s/%d, manifest %s/%d", root, size, m.Root, m.Entries)
+	}
+}
+
+func TestEncryptedDataVerifiesAndDecrypts(t *testing.T) {
+	dir := t.TempDir()
+	keys, err := envelope.Open(filepath.Join(dir, "keyring.json"), "k1:"+base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := OpenAuditLog(filepath.Join(dir, "log"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.SetKeyring(keys)
+	if _, err := l.Append(Entry{Stream: "s", Tenant: "acme", Producer: "test", Action: "write", Data: json.RawMessage(`{"secret":"x"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Rotate("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Append(Entry{Stream: "s", Tenant: "acme", Producer: "test", Action: "write", Data: json.RawMessage(`{"secret":"y"}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Verify(); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if raw := string(l.entries[0].Data); !strings.Contains(raw, envelope.Prefix) {
+		t.Fatalf("data stored in plaintext: %s", raw)
+	}
+	got := l.Entries(1, 10)
+	if string(got[0].Data) != `{"secret":"x"}` || string(got[1].Data) != `{"secret":"y"}` {
+		t.Fatalf("decrypted data = %s, %s", got[0].Data, got[1].Data)
+	}
+}
+
+func TestEncryptedBackupRestoresKeyring(t *testing.T) {
+	master := "k1:" + base64.StdEncoding.EncodeToString(make([]byte, 32))
+	dir := t.TempDir()
+	keys, err := envelope.Open(filepath.Join(dir, "keyring.json"), master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := OpenAuditLog(filepath.Join(dir, "log"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	l.SetKeyring(keys)
+	for _, tenant := range []string{"acme", "globex", "acme"} {
+		if _, err := l.Append(Entry{Stream: tenant, Tenant: tenant, Producer: "test", Action: "write", Data: json.RawMessage(`{"secret":"` + tenant + `"}`)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	anchors, _ := OpenAnchorStore(t.TempDir())
+	ctx, backupDir := context.Background(), t.TempDir()
+	m, err := WriteBackup(ctx, dirSink{root: backupDir}, l, anchors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := dirSink{root: filepath.Join(backupDir, m.ID)}
+
+	// A keyring path holding other keys is never overwritten.
+	other := t.TempDir()
+	if err := os.WriteFile(filepath.Join(other, "keyring.json"), []byte("[]"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestoreBackup(ctx, src, filepath.Join(other, "log"), filepath.Join(other, "keyring.json")); err != errKeyringConflict {
+		t.Fatalf("restore over other keys: %v", err)
+	}
+	if segs, _ := filepath.Glob(filepath.Join(other, "log", "segment-*.jsonl")); len(segs) != 0 {
+		t.Fatalf("refused restore left %d segments", len(segs))
+	}
+
+	fresh := t.TempDir()
+	keyringPath := filepath.Join(fresh, "keys", "keyring.json")
+	if _, err := RestoreBackup(ctx, src, filepath.Join(fresh, "log"), keyringPath); err != nil {
+		t.Fatal(err)
+	}
+	restoredKeys, err := envelope.Open(keyringPath, master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := OpenAuditLog(filepath.Join(fresh, "log"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	restored.SetKeyring(restoredKeys)
+	got := restored.Entries(1, 10)
+	if len(got) != 3 {
+		t.Fatalf("restored %d entries", len(got))
+	}
+	for i, tenant := range []string{"acme", "globex", "acme"} {
+		if want := `{"secret":"` + tenant + `"}`; string(got[i].Data) != want {
+			t.Fatalf("entry %d data = %s, want %s", i+1, got[i].Data, want)
+		}
+	}
+	if _, err := RestoreBackup(ctx, src, filepath.Join(fresh, "log"), keyringPath); err != nil {
+		t.Fatalf("repeating the same restore: %v", err)
+	}
+}
+
+func TestFailedWriteStopsAppends(t *testing.T) {
+	dir := t.TempDir()
+	l, err := OpenAuditLog(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Append(Entry{Producer: "test", Action: "write"}); err != nil {
+		t.Fatal(err)
+	}
+	// A read-only handle fails both the write and the truncate undoing it.
+	ro, err := os.Open(l.seg.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.seg.Close()
+	l.seg = ro
+	if _, err := l.Append(Entry{Producer: "test", Action: "write"}); err == nil {
+		t.Fatal("append on a failing segment succeeded")
+	}
+	if _, err := l.Append(Entry{Producer: "test", Action: "write"}); !errors.Is(err, errLogFailed) {
+		t.Fatalf("append after an unrecoverable write: %v", err)
+	}
+	l.Close()
+	l, err = OpenAuditLog(dir, 10)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer l.Close()
+	if _, size := l.Root(); size != 1 {
+		t.Fatalf("size = %d, want 1", size)
+	}
+}
@@ -1,13 +1,169 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
+	deprecation "github.com/swarmguard/libs/go/core/deprecation"
+	envelope "github.com/swarmguard/libs/go/core/envelope"
 	sloglog "github.com/swarmguard/libs/go/core/logging"
 )
 
 func main() {
 	sloglog.Init("audit-trail")
 	slog.Info("starting service")
-	// TODO: Append-only log & Merkle root chain
+
+	segmentSize, err := strconv.Atoi(getenv("AUDIT_SEGMENT_SIZE", "10000"))
+	if err != nil || segmentSize <= 0 {
+		segmentSize = 10000
+	}
+	dataDir := getenv("AUDIT_DATA_DIR", "data/audit")
+	keyringPath := getenv("AUDIT_KEYRING_PATH", "data/audit-keyring.json")
+	var restored *BackupManifest
+	// A refused restore is fatal too: serving a data dir that is not the
+	// requested backup would skip the manifest root check below.
+	if src := os.Getenv("AUDIT_RESTORE_FROM"); src != "" {
+		source, err := NewBackupSource(src)
+		if err != nil {
+			slog.Error("invalid AUDIT_RESTORE_FROM", "error", err)
+			os.Exit(1)
+		}
+		m, err := RestoreBackup(context.Background(), source, dataDir, keyringPath)
+		if err != nil {
+			slog.Error("restore failed", "from", src, "error", err)
+			os.Exit(1)
+		}
+		restored = &m
+	}
+	auditLog, err := OpenAuditLog(dataDir, segmentSize)
+	if err != nil {
+		slog.Error("audit log open failed", "error", err)
+		os.Exit(1)
+	}
+	defer auditLog.Close()
+	var keys *envelope.Keyring
+	if spec := os.Getenv("AUDIT_MASTER_KEYS"); spec != "" {
+		kr, err := envelope.Open(keyringPath, spec)
+		if err != nil {
+			slog.Error("keyring init failed", "error", err)
+			os.Exit(1)
+		}
+		keys = kr
+		auditLog.SetKeyring(keys)
+	}
+	root, size := auditLog.Root()
+	if restored != nil {
+		// Compare at the manifest size: entries appended since an earlier
+		// restart of the same restore are not part of the backup.
+		if got, ok := auditLog.RootAt(restored.Entries); !ok || got != restored.Root {
+			slog.Error("restored log does not match manifest", "backup", restored.ID, "root", got, "want_root", restored.Root)
+			os.Exit(1)
+		}
+		slog.Info("audit log restored", "backup", restored.ID)
+	}
+	slog.Info("audit log loaded", "entries", size, "root", root)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Deprecated routes are configured as pattern=deprecated[/sunset[!]][>successor];...
+	deprecations := deprecation.New(nil)
+	if err := deprecations.ParseSpec(os.Getenv("AUDIT_DEPRECATED_ROUTES")); err != nil {
+		slog.Error("invalid AUDIT_DEPRECATED_ROUTES", "error", err)
+		os.Exit(1)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc("GET /internal/deprecations", deprecations.ReportHandler)
+	registerRoutes(mux, auditLog)
+	if keys != nil {
+		registerKeyRoutes(mux, keys)
+	}
+
+	anchors, err := OpenAnchorStore(dataDir)
+	if err != nil {
+		slog.Error("anchor store open failed", "error", err)
+		os.Exit(1)
+	}
+	var anchorer *Anchorer
+	if tsaURL := os.Getenv("AUDIT_TSA_URL"); tsaURL != "" {
+		roots, err := loadTSARoots(os.Getenv("AUDIT_TSA_CA_FILE"))
+		if err != nil {
+			slog.Error("invalid AUDIT_TSA_CA_FILE", "error", err)
+			os.Exit(1)
+		}
+		if roots == nil {
+			slog.Error("AUDIT_TSA_CA_FILE not set: anchors are stored but no proof will be reported valid", "tsa", tsaURL)
+		}
+		anchorer = NewAnchorer(auditLog, anchors, tsaURL, roots)
+		interval, err := time.ParseDuration(getenv("AUDIT_TSA_INTERVAL", "1h"))
+		if err != nil {
+			interval = time.Hour
+		}
+		go anchorer.Run(ctx, interval)
+	}
+	registerAnchorRoutes(mux, anchors, anchorer)
+
+	if target := os.Getenv("AUDIT_BACKUP_TARGET"); target != "" {
+		sink, err := NewBackupSink(target)
+		if err != nil {
+			slog.Error("invalid backup target", "error", err)
+			os.Exit(1)
+		}
+		mux.HandleFunc("POST /internal/backup", func(w http.ResponseWriter, r *http.Request) {
+			m, err := WriteBackup(r.Context(), sink, auditLog, anchors)
+			if err != nil {
+				slog.Error("backup failed", "backup", m.ID, "error", err)
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			slog.Info("backup written", "backup", m.ID, "entries", m.Entries)
+			writeJSON(w, http.StatusOK, m)
+		})
+	}
+
+	srv := &http.Server{Addr: getenv("AUDIT_HTTP_ADDR", ":8080"), Handler: deprecations.Middleware(mux)}
+	go func() {
+		slog.Info("http listening", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("http server failed", "error", err)
+			stop()
+		}
+	}()
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+// loadTSARoots reads the PEM bundle used to validate TSA signer certificates.
+// An empty path returns nil: anchoring still works, verification does not.
+func loadTSARoots(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no PEM certificates", path)
+	}
+	return pool, nil
 }
@@ -0,0 +1,29 @@
+package main
+
+import "crypto/sha256"
+
+// merkleRoot computes a binary SHA-256 Merkle root over leaf hashes. An odd
+// node at any level is promoted unchanged. The root of an empty set is all zeros.
+func merkleRoot(leaves [][32]byte) [32]byte {
+	if len(leaves) == 0 {
+		return [32]byte{}
+	}
+	level := make([][32]byte, len(leaves))
+	copy(level, leaves)
+	for len(level) > 1 {
+		next := make([][32]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			var buf [65]byte
+			buf[0] = 0x01 // interior node domain separator
+			copy(buf[1:33], level[i][:])
+			copy(buf[33:], level[i+1][:])
+			next = append(next, sha256.Sum256(buf[:]))
+		}
+		level = next
+	}
+	return level[0]
+}
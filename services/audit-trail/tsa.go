@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// Minimal RFC 3161 client: builds TimeStampReq, parses TimeStampResp and
+// verifies the CMS SignedData wrapping the TSTInfo.
+
+var (
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+var errTSAVerify = errors.New("timestamp token verification failed")
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,tag:0"`
+}
+
+type issuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerial
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// accuracy is typed rather than a RawValue: an optional untagged RawValue
+// matches any element and would swallow the nonce of tokens without one.
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time     `asn1:"generalized"`
+	Accuracy       accuracy      `asn1:"optional"`
+	Ordering       bool          `asn1:"optional,default:false"`
+	Nonce          *big.Int      `asn1:"optional"`
+	TSA            asn1.RawValue `asn1:"optional,tag:0"`
+	Extensions     asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// TimestampInfo is the verified content of a timestamp token.
+type TimestampInfo struct {
+	GenTime time.Time
+	Serial  *big.Int
+	Digest  []byte
+	Signer  *x509.Certificate
+}
+
+// TSAClient requests RFC 3161 timestamps over SHA-256 digests.
+type TSAClient struct {
+	url   string
+	http  *http.Client
+	roots *x509.CertPool // nil: signer certificate chain is not checked
+}
+
+func NewTSAClient(url string, roots *x509.CertPool) *TSAClient {
+	return &TSAClient{url: url, http: &http.Client{Timeout: 10 * time.Second}, roots: roots}
+}
+
+// Timestamp obtains a token for digest and returns it with its verified content.
+func (c *TSAClient) Timestamp(ctx context.Context, digest []byte) ([]byte, *TimestampInfo, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, nil, err
+	}
+	reqDER, err := asn1.Marshal(timeStampReq{
+		Version:        1,
+		MessageImprint: messageImprint{HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256}, HashedMessage: digest},
+		Nonce:          nonce,
+		CertReq:        true,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(reqDER))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/timestamp-query")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("tsa status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, nil, err
+	}
+	var tsr timeStampResp
+	if _, err := asn1.Unmarshal(body, &tsr); err != nil {
+		return nil, nil, fmt.Errorf("decode tsa response: %w", err)
+	}
+	// 0 = granted, 1 = grantedWithMods
+	if tsr.Status.Status > 1 || len(tsr.TimeStampToken.FullBytes) == 0 {
+		return nil, nil, fmt.Errorf("tsa rejected request: status %d %v", tsr.Status.Status, tsr.Status.StatusString)
+	}
+	token := tsr.TimeStampToken.FullBytes
+	info, tst, err := parseAndVerifyToken(token, digest, c.roots)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tst.Nonce == nil || tst.Nonce.Cmp(nonce) != 0 {
+		return nil, nil, fmt.Errorf("%w: nonce mismatch", errTSAVerify)
+	}
+	return token, info, nil
+}
+
+// VerifyToken checks that token is a valid timestamp over digest.
+func VerifyToken(token, digest []byte, roots *x509.CertPool) (*TimestampInfo, error) {
+	info, _, err := parseAndVerifyToken(token, digest, roots)
+	return info, err
+}
+
+func parseAndVerifyToken(token, digest []byte, roots *x509.CertPool) (*TimestampInfo, *tstInfo, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(token, &ci); err != nil || !ci.ContentType.Equal(oidSignedData) {
+		return nil, nil, fmt.Errorf("%w: not a CMS SignedData", errTSAVerify)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errTSAVerify, err)
+	}
+	if !sd.EncapContentInfo.EContentType.Equal(oidTSTInfo) || len(sd.SignerInfos) != 1 {
+		return nil, nil, fmt.Errorf("%w: unexpected content", errTSAVerify)
+	}
+	var tst tstInfo
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent, &tst); err != nil {
+		return nil, nil, fmt.Errorf("%w: tstinfo: %v", errTSAVerify, err)
+	}
+	if !bytes.Equal(tst.MessageImprint.HashedMessage, digest) {
+		return nil, nil, fmt.Errorf("%w: message imprint mismatch", errTSAVerify)
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil || len(certs) == 0 {
+		return nil, nil, fmt.Errorf("%w: token carries no signer certificate", errTSAVerify)
+	}
+	si := sd.SignerInfos[0]
+	signer := findSigner(certs, si.SID)
+	if signer == nil {
+		return nil, nil, fmt.Errorf("%w: signer certificate not found", errTSAVerify)
+	}
+	if err := verifySignerInfo(si, signer, sd.EncapContentInfo.EContent); err != nil {
+		return nil, nil, err
+	}
+	if roots != nil {
+		inter := x509.NewCertPool()
+		for _, c := range certs {
+			inter.AddCert(c)
+		}
+		if _, err := signer.Verify(x509.VerifyOptions{
+			Roots: roots, Intermediates: inter, CurrentTime: tst.GenTime,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		}); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", errTSAVerify, err)
+		}
+	}
+	return &TimestampInfo{GenTime: tst.GenTime, Serial: tst.SerialNumber, Digest: digest, Signer: signer}, &tst, nil
+}
+
+func findSigner(certs []*x509.Certificate, sid issuerAndSerial) *x509.Certificate {
+	for _, c := range certs {
+		if sid.Serial != nil && c.SerialNumber.Cmp(sid.Serial) == 0 && bytes.Equal(c.RawIssuer, sid.Issuer.FullBytes) {
+			return c
+		}
+	}
+	return nil
+}
+
+func verifySignerInfo(si signerInfo, cert *x509.Certificate, content []byte) error {
+	h, err := hashFor(si.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	if len(si.SignedAttrs.Bytes) == 0 {
+		return fmt.Errorf("%w: signed attributes missing", errTSAVerify)
+	}
+	// The signature covers the DER of the attributes re-tagged as a SET.
+	signed := append([]byte(nil), si.SignedAttrs.FullBytes...)
+	signed[0] = 0x31
+	var attrs []attribute
+	if _, err := asn1.UnmarshalWithParams(signed, &attrs, "set"); err != nil {
+		return fmt.Errorf("%w: signed attributes: %v", errTSAVerify, err)
+	}
+	hw := h.New()
+	hw.Write(content)
+	var found bool
+	for _, a := range attrs {
+		if !a.Type.Equal(oidMessageDigest) {
+			continue
+		}
+		var md []byte
+		if _, err := asn1.Unmarshal(a.Values.Bytes, &md); err != nil || !bytes.Equal(md, hw.Sum(nil)) {
+			return fmt.Errorf("%w: content digest mismatch", errTSAVerify)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("%w: message digest attribute missing", errTSAVerify)
+	}
+	alg, err := signatureAlgorithm(h, cert)
+	if err != nil {
+		return err
+	}
+	if err := cert.CheckSignature(alg, signed, si.Signature); err != nil {
+		return fmt.Errorf("%w: %v", errTSAVerify, err)
+	}
+	return nil
+}
+
+func hashFor(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("%w: unsupported digest %v", errTSAVerify, oid)
+}
+
+func signatureAlgorithm(h crypto.Hash, cert *x509.Certificate) (x509.SignatureAlgorithm, error) {
+	type key struct {
+		h crypto.Hash
+		k x509.PublicKeyAlgorithm
+	}
+	algs := map[key]x509.SignatureAlgorithm{
+		{crypto.SHA256, x509.RSA}: x509.SHA256WithRSA, {crypto.SHA384, x509.RSA}: x509.SHA384WithRSA,
+		{crypto.SHA512, x509.RSA}: x509.SHA512WithRSA, {crypto.SHA256, x509.ECDSA}: x509.ECDSAWithSHA256,
+		{crypto.SHA384, x509.ECDSA}: x509.ECDSAWithSHA384, {crypto.SHA512, x509.ECDSA}: x509.ECDSAWithSHA512,
+	}
+	if alg, ok := algs[key{h, cert.PublicKeyAlgorithm}]; ok {
+		return alg, nil
+	}
+	return 0, fmt.Errorf("%w: unsupported signer key %v", errTSAVerify, cert.PublicKeyAlgorithm)
+}
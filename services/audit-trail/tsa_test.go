@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testTSA signs RFC 3161 tokens with a leaf certificate issued by its own CA.
+type testTSA struct {
+	roots  *x509.CertPool
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestTSA(t *testing.T) *testTSA {
+	t.Helper()
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test tsa root"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ = x509.ParseCertificate(caDER)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafDER, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: "test tsa"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	}, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(leafDER)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	return &testTSA{roots: roots, cert: leaf, key: key}
+}
+
+// token returns a DER TimeStampToken over digest.
+func (s *testTSA) token(t *testing.T, digest []byte, nonce *big.Int) []byte {
+	t.Helper()
+	s.serial++
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+	tst, err := asn1.Marshal(tstInfo{
+		Version: 1, Policy: asn1.ObjectIdentifier{1, 2, 3},
+		MessageImprint: messageImprint{HashAlgorithm: sha256Alg, HashedMessage: digest},
+		SerialNumber:   big.NewInt(s.serial), GenTime: time.Now().UTC().Truncate(time.Second), Nonce: nonce,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(tst)
+	md, _ := asn1.Marshal(sum[:])
+	// The signature covers the attributes as a SET; the SignerInfo carries
+	// them re-tagged as [0] IMPLICIT.
+	attrs, err := asn1.MarshalWithParams([]attribute{{Type: oidMessageDigest, Values: asn1.RawValue{FullBytes: append([]byte{0x31, byte(len(md))}, md...)}}}, "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrsSum := sha256.Sum256(attrs)
+	sig, err := ecdsa.SignASN1(rand.Reader, s.key, attrsSum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedAttrs := append([]byte{0xa0}, attrs[1:]...)
+	digestAlgs, _ := asn1.MarshalWithParams([]pkix.AlgorithmIdentifier{sha256Alg}, "set")
+	sd, err := asn1.Marshal(signedData{
+		Version:          3,
+		DigestAlgorithms: asn1.RawValue{FullBytes: digestAlgs},
+		EncapContentInfo: encapContentInfo{EContentType: oidTSTInfo, EContent: tst},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: s.cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                issuerAndSerial{Issuer: asn1.RawValue{FullBytes: s.cert.RawIssuer}, Serial: s.cert.SerialNumber},
+			DigestAlgorithm:    sha256Alg,
+			SignedAttrs:        asn1.RawValue{FullBytes: signedAttrs},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+			Signature:          sig,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// server answers TimeStampReqs like an RFC 3161 HTTP endpoint.
+func (s *testTSA) server(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req timeStampReq
+		if _, err := asn1.Unmarshal(body, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp, _ := asn1.Marshal(timeStampResp{
+			Status:         pkiStatusInfo{Status: 0},
+			TimeStampToken: asn1.RawValue{FullBytes: s.token(t, req.MessageImprint.HashedMessage, req.Nonce)},
+		})
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		_, _ = w.Write(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestTimestampTokenVerification(t *testing.T) {
+	tsa := newTestTSA(t)
+	digest := sha256.Sum256([]byte("root"))
+	token, info, err := NewTSAClient(tsa.server(t).URL, tsa.roots).Timestamp(context.Background(), digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Signer.Subject.CommonName != "test tsa" || info.Serial.Int64() != 1 {
+		t.Fatalf("info %+v", info)
+	}
+	if _, err := VerifyToken(token, digest[:], tsa.roots); err != nil {
+		t.Fatalf("good token: %v", err)
+	}
+
+	other := sha256.Sum256([]byte("other root"))
+	if _, err := VerifyToken(token, other[:], tsa.roots); !errors.Is(err, errTSAVerify) {
+		t.Fatalf("wrong imprint: %v", err)
+	}
+	// Flip the last byte of the signed TSTInfo.
+	var ci contentInfo
+	var sd signedData
+	if _, err := asn1.Unmarshal(token, &ci); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), token...)
+	tampered[bytes.Index(token, sd.EncapContentInfo.EContent)+len(sd.EncapContentInfo.EContent)-1] ^= 0xff
+	if _, err := VerifyToken(tampered, digest[:], tsa.roots); !errors.Is(err, errTSAVerify) {
+		t.Fatalf("tampered token: %v", err)
+	}
+	if _, err := VerifyToken(token, digest[:], newTestTSA(t).roots); !errors.Is(err, errTSAVerify) {
+		t.Fatalf("untrusted signer: %v", err)
+	}
+}
+
+func TestProveRequiresTrustRoots(t *testing.T) {
+	tsa := newTestTSA(t)
+	dir := t.TempDir()
+	l, err := OpenAuditLog(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if _, err := l.Append(Entry{Stream: "s", Producer: "test", Action: "write", Data: json.RawMessage(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	store, _ := OpenAnchorStore(dir)
+	url := tsa.server(t).URL
+	a := NewAnchorer(l, store, url, tsa.roots)
+	anchor, err := a.AnchorNow(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := a.Prove(anchor.Root, time.Time{}); !p.Valid || !p.Consistent {
+		t.Fatalf("proof %+v", p)
+	}
+	if p := a.Prove(anchor.Root, anchor.GenTime); p.Valid {
+		t.Fatalf("proof not before gen time: %+v", p)
+	}
+	digest, _ := hex.DecodeString(anchor.Root)
+	if p := a.Prove(hex.EncodeToString(digest[:len(digest)-1]), time.Time{}); p.Valid {
+		t.Fatalf("proof of unknown root: %+v", p)
+	}
+	if p := NewAnchorer(l, store, url, nil).Prove(anchor.Root, time.Time{}); p.Valid || p.Error != errNoTSARoots.Error() {
+		t.Fatalf("proof without trust roots: %+v", p)
+	}
+}
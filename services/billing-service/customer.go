@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCustomerNotFound = errors.New("customer not found")
+	ErrCustomerExists   = errors.New("customer exists")
+)
+
+// TierLimits are the enforcement limits attached to a pricing tier.
+type TierLimits struct {
+	RequestsPerMinute int   `json:"requests_per_minute"`
+	EventsPerDay      int64 `json:"events_per_day"`
+	MaxAPIKeys        int   `json:"max_api_keys"`
+}
+
+// defaultTiers can be overridden with BILLING_TIERS (JSON object tier -> limits).
+var defaultTiers = map[string]TierLimits{
+	"free":       {RequestsPerMinute: 60, EventsPerDay: 100_000, MaxAPIKeys: 2},
+	"pro":        {RequestsPerMinute: 1_200, EventsPerDay: 10_000_000, MaxAPIKeys: 20},
+	"enterprise": {RequestsPerMinute: 12_000, EventsPerDay: 500_000_000, MaxAPIKeys: 200},
+}
+
+const downgradeTier = "free"
+
+func tiersFromEnv() (map[string]TierLimits, error) {
+	raw := os.Getenv("BILLING_TIERS")
+	if raw == "" {
+		return defaultTiers, nil
+	}
+	var tiers map[string]TierLimits
+	if err := json.Unmarshal([]byte(raw), &tiers); err != nil {
+		return nil, fmt.Errorf("BILLING_TIERS: %w", err)
+	}
+	if _, ok := tiers[downgradeTier]; !ok {
+		return nil, fmt.Errorf("BILLING_TIERS must define %q", downgradeTier)
+	}
+	return tiers, nil
+}
+
+type Customer struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	Tier string `json:"tier"`
+	// SampleRate > 1 meters usage by counting 1 in SampleRate events and
+	// extrapolating, for very high volume customers.
+	SampleRate int       `json:"sample_rate,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// CustomerStore persists customers the same way InvoiceStore does.
+type CustomerStore struct {
+	mu        sync.RWMutex
+	path      string
+	customers map[string]*Customer
+}
+
+func NewCustomerStore(path string) (*CustomerStore, error) {
+	s := &CustomerStore{path: path, customers: map[string]*Customer{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*Customer
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	for _, c := range list {
+		s.customers[c.ID] = c
+	}
+	return s, nil
+}
+
+func (s *CustomerStore) Get(id string) (Customer, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.customers[id]
+	if !ok {
+		return Customer{}, false
+	}
+	return *c, true
+}
+
+func (s *CustomerStore) List() []Customer {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Customer, 0, len(s.customers))
+	for _, c := range s.customers {
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (s *CustomerStore) Put(c Customer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	if prev, ok := s.customers[c.ID]; ok {
+		c.CreatedAt = prev.CreatedAt
+	} else {
+		c.CreatedAt = now
+	}
+	c.UpdatedAt = now
+	s.customers[c.ID] = &c
+	return s.persistLocked()
+}
+
+// Create stores c unless a customer with its ID exists, atomically.
+func (s *CustomerStore) Create(c Customer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.customers[c.ID]; ok {
+		return ErrCustomerExists
+	}
+	c.CreatedAt = time.Now().UTC()
+	c.UpdatedAt = c.CreatedAt
+	s.customers[c.ID] = &c
+	if err := s.persistLocked(); err != nil {
+		delete(s.customers, c.ID)
+		return err
+	}
+	return nil
+}
+
+func (s *CustomerStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.customers[id]; !ok {
+		return ErrCustomerNotFound
+	}
+	delete(s.customers, id)
+	return s.persistLocked()
+}
+
+func (s *CustomerStore) persistLocked() error {
+	list := make([]*Customer, 0, len(s.customers))
+	for _, c := range s.customers {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
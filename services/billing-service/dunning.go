@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DunningPolicy controls how long an unpaid invoice may stay in each state
+// before the next escalation step fires. Offsets are measured from DueAt.
+type DunningPolicy struct {
+	GracePeriod    time.Duration // past_due -> downgraded
+	SuspendAfter   time.Duration // downgraded -> suspended (in addition to GracePeriod)
+	NotifyWorkflow string
+	DowngradeFlow  string
+	SuspendFlow    string
+	RestoreFlow    string
+}
+
+func dunningPolicyFromEnv() DunningPolicy {
+	return DunningPolicy{
+		GracePeriod:    getenvDuration("BILLING_DUNNING_GRACE", 7*24*time.Hour),
+		SuspendAfter:   getenvDuration("BILLING_DUNNING_SUSPEND_AFTER", 7*24*time.Hour),
+		NotifyWorkflow: getenv("BILLING_PLAYBOOK_NOTIFY", "billing-dunning-notify"),
+		DowngradeFlow:  getenv("BILLING_PLAYBOOK_DOWNGRADE", "billing-dunning-downgrade"),
+		SuspendFlow:    getenv("BILLING_PLAYBOOK_SUSPEND", "billing-dunning-suspend"),
+		RestoreFlow:    getenv("BILLING_PLAYBOOK_RESTORE", "billing-dunning-restore"),
+	}
+}
+
+// Target returns the state an unpaid invoice should be in at time now.
+func (p DunningPolicy) Target(inv Invoice, now time.Time) InvoiceState {
+	overdue := now.Sub(inv.DueAt)
+	switch {
+	case overdue <= 0:
+		return StateOpen
+	case overdue <= p.GracePeriod:
+		return StatePastDue
+	case overdue <= p.GracePeriod+p.SuspendAfter:
+		return StateDowngrade
+	default:
+		return StateSuspended
+	}
+}
+
+// workflowFor maps a state entered to the orchestrator playbook that enforces it.
+func (p DunningPolicy) workflowFor(from, to InvoiceState) string {
+	switch to {
+	case StatePastDue:
+		return p.NotifyWorkflow
+	case StateDowngrade:
+		return p.DowngradeFlow
+	case StateSuspended:
+		return p.SuspendFlow
+	case StatePaid, StateOpen, StateVoid:
+		if from == StateDowngrade || from == StateSuspended {
+			return p.RestoreFlow
+		}
+	}
+	return ""
+}
+
+// DunningManager drives invoice state transitions and triggers the matching
+// orchestrator playbook for each one. Transitions of one invoice are
+// serialized, so a payment cannot interleave with an escalation whose
+// playbook already ran.
+type DunningManager struct {
+	store    *InvoiceStore
+	playbook *PlaybookClient
+	policy   DunningPolicy
+	now      func() time.Time
+	onChange func(ctx context.Context, customerID string)
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex // per invoice
+}
+
+func NewDunningManager(store *InvoiceStore, playbook *PlaybookClient, policy DunningPolicy) *DunningManager {
+	return &DunningManager{store: store, playbook: playbook, policy: policy, now: time.Now, locks: map[string]*sync.Mutex{}}
+}
+
+// OnChange registers a callback invoked after every persisted transition.
+func (d *DunningManager) OnChange(f func(ctx context.Context, customerID string)) { d.onChange = f }
+
+func (d *DunningManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		d.Tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// lock serializes transitions of one invoice.
+func (d *DunningManager) lock(id string) *sync.Mutex {
+	d.mu.Lock()
+	l, ok := d.locks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		d.locks[id] = l
+	}
+	d.mu.Unlock()
+	l.Lock()
+	return l
+}
+
+// escalation returns the next automatic step for inv, if any. Invoices on
+// hold are skipped, and so are invoices whose state was last set manually:
+// an override stays in place until another manual action, or until a
+// hold_until given with it has passed.
+func (d *DunningManager) escalation(inv Invoice, now time.Time) (InvoiceState, bool) {
+	if inv.State == StatePaid || inv.State == StateVoid || now.Before(inv.HoldUntil) {
+		return "", false
+	}
+	if n := len(inv.History); n > 0 && inv.History[n-1].Manual && !inv.HoldUntil.After(inv.History[n-1].At) {
+		return "", false
+	}
+	return nextStep(inv.State, d.policy.Target(inv, now))
+}
+
+// Tick escalates every unpaid invoice by at most one step. A step is only
+// persisted once its playbook was accepted, so a failed trigger is retried on
+// the next tick.
+func (d *DunningManager) Tick(ctx context.Context) {
+	now := d.now()
+	for _, inv := range d.store.List() {
+		if _, ok := d.escalation(inv, now); !ok {
+			continue
+		}
+		if err := d.escalate(ctx, inv.ID, now); err != nil {
+			slog.Warn("dunning transition failed", "invoice", inv.ID, "error", err)
+		}
+	}
+}
+
+// escalate re-checks the invoice under its lock, since a payment or override
+// may have landed since Tick listed it.
+func (d *DunningManager) escalate(ctx context.Context, id string, now time.Time) error {
+	defer d.lock(id).Unlock()
+	inv, ok := d.store.Get(id)
+	if !ok {
+		return nil
+	}
+	next, ok := d.escalation(inv, now)
+	if !ok {
+		return nil
+	}
+	_, err := d.applyLocked(ctx, inv, next, "dunning: overdue since "+inv.DueAt.Format(time.RFC3339), false)
+	return err
+}
+
+// Apply triggers the playbook for a transition and then persists it.
+func (d *DunningManager) Apply(ctx context.Context, id string, to InvoiceState, reason string, manual bool) (Invoice, error) {
+	defer d.lock(id).Unlock()
+	inv, ok := d.store.Get(id)
+	if !ok {
+		return Invoice{}, ErrInvoiceNotFound
+	}
+	return d.applyLocked(ctx, inv, to, reason, manual)
+}
+
+func (d *DunningManager) applyLocked(ctx context.Context, inv Invoice, to InvoiceState, reason string, manual bool) (Invoice, error) {
+	if !canTransition(inv.State, to) {
+		return inv, ErrInvalidTransition
+	}
+	if wf := d.policy.workflowFor(inv.State, to); wf != "" {
+		if err := d.playbook.Trigger(ctx, wf, map[string]any{
+			"invoice_id":   inv.ID,
+			"customer_id":  inv.CustomerID,
+			"amount_cents": inv.AmountCents,
+			"from_state":   string(inv.State),
+			"to_state":     string(to),
+			"manual":       manual,
+		}); err != nil {
+			return inv, err
+		}
+	}
+	updated, err := d.store.Transition(inv.ID, to, reason, manual)
+	if err == nil {
+		slog.Info("invoice state changed", "invoice", inv.ID, "customer", inv.CustomerID, "from", inv.State, "to", to, "manual", manual)
+		if d.onChange != nil {
+			d.onChange(ctx, inv.CustomerID)
+		}
+	}
+	return updated, err
+}
+
+// nextStep returns the single escalation step from cur toward target.
+func nextStep(cur, target InvoiceState) (InvoiceState, bool) {
+	order := []InvoiceState{StateOpen, StatePastDue, StateDowngrade, StateSuspended}
+	rank := func(s InvoiceState) int {
+		for i, o := range order {
+			if o == s {
+				return i
+			}
+		}
+		return -1
+	}
+	c, t := rank(cur), rank(target)
+	if c < 0 || t <= c {
+		return "", false
+	}
+	return order[c+1], true
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDunningTargetAndNextStep(t *testing.T) {
+	p := DunningPolicy{GracePeriod: 48 * time.Hour, SuspendAfter: 24 * time.Hour}
+	due := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	inv := Invoice{DueAt: due}
+	cases := []struct {
+		after time.Duration
+		want  InvoiceState
+	}{
+		{-time.Hour, StateOpen},
+		{time.Hour, StatePastDue},
+		{50 * time.Hour, StateDowngrade},
+		{80 * time.Hour, StateSuspended},
+	}
+	for _, c := range cases {
+		if got := p.Target(inv, due.Add(c.after)); got != c.want {
+			t.Errorf("Target(+%s) = %s, want %s", c.after, got, c.want)
+		}
+	}
+	// Escalation is one step at a time even if the invoice is long overdue.
+	if next, ok := nextStep(StateOpen, StateSuspended); !ok || next != StatePastDue {
+		t.Fatalf("nextStep(open, suspended) = %s, %v", next, ok)
+	}
+	if _, ok := nextStep(StateSuspended, StatePastDue); ok {
+		t.Fatal("dunning must never de-escalate automatically")
+	}
+}
+
+func TestDunningSerializesPaymentWithEscalation(t *testing.T) {
+	var mu sync.Mutex
+	var runs []string
+	downgrading, release := make(chan struct{}), make(chan struct{})
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Workflow string `json:"workflow"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		runs = append(runs, body.Workflow)
+		mu.Unlock()
+		if body.Workflow == "downgrade" {
+			close(downgrading)
+			<-release
+		}
+	}))
+	defer orch.Close()
+	t.Setenv("ORCHESTRATOR_URL", orch.URL)
+	store, err := NewInvoiceStore(filepath.Join(t.TempDir(), "invoices.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	due := time.Now().Add(-50 * time.Hour)
+	if err := store.Put(Invoice{ID: "inv-1", CustomerID: "c1", DueAt: due, State: StatePastDue}); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDunningManager(store, NewPlaybookClient(nil), DunningPolicy{GracePeriod: 48 * time.Hour, SuspendAfter: 24 * time.Hour, DowngradeFlow: "downgrade", RestoreFlow: "restore"})
+
+	ticked := make(chan struct{})
+	go func() { d.Tick(context.Background()); close(ticked) }()
+	<-downgrading
+	paid := make(chan error)
+	go func() {
+		_, err := d.Apply(context.Background(), "inv-1", StatePaid, "payment received", true)
+		paid <- err
+	}()
+	time.Sleep(20 * time.Millisecond) // let the payment reach the invoice first
+	close(release)
+	<-ticked
+	if err := <-paid; err != nil {
+		t.Fatalf("payment during escalation: %v", err)
+	}
+	inv, _ := store.Get("inv-1")
+	mu.Lock()
+	defer mu.Unlock()
+	if inv.State != StatePaid || len(runs) != 2 || runs[1] != "restore" {
+		t.Fatalf("state %s, playbooks %v", inv.State, runs)
+	}
+}
+
+func TestDunningHonoursManualOverride(t *testing.T) {
+	orch := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer orch.Close()
+	t.Setenv("ORCHESTRATOR_URL", orch.URL)
+	store, err := NewInvoiceStore(filepath.Join(t.TempDir(), "invoices.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(Invoice{ID: "inv-1", DueAt: time.Now().Add(-time.Hour), State: StatePastDue}); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDunningManager(store, NewPlaybookClient(nil), DunningPolicy{GracePeriod: 48 * time.Hour, SuspendAfter: 24 * time.Hour})
+	if _, err := d.Apply(context.Background(), "inv-1", StateOpen, "customer called", true); err != nil {
+		t.Fatal(err)
+	}
+	d.Tick(context.Background())
+	if inv, _ := store.Get("inv-1"); inv.State != StateOpen {
+		t.Fatalf("manual override escalated to %s", inv.State)
+	}
+	// A hold given with the override lets dunning resume once it passes.
+	if _, err := store.SetHold("inv-1", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	d.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	d.Tick(context.Background())
+	if inv, _ := store.Get("inv-1"); inv.State != StatePastDue {
+		t.Fatalf("after the hold: %s", inv.State)
+	}
+}
+
+func TestInvoiceChangesRollBackWhenPersistFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "invoices.json")
+	store, err := NewInvoiceStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(Invoice{ID: "inv-1", DueAt: time.Now().Add(-time.Hour), State: StatePastDue}); err != nil {
+		t.Fatal(err)
+	}
+	// The store's directory is a regular file, so every persist fails.
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store.path = filepath.Join(blocker, "invoices.json")
+
+	if _, err := store.Transition("inv-1", StateDowngrade, "overdue", false); err == nil {
+		t.Fatal("transition persisted into a blocked path")
+	}
+	if _, err := store.SetHold("inv-1", time.Now().Add(time.Hour)); err == nil {
+		t.Fatal("hold persisted into a blocked path")
+	}
+	if inv, _ := store.Get("inv-1"); inv.State != StatePastDue || len(inv.History) != 0 || !inv.HoldUntil.IsZero() {
+		t.Fatalf("memory ran ahead of disk: %+v", inv)
+	}
+
+	store.path = path
+	if _, err := store.Transition("inv-1", StateDowngrade, "overdue", false); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewInvoiceStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv, _ := reopened.Get("inv-1"); inv.State != StateDowngrade || len(inv.History) != 1 {
+		t.Fatalf("after restart: %+v", inv)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+func registerInvoiceRoutes(mux *http.ServeMux, store *InvoiceStore, dunning *DunningManager, meter *UsageMeter) {
+	mux.HandleFunc("GET /v1/invoices", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, store.List())
+	})
+	// bill_usage closes the customer's metering period into line items.
+	mux.HandleFunc("POST /v1/invoices", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Invoice
+			BillUsage bool `json:"bill_usage"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" || req.CustomerID == "" {
+			writeError(w, http.StatusBadRequest, "id and customer_id required")
+			return
+		}
+		inv := req.Invoice
+		if _, exists := store.Get(inv.ID); exists {
+			writeError(w, http.StatusConflict, "invoice exists")
+			return
+		}
+		inv.State, inv.History = StateOpen, nil
+		var closed *UsagePeriod
+		if req.BillUsage {
+			items, p, err := meter.Close(inv.CustomerID)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			inv.LineItems, closed = items, p
+		}
+		if err := store.Put(inv); err != nil {
+			// The usage was not billed; put it back for the next invoice.
+			if rerr := meter.Reopen(closed); rerr != nil {
+				slog.Error("usage period lost after failed invoice", "customer", inv.CustomerID, "invoice", inv.ID, "error", rerr)
+			}
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		created, _ := store.Get(inv.ID)
+		writeJSON(w, http.StatusCreated, created)
+	})
+	mux.HandleFunc("GET /v1/invoices/{id}", func(w http.ResponseWriter, r *http.Request) {
+		inv, ok := store.Get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrInvoiceNotFound.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, inv)
+	})
+	mux.HandleFunc("POST /v1/invoices/{id}/pay", func(w http.ResponseWriter, r *http.Request) {
+		inv, err := dunning.Apply(r.Context(), r.PathValue("id"), StatePaid, "payment received", true)
+		writeTransition(w, inv, err)
+	})
+	// Manual override: force a state (e.g. reinstate a suspended customer) and/or
+	// pause automatic escalation until hold_until.
+	mux.HandleFunc("POST /v1/invoices/{id}/override", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			State     InvoiceState `json:"state"`
+			Reason    string       `json:"reason"`
+			HoldUntil *time.Time   `json:"hold_until"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.State == "" && req.HoldUntil == nil) {
+			writeError(w, http.StatusBadRequest, "state or hold_until required")
+			return
+		}
+		id := r.PathValue("id")
+		inv, ok := store.Get(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrInvoiceNotFound.Error())
+			return
+		}
+		var err error
+		if req.HoldUntil != nil {
+			if inv, err = store.SetHold(id, *req.HoldUntil); err != nil {
+				writeTransition(w, inv, err)
+				return
+			}
+		}
+		if req.State != "" && req.State != inv.State {
+			if req.Reason == "" {
+				req.Reason = "manual override"
+			}
+			inv, err = dunning.Apply(r.Context(), id, req.State, req.Reason, true)
+		}
+		writeTransition(w, inv, err)
+	})
+}
+
+func registerCustomerRoutes(mux *http.ServeMux, customers *CustomerStore, limits *LimitsPublisher, tiers map[string]TierLimits) {
+	mux.HandleFunc("GET /v1/customers", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, customers.List())
+	})
+	mux.HandleFunc("POST /v1/customers", func(w http.ResponseWriter, r *http.Request) {
+		var c Customer
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil || c.ID == "" {
+			writeError(w, http.StatusBadRequest, "id required")
+			return
+		}
+		if _, ok := tiers[c.Tier]; !ok {
+			writeError(w, http.StatusBadRequest, "unknown tier")
+			return
+		}
+		if _, exists := customers.Get(c.ID); exists {
+			writeError(w, http.StatusConflict, "customer exists")
+			return
+		}
+		if err := customers.Put(c); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		limits.CustomerChanged(r.Context(), c.ID)
+		created, _ := customers.Get(c.ID)
+		writeJSON(w, http.StatusCreated, created)
+	})
+	mux.HandleFunc("GET /v1/customers/{id}", func(w http.ResponseWriter, r *http.Request) {
+		c, ok := customers.Get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrCustomerNotFound.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	})
+	mux.HandleFunc("PUT /v1/customers/{id}/tier", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Tier string `json:"tier"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid body")
+			return
+		}
+		if _, ok := tiers[req.Tier]; !ok {
+			writeError(w, http.StatusBadRequest, "unknown tier")
+			return
+		}
+		c, ok := customers.Get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrCustomerNotFound.Error())
+			return
+		}
+		c.Tier = req.Tier
+		if err := customers.Put(c); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		limits.CustomerChanged(r.Context(), c.ID)
+		writeJSON(w, http.StatusOK, c)
+	})
+	mux.HandleFunc("GET /v1/customers/{id}/limits", func(w http.ResponseWriter, r *http.Request) {
+		eff, ok := limits.Effective(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrCustomerNotFound.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, eff)
+	})
+}
+
+func registerUsageRoutes(mux *http.ServeMux, customers *CustomerStore, meter *UsageMeter) {
+	mux.HandleFunc("POST /v1/usage", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			CustomerID string `json:"customer_id"`
+			Metric     string `json:"metric"`
+			Count      int64  `json:"count"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CustomerID == "" || req.Metric == "" || req.Count <= 0 {
+			writeError(w, http.StatusBadRequest, "customer_id, metric and positive count required")
+			return
+		}
+		c, ok := customers.Get(req.CustomerID)
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrCustomerNotFound.Error())
+			return
+		}
+		kept := meter.Record(c.ID, req.Metric, req.Count, c.SampleRate)
+		writeJSON(w, http.StatusAccepted, map[string]bool{"recorded": kept})
+	})
+	mux.HandleFunc("GET /v1/customers/{id}/usage", func(w http.ResponseWriter, r *http.Request) {
+		p, ok := meter.Period(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrNoUsage.Error())
+			return
+		}
+		type counterView struct {
+			*UsageCounter
+			Quantity   int64 `json:"quantity"`
+			ErrorBound int64 `json:"error_bound"`
+		}
+		out := map[string]any{"customer_id": p.CustomerID, "start": p.Start}
+		counters := map[string]counterView{}
+		for k, c := range p.Counters {
+			counters[k] = counterView{UsageCounter: c, Quantity: c.Quantity(), ErrorBound: c.ErrorBound()}
+		}
+		out["counters"] = counters
+		writeJSON(w, http.StatusOK, out)
+	})
+	// Switch a customer between exact (sample_rate 0 or 1) and sampled metering.
+	mux.HandleFunc("PUT /v1/customers/{id}/metering", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			SampleRate int `json:"sample_rate"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SampleRate < 0 {
+			writeError(w, http.StatusBadRequest, "non-negative sample_rate required")
+			return
+		}
+		c, ok := customers.Get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrCustomerNotFound.Error())
+			return
+		}
+		c.SampleRate = req.SampleRate
+		if err := customers.Put(c); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, c)
+	})
+	// Reconcile metered usage against the gateway's aggregate counter.
+	mux.HandleFunc("POST /v1/usage/reconcile", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			CustomerID string `json:"customer_id"`
+			Metric     string `json:"metric"`
+			Reference  int64  `json:"reference"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CustomerID == "" || req.Metric == "" {
+			writeError(w, http.StatusBadRequest, "customer_id and metric required")
+			return
+		}
+		rec, err := meter.Reconcile(req.CustomerID, req.Metric, req.Reference)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, rec)
+	})
+}
+
+func writeTransition(w http.ResponseWriter, inv Invoice, err error) {
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, inv)
+	case errors.Is(err, ErrInvoiceNotFound):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrInvalidTransition):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusBadGateway, err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// InvoiceState is a node in the dunning state machine.
+type InvoiceState string
+
+const (
+	StateOpen      InvoiceState = "open"
+	StatePastDue   InvoiceState = "past_due"
+	StateDowngrade InvoiceState = "downgraded"
+	StateSuspended InvoiceState = "suspended"
+	StatePaid      InvoiceState = "paid"
+	StateVoid      InvoiceState = "void"
+)
+
+// allowedTransitions lists automatic and manual moves. Paid and void are terminal.
+var allowedTransitions = map[InvoiceState][]InvoiceState{
+	StateOpen:      {StatePastDue, StatePaid, StateVoid},
+	StatePastDue:   {StateDowngrade, StatePaid, StateVoid, StateOpen},
+	StateDowngrade: {StateSuspended, StatePaid, StateVoid, StateOpen},
+	StateSuspended: {StatePaid, StateVoid, StateOpen},
+}
+
+var (
+	ErrInvoiceNotFound   = errors.New("invoice not found")
+	ErrInvalidTransition = errors.New("invalid state transition")
+)
+
+// Transition records one state change for audit and debugging.
+type Transition struct {
+	From   InvoiceState `json:"from"`
+	To     InvoiceState `json:"to"`
+	At     time.Time    `json:"at"`
+	Reason string       `json:"reason"`
+	Manual bool         `json:"manual"`
+}
+
+type Invoice struct {
+	ID          string       `json:"id"`
+	CustomerID  string       `json:"customer_id"`
+	AmountCents int64        `json:"amount_cents"`
+	Currency    string       `json:"currency"`
+	DueAt       time.Time    `json:"due_at"`
+	State       InvoiceState `json:"state"`
+	// HoldUntil pauses automatic dunning (manual override) until the given time.
+	HoldUntil time.Time    `json:"hold_until,omitempty"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	History   []Transition `json:"history,omitempty"`
+	LineItems []LineItem   `json:"line_items,omitempty"`
+}
+
+// LineItem is the metered usage of one metric over a billing period. For
+// sampled metering Quantity is an estimate and ErrorBound its 95% confidence
+// half-width; ReferenceQuantity is the gateway aggregate if reconciled.
+type LineItem struct {
+	Metric            string    `json:"metric"`
+	Quantity          int64     `json:"quantity"`
+	Sampled           bool      `json:"sampled,omitempty"`
+	SampleRate        int       `json:"sample_rate,omitempty"`
+	ErrorBound        int64     `json:"error_bound,omitempty"`
+	ReferenceQuantity *int64    `json:"reference_quantity,omitempty"`
+	PeriodStart       time.Time `json:"period_start"`
+	PeriodEnd         time.Time `json:"period_end"`
+}
+
+func canTransition(from, to InvoiceState) bool {
+	for _, s := range allowedTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// InvoiceStore keeps invoices in memory and persists the full set to a JSON file
+// on every mutation (write to temp file + rename for crash safety).
+type InvoiceStore struct {
+	mu       sync.RWMutex
+	path     string
+	invoices map[string]*Invoice
+	// byCustomer indexes invoice IDs per customer for ListByCustomer.
+	byCustomer map[string]map[string]bool
+}
+
+func NewInvoiceStore(path string) (*InvoiceStore, error) {
+	s := &InvoiceStore{path: path, invoices: map[string]*Invoice{}, byCustomer: map[string]map[string]bool{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*Invoice
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	for _, inv := range list {
+		s.setLocked(inv)
+	}
+	return s, nil
+}
+
+func (s *InvoiceStore) Get(id string) (Invoice, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	inv, ok := s.invoices[id]
+	if !ok {
+		return Invoice{}, false
+	}
+	return *inv, true
+}
+
+func (s *InvoiceStore) List() []Invoice {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Invoice, 0, len(s.invoices))
+	for _, inv := range s.invoices {
+		out = append(out, *inv)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// ListByCustomer returns the invoices of one customer, sorted by ID.
+func (s *InvoiceStore) ListByCustomer(customerID string) []Invoice {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := s.byCustomer[customerID]
+	out := make([]Invoice, 0, len(ids))
+	for id := range ids {
+		out = append(out, *s.invoices[id])
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (s *InvoiceStore) Put(inv Invoice) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if inv.State == "" {
+		inv.State = StateOpen
+	}
+	inv.UpdatedAt = time.Now().UTC()
+	prev, existed := s.invoices[inv.ID]
+	s.setLocked(&inv)
+	if err := s.persistLocked(); err != nil {
+		// Keep memory in line with disk so a failed create is not served.
+		if existed {
+			s.setLocked(prev)
+		} else {
+			s.deleteLocked(inv.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// setLocked stores inv and keeps the customer index in line, also when a
+// Put moves an invoice to another customer.
+func (s *InvoiceStore) setLocked(inv *Invoice) {
+	s.deleteLocked(inv.ID)
+	s.invoices[inv.ID] = inv
+	ids := s.byCustomer[inv.CustomerID]
+	if ids == nil {
+		ids = map[string]bool{}
+		s.byCustomer[inv.CustomerID] = ids
+	}
+	ids[inv.ID] = true
+}
+
+func (s *InvoiceStore) deleteLocked(id string) {
+	cur, ok := s.invoices[id]
+	if !ok {
+		return
+	}
+	delete(s.invoices, id)
+	if ids := s.byCustomer[cur.CustomerID]; ids != nil {
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(s.byCustomer, cur.CustomerID)
+		}
+	}
+}
+
+// Transition moves an invoice to a new state if the state machine allows it.
+func (s *InvoiceStore) Transition(id string, to InvoiceState, reason string, manual bool) (Invoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inv, ok := s.invoices[id]
+	if !ok {
+		return Invoice{}, ErrInvoiceNotFound
+	}
+	if !canTransition(inv.State, to) {
+		return *inv, fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, inv.State, to)
+	}
+	prev := *inv
+	now := time.Now().UTC()
+	inv.History = append(inv.History, Transition{From: inv.State, To: to, At: now, Reason: reason, Manual: manual})
+	inv.State = to
+	inv.UpdatedAt = now
+	return s.commitLocked(inv, prev)
+}
+
+// SetHold pauses (or with a zero time, resumes) automatic dunning for an invoice.
+func (s *InvoiceStore) SetHold(id string, until time.Time) (Invoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	inv, ok := s.invoices[id]
+	if !ok {
+		return Invoice{}, ErrInvoiceNotFound
+	}
+	prev := *inv
+	inv.HoldUntil = until
+	inv.UpdatedAt = time.Now().UTC()
+	return s.commitLocked(inv, prev)
+}
+
+// commitLocked persists a change to inv, restoring prev if that fails so
+// memory never runs ahead of disk; otherwise a restart would undo a change
+// dunning already acted on, and it would act on it again.
+func (s *InvoiceStore) commitLocked(inv *Invoice, prev Invoice) (Invoice, error) {
+	if err := s.persistLocked(); err != nil {
+		*inv = prev
+		return prev, err
+	}
+	return *inv, nil
+}
+
+func (s *InvoiceStore) persistLocked() error {
+	list := make([]*Invoice, 0, len(s.invoices))
+	for _, inv := range s.invoices {
+		list = append(list, inv)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
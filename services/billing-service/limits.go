@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	natsctx "github.com/swarmguard/libs/go/core/natsctx"
+)
+
+const subjectLimitsChanged = "billing.v1.limits.changed"
+
+// EffectiveLimits is what the gateway and orchestrator enforce for a customer:
+// the tier limits, reduced to the downgrade tier while an invoice is in
+// dunning, plus the suspension flag.
+type EffectiveLimits struct {
+	CustomerID    string     `json:"customer_id"`
+	Tier          string     `json:"tier"`
+	EffectiveTier string     `json:"effective_tier"`
+	Limits        TierLimits `json:"limits"`
+	Suspended     bool       `json:"suspended"`
+	Reason        string     `json:"reason,omitempty"`
+	Revision      uint64     `json:"revision"`
+	ChangedAt     time.Time  `json:"changed_at"`
+}
+
+// LimitsPublisher computes effective limits and pushes them on NATS whenever
+// a customer's tier or dunning state changes. Without a NATS connection it
+// only serves the computed view over HTTP.
+type LimitsPublisher struct {
+	customers *CustomerStore
+	invoices  *InvoiceStore
+	tiers     map[string]TierLimits
+	// send publishes one message; nil without a NATS connection.
+	send     func(ctx context.Context, subject string, data []byte) error
+	revision atomic.Uint64
+}
+
+func NewLimitsPublisher(customers *CustomerStore, invoices *InvoiceStore, tiers map[string]TierLimits, nc *nats.Conn) *LimitsPublisher {
+	p := &LimitsPublisher{customers: customers, invoices: invoices, tiers: tiers}
+	if nc != nil {
+		p.send = func(ctx context.Context, subject string, data []byte) error {
+			return natsctx.Publish(ctx, nc, subject, data)
+		}
+	}
+	return p
+}
+
+// Effective derives the current limits for a customer.
+func (p *LimitsPublisher) Effective(customerID string) (EffectiveLimits, bool) {
+	c, ok := p.customers.Get(customerID)
+	if !ok {
+		return EffectiveLimits{}, false
+	}
+	eff := EffectiveLimits{CustomerID: c.ID, Tier: c.Tier, EffectiveTier: c.Tier}
+	for _, inv := range p.invoices.ListByCustomer(c.ID) {
+		switch inv.State {
+		case StateSuspended:
+			eff.Suspended = true
+			eff.Reason = "invoice " + inv.ID + " suspended"
+		case StateDowngrade:
+			eff.EffectiveTier = downgradeTier
+			if eff.Reason == "" {
+				eff.Reason = "invoice " + inv.ID + " in dunning"
+			}
+		}
+	}
+	if eff.Suspended {
+		eff.EffectiveTier = downgradeTier
+	}
+	eff.Limits = p.tiers[eff.EffectiveTier]
+	return eff, true
+}
+
+// CustomerChanged publishes the current effective limits of one customer.
+func (p *LimitsPublisher) CustomerChanged(ctx context.Context, customerID string) {
+	eff, ok := p.Effective(customerID)
+	if !ok {
+		return
+	}
+	p.publish(ctx, eff)
+}
+
+// Resync republishes every customer so subscribers that missed an update
+// (restart, NATS core has no replay) converge without polling.
+func (p *LimitsPublisher) Resync(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, c := range p.customers.List() {
+				p.CustomerChanged(ctx, c.ID)
+			}
+		}
+	}
+}
+
+func (p *LimitsPublisher) publish(ctx context.Context, eff EffectiveLimits) {
+	if p.send == nil {
+		return
+	}
+	// Revisions are wall-clock based so they keep increasing across restarts.
+	now := time.Now().UTC()
+	for {
+		prev := p.revision.Load()
+		next := max(prev+1, uint64(now.UnixNano()))
+		if p.revision.CompareAndSwap(prev, next) {
+			eff.Revision = next
+			break
+		}
+	}
+	eff.ChangedAt = now
+	data, err := json.Marshal(eff)
+	if err != nil {
+		return
+	}
+	if err := p.send(ctx, subjectLimitsChanged, data); err != nil {
+		slog.Warn("limits publish failed", "customer", eff.CustomerID, "error", err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newLimitsPublisher(t *testing.T) (*LimitsPublisher, *CustomerStore, *InvoiceStore) {
+	t.Helper()
+	dir := t.TempDir()
+	customers, err := NewCustomerStore(filepath.Join(dir, "customers.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoices, err := NewInvoiceStore(filepath.Join(dir, "invoices.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []Customer{{ID: "acme", Tier: "pro"}, {ID: "globex", Tier: "pro"}} {
+		if err := customers.Put(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tiers := map[string]TierLimits{"pro": {RequestsPerMinute: 1000}, downgradeTier: {RequestsPerMinute: 10}}
+	return NewLimitsPublisher(customers, invoices, tiers, nil), customers, invoices
+}
+
+func TestEffectiveLimits(t *testing.T) {
+	p, _, invoices := newLimitsPublisher(t)
+	put := func(id, customer string, state InvoiceState) {
+		t.Helper()
+		if err := invoices.Put(Invoice{ID: id, CustomerID: customer, State: state}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(step string, wantTier string, wantSuspended bool) {
+		t.Helper()
+		eff, ok := p.Effective("acme")
+		if !ok || eff.Tier != "pro" || eff.EffectiveTier != wantTier || eff.Suspended != wantSuspended || eff.Limits != p.tiers[wantTier] {
+			t.Fatalf("%s: %+v", step, eff)
+		}
+	}
+	check("no invoices", "pro", false)
+	put("inv-1", "acme", StateDowngrade)
+	put("inv-9", "globex", StateSuspended) // another customer's dunning
+	check("in dunning", downgradeTier, false)
+	put("inv-2", "acme", StateSuspended)
+	check("suspended and downgraded", downgradeTier, true)
+	if eff, _ := p.Effective("acme"); eff.Reason != "invoice inv-2 suspended" {
+		t.Fatalf("suspension must win the reason: %q", eff.Reason)
+	}
+	put("inv-1", "acme", StatePaid)
+	put("inv-2", "acme", StatePaid)
+	check("paid", "pro", false)
+	// An invoice moved to another customer stops counting for the first.
+	put("inv-3", "acme", StateSuspended)
+	put("inv-3", "globex", StateSuspended)
+	check("moved", "pro", false)
+	if _, ok := p.Effective("initech"); ok {
+		t.Fatal("unknown customer has limits")
+	}
+}
+
+func TestLimitsPublishRevisionsIncrease(t *testing.T) {
+	p, customers, invoices := newLimitsPublisher(t)
+	var mu sync.Mutex
+	var sent []EffectiveLimits
+	p.send = func(_ context.Context, subject string, data []byte) error {
+		if subject != subjectLimitsChanged {
+			t.Errorf("subject %q", subject)
+		}
+		var eff EffectiveLimits
+		if err := json.Unmarshal(data, &eff); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		sent = append(sent, eff)
+		mu.Unlock()
+		return nil
+	}
+	if err := invoices.Put(Invoice{ID: "inv-1", CustomerID: "acme", State: StateSuspended}); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, c := range customers.List() {
+				p.CustomerChanged(context.Background(), c.ID)
+			}
+		}()
+	}
+	wg.Wait()
+	p.CustomerChanged(context.Background(), "initech")
+	if len(sent) != 40 {
+		t.Fatalf("published %d updates, want 40", len(sent))
+	}
+	seen := map[uint64]bool{}
+	for _, eff := range sent {
+		if eff.Revision == 0 || seen[eff.Revision] || eff.ChangedAt.IsZero() {
+			t.Fatalf("revision %d reused or missing: %+v", eff.Revision, eff)
+		}
+		seen[eff.Revision] = true
+		if eff.CustomerID == "acme" && !eff.Suspended {
+			t.Fatalf("acme published without its suspension: %+v", eff)
+		}
+	}
+	// Revisions are wall-clock based, so a restarted publisher continues
+	// above the previous one.
+	last := p.revision.Load()
+	restarted, _, _ := newLimitsPublisher(t)
+	var next uint64
+	restarted.send = func(_ context.Context, _ string, data []byte) error {
+		var eff EffectiveLimits
+		_ = json.Unmarshal(data, &eff)
+		next = eff.Revision
+		return nil
+	}
+	time.Sleep(time.Millisecond)
+	restarted.CustomerChanged(context.Background(), "acme")
+	if next <= last {
+		t.Fatalf("revision after restart %d, before %d", next, last)
+	}
+	// Publishing in sequence is strictly monotonic.
+	prev := p.revision.Load()
+	for i := 0; i < 100; i++ {
+		p.CustomerChanged(context.Background(), "globex")
+		if cur := p.revision.Load(); cur <= prev {
+			t.Fatalf("revision went from %d to %d", prev, cur)
+		} else {
+			prev = cur
+		}
+	}
+}
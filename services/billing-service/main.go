@@ -1,13 +1,127 @@
 package main
 
 import (
+	"context"
 	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	nats "github.com/nats-io/nats.go"
+	deprecation "github.com/swarmguard/libs/go/core/deprecation"
 	sloglog "github.com/swarmguard/libs/go/core/logging"
+	registry "github.com/swarmguard/libs/go/core/registry"
 )
 
 func main() {
 	sloglog.Init("billing-service")
 	slog.Info("starting service")
 	// TODO: Usage aggregation + pricing engine
+
+	store, err := NewInvoiceStore(getenv("BILLING_STATE_PATH", "data/invoices.json"))
+	if err != nil {
+		slog.Error("invoice store init failed", "error", err)
+		os.Exit(1)
+	}
+	customers, err := NewCustomerStore(getenv("BILLING_CUSTOMERS_PATH", "data/customers.json"))
+	if err != nil {
+		slog.Error("customer store init failed", "error", err)
+		os.Exit(1)
+	}
+	meter, err := NewUsageMeter(getenv("BILLING_USAGE_PATH", "data/usage.json"))
+	if err != nil {
+		slog.Error("usage meter init failed", "error", err)
+		os.Exit(1)
+	}
+	tiers, err := tiersFromEnv()
+	if err != nil {
+		slog.Error("tier config invalid", "error", err)
+		os.Exit(1)
+	}
+	nc, err := nats.Connect(getenv("NATS_URL", "127.0.0.1:4222"))
+	if err != nil {
+		slog.Warn("nats connect failed, limit changes will not be pushed", "error", err)
+		nc = nil
+	} else {
+		defer nc.Close()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var reg *registry.Registry
+	if spec := os.Getenv("SERVICE_REGISTRY"); spec != "" {
+		backend, err := registry.BackendFromSpec(spec)
+		if err != nil {
+			slog.Error("service registry config invalid", "error", err)
+			os.Exit(1)
+		}
+		reg = registry.New(backend, registry.Options{HealthPath: "/health", Interval: getenvDuration("SERVICE_REGISTRY_INTERVAL", 10*time.Second)})
+		if err := reg.Watch(ctx, "orchestrator"); err != nil {
+			slog.Warn("orchestrator not resolvable yet", "error", err)
+		}
+		reg.Subscribe(func(service string, healthy []string) {
+			slog.Info("service endpoints changed", "service", service, "healthy", healthy)
+		})
+		go reg.Run(ctx)
+	}
+	limits := NewLimitsPublisher(customers, store, tiers, nc)
+	playbook := NewPlaybookClient(reg)
+	dunning := NewDunningManager(store, playbook, dunningPolicyFromEnv())
+	dunning.OnChange(limits.CustomerChanged)
+	go dunning.Run(ctx, getenvDuration("BILLING_DUNNING_INTERVAL", time.Minute))
+	go limits.Resync(ctx, getenvDuration("BILLING_LIMITS_RESYNC_INTERVAL", 5*time.Minute))
+	go meter.Run(ctx, getenvDuration("BILLING_USAGE_FLUSH_INTERVAL", 10*time.Second))
+
+	// Deprecated routes are configured as pattern=deprecated[/sunset[!]][>successor];...
+	deprecations := deprecation.New(nil)
+	if err := deprecations.ParseSpec(os.Getenv("BILLING_DEPRECATED_ROUTES")); err != nil {
+		slog.Error("invalid BILLING_DEPRECATED_ROUTES", "error", err)
+		os.Exit(1)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc("GET /internal/deprecations", deprecations.ReportHandler)
+	registerInvoiceRoutes(mux, store, dunning, meter)
+	registerCustomerRoutes(mux, customers, limits, tiers)
+	registerUsageRoutes(mux, customers, meter)
+	var audit *AuditClient
+	if u := os.Getenv("BILLING_AUDIT_URL"); u != "" {
+		audit = NewAuditClient(u)
+	}
+	registerOnboardingRoutes(mux, NewOnboarder(customers, limits, playbook, audit, tiers))
+
+	srv := &http.Server{Addr: getenv("BILLING_HTTP_ADDR", ":8080"), Handler: deprecations.Middleware(mux)}
+	go func() {
+		slog.Info("http listening", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("http server failed", "error", err)
+			stop()
+		}
+	}()
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+	if err := meter.Flush(); err != nil {
+		slog.Error("usage flush failed", "error", err)
+	}
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+func getenvDuration(k string, def time.Duration) time.Duration {
+	if v := os.Getenv(k); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		slog.Warn("invalid duration, using default", "key", k, "value", v)
+	}
+	return def
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownTier     = errors.New("unknown tier")
+	ErrUnknownWorkflow = errors.New("workflow is not a configured onboarding template")
+)
+
+// OnboardingStep is one entry of the provisioning trail.
+type OnboardingStep struct {
+	Step   string    `json:"step"`
+	Status string    `json:"status"` // done, failed, compensated
+	Error  string    `json:"error,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// OnboardingRequest is the body of POST /v1/onboarding.
+type OnboardingRequest struct {
+	CustomerID string `json:"customer_id"`
+	Name       string `json:"name"`
+	Tier       string `json:"tier"`
+	// Workflows selects a subset of the configured provisioning templates.
+	Workflows []string `json:"workflows,omitempty"`
+}
+
+// AuditClient appends entries to the audit-trail service.
+type AuditClient struct {
+	url  string
+	http *http.Client
+}
+
+func NewAuditClient(url string) *AuditClient {
+	return &AuditClient{url: url, http: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (a *AuditClient) Record(ctx context.Context, stream, action, resource string, data any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]any{
+		"stream": stream, "producer": "billing-service", "action": action,
+		"resource": resource, "data": json.RawMessage(raw),
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url+"/v1/entries", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("audit append: status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Onboarder provisions a tenant in one request: it creates the billing
+// customer, then runs the provisioning workflows (API keys, default policies
+// and workflows) on the orchestrator from templates. Any failure rolls the
+// customer back and runs the rollback workflow for what was already
+// provisioned. Every step is recorded in the audit-trail "onboarding" stream.
+type Onboarder struct {
+	customers *CustomerStore
+	limits    *LimitsPublisher
+	playbook  *PlaybookClient
+	audit     *AuditClient // nil skips the audit trail
+	tiers     map[string]TierLimits
+	workflows []string
+	rollback  string
+}
+
+func NewOnboarder(customers *CustomerStore, limits *LimitsPublisher, playbook *PlaybookClient, audit *AuditClient, tiers map[string]TierLimits) *Onboarder {
+	var workflows []string
+	for _, wf := range strings.Split(getenv("BILLING_ONBOARDING_WORKFLOWS", "tenant-provision-api-keys,tenant-provision-policies,tenant-provision-workflows"), ",") {
+		if wf = strings.TrimSpace(wf); wf != "" {
+			workflows = append(workflows, wf)
+		}
+	}
+	return &Onboarder{
+		customers: customers, limits: limits, playbook: playbook, audit: audit, tiers: tiers,
+		workflows: workflows,
+		rollback:  getenv("BILLING_ONBOARDING_ROLLBACK_WORKFLOW", "tenant-deprovision"),
+	}
+}
+
+// Onboard returns the trail of steps; on error the trail ends with the failed
+// step and its compensations.
+func (o *Onboarder) Onboard(ctx context.Context, req OnboardingRequest) (Customer, []OnboardingStep, error) {
+	limits, ok := o.tiers[req.Tier]
+	if !ok {
+		return Customer{}, nil, ErrUnknownTier
+	}
+	workflows := o.workflows
+	if len(req.Workflows) > 0 {
+		for _, wf := range req.Workflows {
+			if !slices.Contains(o.workflows, wf) {
+				return Customer{}, nil, fmt.Errorf("%w: %q", ErrUnknownWorkflow, wf)
+			}
+		}
+		workflows = req.Workflows
+	}
+	var trail []OnboardingStep
+	step := func(name string, err error) {
+		s := OnboardingStep{Step: name, Status: "done", At: time.Now().UTC()}
+		if err != nil {
+			s.Status, s.Error = "failed", err.Error()
+		}
+		trail = append(trail, s)
+		o.record(ctx, req.CustomerID, s)
+	}
+
+	// Create is atomic, so of two concurrent onboardings of one customer only
+	// one provisions and the other never compensates the winner's customer.
+	err := o.customers.Create(Customer{ID: req.CustomerID, Name: req.Name, Tier: req.Tier})
+	if errors.Is(err, ErrCustomerExists) {
+		return Customer{}, nil, err
+	}
+	step("create-customer", err)
+	if err != nil {
+		return Customer{}, trail, err
+	}
+	params := map[string]any{"customer_id": req.CustomerID, "name": req.Name, "tier": req.Tier, "limits": limits}
+	provisioned := 0
+	for _, wf := range workflows {
+		err = o.playbook.Trigger(ctx, wf, params)
+		step("workflow:"+wf, err)
+		if err != nil {
+			break
+		}
+		provisioned++
+	}
+	if err != nil {
+		trail = o.compensate(ctx, req.CustomerID, provisioned > 0, trail)
+		return Customer{}, trail, err
+	}
+	o.limits.CustomerChanged(ctx, req.CustomerID)
+	c, _ := o.customers.Get(req.CustomerID)
+	slog.Info("tenant onboarded", "customer", c.ID, "tier", c.Tier, "workflows", len(workflows))
+	return c, trail, nil
+}
+
+// compensate undoes a partial onboarding. The rollback workflow only runs if
+// some provisioning workflow was accepted.
+func (o *Onboarder) compensate(ctx context.Context, customerID string, deprovision bool, trail []OnboardingStep) []OnboardingStep {
+	// Compensation must run even if the request was cancelled.
+	ctx = context.WithoutCancel(ctx)
+	undo := func(name string, err error) {
+		s := OnboardingStep{Step: name, Status: "compensated", At: time.Now().UTC()}
+		if err != nil {
+			s.Status, s.Error = "failed", err.Error()
+			slog.Error("onboarding compensation failed", "customer", customerID, "step", name, "error", err)
+		}
+		trail = append(trail, s)
+		o.record(ctx, customerID, s)
+	}
+	if deprovision && o.rollback != "" {
+		undo("workflow:"+o.rollback, o.playbook.Trigger(ctx, o.rollback, map[string]any{"customer_id": customerID}))
+	}
+	undo("delete-customer", o.customers.Delete(customerID))
+	return trail
+}
+
+func (o *Onboarder) record(ctx context.Context, customerID string, s OnboardingStep) {
+	if o.audit == nil {
+		return
+	}
+	if err := o.audit.Record(ctx, "onboarding", "tenant.onboarding."+s.Status, customerID, s); err != nil {
+		slog.Warn("onboarding audit record failed", "customer", customerID, "step", s.Step, "error", err)
+	}
+}
+
+func registerOnboardingRoutes(mux *http.ServeMux, o *Onboarder) {
+	mux.HandleFunc("POST /v1/onboarding", func(w http.ResponseWriter, r *http.Request) {
+		var req OnboardingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CustomerID == "" || req.Tier == "" {
+			writeError(w, http.StatusBadRequest, "customer_id and tier required")
+			return
+		}
+		c, trail, err := o.Onboard(r.Context(), req)
+		switch {
+		case errors.Is(err, ErrUnknownTier), errors.Is(err, ErrUnknownWorkflow):
+			writeError(w, http.StatusBadRequest, err.Error())
+		case errors.Is(err, ErrCustomerExists):
+			writeError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			writeJSON(w, http.StatusBadGateway, map[string]any{"error": err.Error(), "steps": trail})
+		default:
+			writeJSON(w, http.StatusCreated, map[string]any{"customer": c, "steps": trail})
+		}
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOnboardingRollsBackOnWorkflowFailure(t *testing.T) {
+	var mu sync.Mutex
+	var runs []string
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Workflow string `json:"workflow"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		runs = append(runs, body.Workflow)
+		mu.Unlock()
+		if body.Workflow == "provision-policies" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer orch.Close()
+	t.Setenv("ORCHESTRATOR_URL", orch.URL)
+	t.Setenv("BILLING_ONBOARDING_WORKFLOWS", "provision-keys,provision-policies")
+
+	dir := t.TempDir()
+	customers, _ := NewCustomerStore(filepath.Join(dir, "customers.json"))
+	invoices, _ := NewInvoiceStore(filepath.Join(dir, "invoices.json"))
+	limits := NewLimitsPublisher(customers, invoices, defaultTiers, nil)
+	o := NewOnboarder(customers, limits, NewPlaybookClient(nil), nil, defaultTiers)
+
+	req := OnboardingRequest{CustomerID: "acme", Tier: "pro", Workflows: []string{"provision-keys", "provision-policies"}}
+	if _, trail, err := o.Onboard(context.Background(), req); err == nil {
+		t.Fatalf("want error, trail %+v", trail)
+	}
+	if _, ok := customers.Get("acme"); ok {
+		t.Fatal("customer must be rolled back")
+	}
+	if last := runs[len(runs)-1]; last != "tenant-deprovision" {
+		t.Fatalf("want rollback workflow last, runs %v", runs)
+	}
+
+	req.Workflows = []string{"provision-keys"}
+	c, trail, err := o.Onboard(context.Background(), req)
+	if err != nil || c.Tier != "pro" || len(trail) != 2 {
+		t.Fatalf("onboard: %+v %+v %v", c, trail, err)
+	}
+	if _, _, err := o.Onboard(context.Background(), req); err != ErrCustomerExists {
+		t.Fatalf("want ErrCustomerExists, got %v", err)
+	}
+	req = OnboardingRequest{CustomerID: "evil", Tier: "pro", Workflows: []string{"tenant-deprovision"}}
+	if _, _, err := o.Onboard(context.Background(), req); !errors.Is(err, ErrUnknownWorkflow) {
+		t.Fatalf("want ErrUnknownWorkflow, got %v", err)
+	}
+}
+
+func TestConcurrentOnboardingProvisionsOnce(t *testing.T) {
+	var runs atomic.Int32
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { runs.Add(1) }))
+	defer orch.Close()
+	t.Setenv("ORCHESTRATOR_URL", orch.URL)
+	t.Setenv("BILLING_ONBOARDING_WORKFLOWS", "provision-keys")
+
+	dir := t.TempDir()
+	customers, _ := NewCustomerStore(filepath.Join(dir, "customers.json"))
+	invoices, _ := NewInvoiceStore(filepath.Join(dir, "invoices.json"))
+	o := NewOnboarder(customers, NewLimitsPublisher(customers, invoices, defaultTiers, nil), NewPlaybookClient(nil), nil, defaultTiers)
+
+	var wg sync.WaitGroup
+	var created, exists atomic.Int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch _, _, err := o.Onboard(context.Background(), OnboardingRequest{CustomerID: "acme", Tier: "pro"}); {
+			case err == nil:
+				created.Add(1)
+			case errors.Is(err, ErrCustomerExists):
+				exists.Add(1)
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if created.Load() != 1 || exists.Load() != 7 || runs.Load() != 1 {
+		t.Fatalf("created=%d exists=%d workflow runs=%d", created.Load(), exists.Load(), runs.Load())
+	}
+	if _, ok := customers.Get("acme"); !ok {
+		t.Fatal("customer missing")
+	}
+}
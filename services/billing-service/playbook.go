@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	registry "github.com/swarmguard/libs/go/core/registry"
+	resilience "github.com/swarmguard/libs/go/core/resilience"
+)
+
+var errPlaybookCircuitOpen = errors.New("orchestrator circuit open")
+
+// PlaybookClient starts orchestrator workflows over HTTP (POST /v1/run).
+type PlaybookClient struct {
+	endpoint func() (string, error)
+	http     *http.Client
+	breaker  *resilience.CircuitBreaker
+}
+
+// NewPlaybookClient picks orchestrator endpoints from reg when set, otherwise
+// it uses ORCHESTRATOR_URL.
+func NewPlaybookClient(reg *registry.Registry) *PlaybookClient {
+	c := &PlaybookClient{
+		http:    &http.Client{Timeout: 5 * time.Second},
+		breaker: resilience.NewCircuitBreaker(5, 30*time.Second),
+	}
+	if reg != nil {
+		c.endpoint = func() (string, error) { return reg.Pick("orchestrator") }
+	} else {
+		base := getenv("ORCHESTRATOR_URL", "http://orchestrator:8080")
+		c.endpoint = func() (string, error) { return base, nil }
+	}
+	return c
+}
+
+// Trigger starts workflow once. Attempts share an Idempotency-Key, and only
+// failures that happen before a request reaches the orchestrator (no
+// endpoint, connection refused) are retried, so a slow or failed response
+// never starts the workflow twice.
+func (c *PlaybookClient) Trigger(ctx context.Context, workflow string, params map[string]any) error {
+	if !c.breaker.Allow() {
+		return errPlaybookCircuitOpen
+	}
+	body, err := json.Marshal(map[string]any{"workflow": workflow, "parameters": params})
+	if err != nil {
+		return err
+	}
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return err
+	}
+	key := hex.EncodeToString(raw[:])
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+		var sent bool
+		if sent, err = c.run(ctx, workflow, body, key); err == nil || sent {
+			break
+		}
+	}
+	if err != nil {
+		c.breaker.RecordFailure()
+		return err
+	}
+	c.breaker.RecordSuccess()
+	return nil
+}
+
+// run posts one attempt; sent reports whether the request may have reached
+// the orchestrator.
+func (c *PlaybookClient) run(ctx context.Context, workflow string, body []byte, key string) (sent bool, err error) {
+	base, err := c.endpoint()
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/v1/run", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		var op *net.OpError
+		return !errors.As(err, &op) || op.Op != "dial", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return true, fmt.Errorf("orchestrator run %s: status %d", workflow, resp.StatusCode)
+	}
+	return true, nil
+}
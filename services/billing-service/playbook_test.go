@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestPlaybookTriggerRetriesOnlyUndeliveredRequests(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	status := http.StatusInternalServerError
+	orch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		w.WriteHeader(status)
+	}))
+	defer orch.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	c := NewPlaybookClient(nil)
+	c.endpoint = func() (string, error) { return orch.URL, nil }
+	if err := c.Trigger(context.Background(), "wf", nil); err == nil {
+		t.Fatal("want error for status 500")
+	}
+	if len(keys) != 1 {
+		t.Fatalf("a delivered request was retried: %d calls", len(keys))
+	}
+
+	// A refused connection never reached the orchestrator and is retried
+	// against the next endpoint with the same key.
+	status = http.StatusOK
+	endpoints := []string{down.URL, orch.URL}
+	c.endpoint = func() (string, error) {
+		u := endpoints[0]
+		endpoints = endpoints[1:]
+		return u, nil
+	}
+	if err := c.Trigger(context.Background(), "wf", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[1] == "" || keys[1] == keys[0] {
+		t.Fatalf("idempotency keys %q", keys)
+	}
+}
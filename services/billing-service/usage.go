@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UsageCounter meters one metric for one customer in the open billing period.
+// Exact events are counted as-is. Sampled events (1-in-N Bernoulli sampling)
+// contribute a Horvitz-Thompson estimate, weight*N per kept event, and its
+// variance, so a customer switched between modes mid-period is still billed
+// correctly.
+type UsageCounter struct {
+	Metric        string  `json:"metric"`
+	Exact         int64   `json:"exact"`
+	SampledEvents int64   `json:"sampled_events,omitempty"`
+	Estimate      float64 `json:"estimate,omitempty"`
+	Variance      float64 `json:"variance,omitempty"`
+	SampleRate    int     `json:"sample_rate,omitempty"` // last rate applied
+	// Reference is the gateway's aggregate count for reconciliation.
+	Reference    *int64    `json:"reference,omitempty"`
+	ReconciledAt time.Time `json:"reconciled_at,omitempty"`
+}
+
+// Quantity is the billable count: exact plus the rounded estimate.
+func (c UsageCounter) Quantity() int64 { return c.Exact + int64(math.Round(c.Estimate)) }
+
+// ErrorBound is the 95% confidence half-width of Quantity (0 when exact).
+func (c UsageCounter) ErrorBound() int64 { return int64(math.Ceil(1.96 * math.Sqrt(c.Variance))) }
+
+// UsagePeriod is the open metering period of one customer.
+type UsagePeriod struct {
+	CustomerID string                   `json:"customer_id"`
+	Start      time.Time                `json:"start"`
+	Counters   map[string]*UsageCounter `json:"counters"`
+}
+
+// Reconciliation compares the metered quantity with a gateway aggregate.
+type Reconciliation struct {
+	CustomerID  string `json:"customer_id"`
+	Metric      string `json:"metric"`
+	Quantity    int64  `json:"quantity"`
+	ErrorBound  int64  `json:"error_bound"`
+	Reference   int64  `json:"reference"`
+	Deviation   int64  `json:"deviation"`
+	WithinBound bool   `json:"within_bound"`
+}
+
+var ErrNoUsage = errors.New("no usage recorded")
+
+// UsageMeter aggregates usage in memory and flushes it to a JSON file
+// periodically; at high volume only sampled events touch the counters.
+type UsageMeter struct {
+	mu      sync.Mutex
+	path    string
+	periods map[string]*UsagePeriod
+	dirty   bool
+	rand    func() float64
+}
+
+func NewUsageMeter(path string) (*UsageMeter, error) {
+	m := &UsageMeter{path: path, periods: map[string]*UsagePeriod{}, rand: rand.Float64}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.periods); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Record meters count events. sampleRate <= 1 counts exactly; otherwise the
+// record is kept with probability 1/sampleRate. It reports whether the record
+// was kept.
+func (m *UsageMeter) Record(customerID, metric string, count int64, sampleRate int) bool {
+	if sampleRate > 1 && m.rand() >= 1/float64(sampleRate) {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.counterLocked(customerID, metric)
+	if sampleRate <= 1 {
+		c.Exact += count
+	} else {
+		n, w := float64(sampleRate), float64(count)
+		c.SampledEvents++
+		c.Estimate += w * n
+		// Var of the HT estimator per kept record: (1-p)/p^2 * w^2 with p = 1/n.
+		c.Variance += (n - 1) * n * w * w
+	}
+	c.SampleRate = max(sampleRate, 1)
+	m.dirty = true
+	return true
+}
+
+func (m *UsageMeter) counterLocked(customerID, metric string) *UsageCounter {
+	p, ok := m.periods[customerID]
+	if !ok {
+		p = &UsagePeriod{CustomerID: customerID, Start: time.Now().UTC(), Counters: map[string]*UsageCounter{}}
+		m.periods[customerID] = p
+	}
+	c, ok := p.Counters[metric]
+	if !ok {
+		c = &UsageCounter{Metric: metric}
+		p.Counters[metric] = c
+	}
+	return c
+}
+
+// Period returns a copy of the customer's open period.
+func (m *UsageMeter) Period(customerID string) (UsagePeriod, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.periods[customerID]
+	if !ok {
+		return UsagePeriod{}, false
+	}
+	out := UsagePeriod{CustomerID: p.CustomerID, Start: p.Start, Counters: map[string]*UsageCounter{}}
+	for k, c := range p.Counters {
+		cp := *c
+		out.Counters[k] = &cp
+	}
+	return out, true
+}
+
+// Reconcile records the gateway aggregate for a metric and reports whether
+// the metered quantity agrees with it within the sampling error bound.
+func (m *UsageMeter) Reconcile(customerID, metric string, reference int64) (Reconciliation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.periods[customerID]
+	if !ok || p.Counters[metric] == nil {
+		return Reconciliation{}, ErrNoUsage
+	}
+	c := p.Counters[metric]
+	c.Reference, c.ReconciledAt = &reference, time.Now().UTC()
+	m.dirty = true
+	r := Reconciliation{CustomerID: customerID, Metric: metric, Quantity: c.Quantity(), ErrorBound: c.ErrorBound(), Reference: reference}
+	r.Deviation = r.Quantity - reference
+	r.WithinBound = abs64(r.Deviation) <= r.ErrorBound
+	if !r.WithinBound {
+		slog.Warn("usage reconciliation outside error bound", "customer", customerID, "metric", metric, "quantity", r.Quantity, "reference", reference, "bound", r.ErrorBound)
+	}
+	return r, nil
+}
+
+// Close ends the customer's period and returns it as invoice line items,
+// along with the closed period for Reopen. The removal is flushed immediately
+// so a restart cannot bill the period twice.
+func (m *UsageMeter) Close(customerID string) ([]LineItem, *UsagePeriod, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.periods[customerID]
+	if !ok {
+		return nil, nil, nil
+	}
+	delete(m.periods, customerID)
+	m.dirty = true
+	if err := m.flushLocked(); err != nil {
+		m.periods[customerID] = p
+		return nil, nil, err
+	}
+	end := time.Now().UTC()
+	items := make([]LineItem, 0, len(p.Counters))
+	for _, c := range p.Counters {
+		items = append(items, LineItem{
+			Metric:            c.Metric,
+			Quantity:          c.Quantity(),
+			Sampled:           c.SampledEvents > 0,
+			SampleRate:        c.SampleRate,
+			ErrorBound:        c.ErrorBound(),
+			ReferenceQuantity: c.Reference,
+			PeriodStart:       p.Start,
+			PeriodEnd:         end,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Metric < items[j].Metric })
+	return items, p, nil
+}
+
+// Reopen puts a period returned by Close back, e.g. when the invoice it was
+// billed on could not be stored. Usage recorded since Close is merged in.
+func (m *UsageMeter) Reopen(p *UsagePeriod) error {
+	if p == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur, ok := m.periods[p.CustomerID]
+	if !ok {
+		m.periods[p.CustomerID] = p
+		m.dirty = true
+		return m.flushLocked()
+	}
+	if p.Start.Before(cur.Start) {
+		cur.Start = p.Start
+	}
+	for _, c := range p.Counters {
+		dst := m.counterLocked(p.CustomerID, c.Metric)
+		dst.Exact += c.Exact
+		dst.SampledEvents += c.SampledEvents
+		dst.Estimate += c.Estimate
+		dst.Variance += c.Variance
+		if dst.SampleRate == 0 {
+			dst.SampleRate = c.SampleRate
+		}
+		if dst.Reference == nil {
+			dst.Reference, dst.ReconciledAt = c.Reference, c.ReconciledAt
+		}
+	}
+	m.dirty = true
+	return m.flushLocked()
+}
+
+// Run flushes dirty state every interval until ctx is done; callers flush
+// once more after shutdown.
+func (m *UsageMeter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Flush(); err != nil {
+				slog.Warn("usage flush failed", "error", err)
+			}
+		}
+	}
+}
+
+func (m *UsageMeter) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.flushLocked()
+}
+
+func (m *UsageMeter) flushLocked() error {
+	if !m.dirty {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.periods, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return err
+	}
+	m.dirty = false
+	return nil
+}
+
+func abs64(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
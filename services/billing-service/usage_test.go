@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSampledUsageWithinErrorBound(t *testing.T) {
+	m, err := NewUsageMeter(filepath.Join(t.TempDir(), "usage.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.rand = rand.New(rand.NewPCG(1, 2)).Float64
+	const events = 200_000
+	for i := 0; i < events; i++ {
+		m.Record("big", "events", 1, 100)
+	}
+	m.Record("big", "events", 500, 0) // exact traffic after switching modes
+	rec, err := m.Reconcile("big", "events", events+500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.ErrorBound == 0 || !rec.WithinBound {
+		t.Fatalf("reconciliation %+v", rec)
+	}
+	items, _, err := m.Close("big")
+	if err != nil || len(items) != 1 {
+		t.Fatalf("close: %v %+v", err, items)
+	}
+	li := items[0]
+	if !li.Sampled || li.SampleRate != 1 || li.ErrorBound != rec.ErrorBound || *li.ReferenceQuantity != events+500 {
+		t.Fatalf("line item %+v", li)
+	}
+	if _, ok := m.Period("big"); ok {
+		t.Fatal("period must be closed")
+	}
+}
+
+func TestFailedInvoiceReopensUsage(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewUsageMeter(filepath.Join(dir, "usage.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Record("acme", "events", 7, 0)
+	store, err := NewInvoiceStore(filepath.Join(dir, "invoices.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The store's directory is a regular file, so every Put fails.
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store.path = filepath.Join(blocker, "invoices.json")
+	mux := http.NewServeMux()
+	registerInvoiceRoutes(mux, store, nil, m)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/v1/invoices", strings.NewReader(`{"id":"inv-1","customer_id":"acme","bill_usage":true}`)))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status %d", w.Code)
+	}
+	if _, ok := store.Get("inv-1"); ok {
+		t.Fatal("failed invoice must not be stored")
+	}
+	p, ok := m.Period("acme")
+	if !ok || p.Counters["events"].Exact != 7 {
+		t.Fatalf("usage not reopened: %+v", p)
+	}
+	m2, _ := NewUsageMeter(filepath.Join(dir, "usage.json"))
+	if p, ok := m2.Period("acme"); !ok || p.Counters["events"].Exact != 7 {
+		t.Fatal("reopened usage not flushed")
+	}
+}
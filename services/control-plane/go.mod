@@ -3,10 +3,28 @@ module github.com/swarmguard/control-plane
 go 1.22
 
 require (
-	google.golang.org/grpc v1.65.0
 	github.com/nats-io/nats.go v1.33.1
-    github.com/swarmguard/libs/go/core v0.0.0
+	github.com/swarmguard/libs/go/core v0.0.0
+	google.golang.org/grpc v1.65.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/klauspost/compress v1.17.2 // indirect
+	github.com/nats-io/nkeys v0.4.7 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	go.opentelemetry.io/otel v1.28.0 // indirect
+	go.opentelemetry.io/otel/metric v1.28.0 // indirect
+	go.opentelemetry.io/otel/trace v1.28.0 // indirect
+	golang.org/x/crypto v0.23.0 // indirect
+	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/text v0.15.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240528184218-531527333157 // indirect
+	google.golang.org/protobuf v1.34.1 // indirect
 )
 
 replace github.com/swarmguard/proto/gen/go => ../../proto/gen/go
+
 replace github.com/swarmguard/libs/go/core => ../../libs/go/core
@@ -0,0 +1,593 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	apikey "github.com/swarmguard/libs/go/core/apikey"
+	natsctx "github.com/swarmguard/libs/go/core/natsctx"
+)
+
+const subjectPolicyChanged = "policy.v1.changed"
+
+// maxBundleBytes caps a downloaded bundle; OPA bundles are policy and data,
+// not artifacts, so anything larger is treated as a bad source.
+const maxBundleBytes = 64 << 20
+
+// maxBundleHistory bounds the revisions kept on disk for rollback.
+const maxBundleHistory = 10
+
+var (
+	ErrBundleSignature = errors.New("bundle signature invalid")
+	ErrBundleNotFound  = errors.New("bundle revision not found")
+	ErrUnknownPackage  = errors.New("package not in the active bundle")
+)
+
+// BundleInfo describes one fetched OPA bundle. Digest is the sha256 of the
+// bundle archive; Revision comes from its .manifest, or the digest if the
+// bundle has none.
+type BundleInfo struct {
+	Revision    string    `json:"revision"`
+	Digest      string    `json:"digest"`
+	Source      string    `json:"source"`
+	Size        int       `json:"size"`
+	FetchedAt   time.Time `json:"fetched_at"`
+	ActivatedAt time.Time `json:"activated_at,omitempty"`
+	Packages    []string  `json:"packages,omitempty"` // Rego packages declared in the bundle
+}
+
+// bundleState is persisted as state.json next to the stored archives.
+type bundleState struct {
+	Active  *BundleInfo  `json:"active,omitempty"`
+	History []BundleInfo `json:"history"` // newest last
+	ETag    string       `json:"etag,omitempty"`
+	// Pinned is set by a rollback and stops polling from re-activating the
+	// bundle that was rolled back; an explicit fetch clears it.
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// BundleManager pulls signed bundles from an HTTP(S) source, which covers S3
+// through presigned or public URLs. A detached ed25519 signature of the
+// archive (base64) is read from sigURL, which defaults to <url>.sig and must
+// be set explicitly for presigned sources. Only the source without its query
+// string or credentials is ever exposed. Archives are written under
+// dir by digest and state.json is replaced via rename, so activation is
+// atomic across restarts; onActivate runs after every switch.
+type BundleManager struct {
+	dir        string
+	source     string
+	sigURL     string
+	pub        ed25519.PublicKey
+	http       *http.Client
+	onActivate func(BundleInfo)
+
+	mu        sync.RWMutex
+	state     bundleState
+	lastErr   string
+	lastFetch time.Time
+	// lastOK is the last fetch that confirmed the active bundle is current
+	// (new, unchanged or 304); it starts at process start.
+	lastOK         time.Time
+	failuresInARow int
+
+	activations       atomic.Uint64
+	fetchFailures     atomic.Uint64
+	signatureFailures atomic.Uint64
+}
+
+func NewBundleManager(dir, source, sigURL string, pub ed25519.PublicKey, onActivate func(BundleInfo)) (*BundleManager, error) {
+	if source != "" && len(pub) != ed25519.PublicKeySize {
+		return nil, errors.New("bundle source requires an ed25519 public key")
+	}
+	if source != "" && sigURL == "" {
+		u, err := url.Parse(source)
+		if err != nil {
+			return nil, fmt.Errorf("bundle source: %w", err)
+		}
+		if u.RawQuery != "" {
+			return nil, errors.New("bundle source has a query string (presigned URL); configure the signature URL separately")
+		}
+		sigURL = source + ".sig"
+	}
+	m := &BundleManager{dir: dir, source: source, sigURL: sigURL, pub: pub, http: &http.Client{Timeout: 30 * time.Second}, onActivate: onActivate, lastOK: time.Now()}
+	data, err := os.ReadFile(filepath.Join(dir, "state.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &m.state); err != nil {
+		return nil, fmt.Errorf("decode bundle state: %w", err)
+	}
+	return m, nil
+}
+
+// Active returns the active bundle.
+func (m *BundleManager) Active() (BundleInfo, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.state.Active == nil {
+		return BundleInfo{}, false
+	}
+	return *m.state.Active, true
+}
+
+// Revision is the active bundle revision, used in decision cache keys.
+func (m *BundleManager) Revision() string {
+	b, _ := m.Active()
+	return b.Revision
+}
+
+// HasPackage reports whether pkg, or the package of a rule path like
+// "swarm.authz.allow", is declared by the active bundle. Without an active
+// bundle OPA serves whatever it loaded itself, so every package is allowed.
+func (m *BundleManager) HasPackage(pkg string) bool {
+	b, ok := m.Active()
+	if !ok {
+		return true
+	}
+	for _, p := range b.Packages {
+		if pkg == p || strings.HasPrefix(pkg, p+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Path returns the stored archive of the active bundle for the evaluator.
+func (m *BundleManager) Path() (string, bool) {
+	b, ok := m.Active()
+	if !ok {
+		return "", false
+	}
+	return m.archivePath(b.Digest), true
+}
+
+// Fetch downloads the bundle, verifies its signature and activates it.
+// changed is false when the source reports the bundle as unmodified or the
+// digest equals the active one.
+func (m *BundleManager) Fetch(ctx context.Context) (info BundleInfo, changed bool, err error) {
+	if m.source == "" {
+		return BundleInfo{}, false, errors.New("no bundle source configured")
+	}
+	defer func() {
+		m.mu.Lock()
+		m.lastFetch = time.Now().UTC()
+		m.lastErr = ""
+		if err != nil {
+			m.lastErr = err.Error()
+			m.failuresInARow++
+		} else {
+			m.lastOK, m.failuresInARow = m.lastFetch, 0
+		}
+		m.mu.Unlock()
+		if err != nil {
+			m.fetchFailures.Add(1)
+		}
+	}()
+	m.mu.RLock()
+	etag := m.state.ETag
+	m.mu.RUnlock()
+	archive, newETag, err := m.get(ctx, m.source, etag)
+	if err != nil {
+		return BundleInfo{}, false, err
+	}
+	if archive == nil {
+		b, _ := m.Active()
+		return b, false, m.unpin(etag)
+	}
+	sigText, _, err := m.get(ctx, m.sigURL, "")
+	if err != nil {
+		return BundleInfo{}, false, fmt.Errorf("fetch signature: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sigText)))
+	if err != nil || !ed25519.Verify(m.pub, archive, sig) {
+		m.signatureFailures.Add(1)
+		slog.Error("policy bundle signature invalid, keeping active bundle", "source", m.PublicSource())
+		return BundleInfo{}, false, ErrBundleSignature
+	}
+	sum := sha256.Sum256(archive)
+	info = BundleInfo{Digest: hex.EncodeToString(sum[:]), Source: m.PublicSource(), Size: len(archive), FetchedAt: time.Now().UTC()}
+	if cur, ok := m.Active(); ok && cur.Digest == info.Digest {
+		return cur, false, m.unpin(newETag)
+	}
+	if info.Revision, info.Packages, err = inspectBundle(archive); err != nil {
+		return BundleInfo{}, false, err
+	}
+	if info.Revision == "" {
+		info.Revision = "sha256:" + info.Digest[:12]
+	}
+	if err := writeFileAtomic(m.archivePath(info.Digest), archive); err != nil {
+		return BundleInfo{}, false, err
+	}
+	info, err = m.activate(info, newETag, false)
+	return info, err == nil, err
+}
+
+// Activate switches back to a previously fetched revision, e.g. for rollback,
+// and pins it until the next explicit fetch.
+func (m *BundleManager) Activate(revision string) (BundleInfo, error) {
+	m.mu.RLock()
+	var found *BundleInfo
+	for i := len(m.state.History) - 1; i >= 0; i-- {
+		if h := m.state.History[i]; h.Revision == revision || h.Digest == revision {
+			found = &h
+			break
+		}
+	}
+	m.mu.RUnlock()
+	if found == nil {
+		return BundleInfo{}, ErrBundleNotFound
+	}
+	if _, err := os.Stat(m.archivePath(found.Digest)); err != nil {
+		return BundleInfo{}, fmt.Errorf("%w: %v", ErrBundleNotFound, err)
+	}
+	// Dropping the ETag makes the next explicit fetch download the source
+	// bundle again instead of getting 304 for the rolled-back one.
+	return m.activate(*found, "", true)
+}
+
+func (m *BundleManager) activate(info BundleInfo, etag string, pin bool) (BundleInfo, error) {
+	info.ActivatedAt = time.Now().UTC()
+	m.mu.Lock()
+	next := bundleState{Active: &info, ETag: etag, Pinned: pin}
+	for _, h := range m.state.History {
+		if h.Digest != info.Digest {
+			next.History = append(next.History, h)
+		}
+	}
+	next.History = append(next.History, info)
+	var evicted []BundleInfo
+	if n := len(next.History) - maxBundleHistory; n > 0 {
+		evicted, next.History = next.History[:n], next.History[n:]
+	}
+	if err := m.saveLocked(next); err != nil {
+		m.mu.Unlock()
+		return BundleInfo{}, err
+	}
+	m.mu.Unlock()
+	for _, e := range evicted {
+		_ = os.Remove(m.archivePath(e.Digest))
+	}
+	m.activations.Add(1)
+	slog.Info("policy bundle activated", "revision", info.Revision, "digest", info.Digest)
+	if m.onActivate != nil {
+		m.onActivate(info)
+	}
+	return info, nil
+}
+
+// unpin ends a rollback pin when an explicit fetch finds the active bundle
+// is already current, which activate would otherwise have done.
+func (m *BundleManager) unpin(etag string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.state.Pinned {
+		return nil
+	}
+	next := m.state
+	next.Pinned, next.ETag = false, etag
+	if err := m.saveLocked(next); err != nil {
+		return err
+	}
+	slog.Info("policy bundle unpinned", "revision", next.Active.Revision)
+	return nil
+}
+
+// saveLocked writes state.json and then switches to next; m.mu must be held.
+func (m *BundleManager) saveLocked(next bundleState) error {
+	data, err := json.MarshalIndent(next, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(m.dir, "state.json"), data); err != nil {
+		return err
+	}
+	m.state = next
+	return nil
+}
+
+// get fetches rawURL; a nil body with no error means 304 Not Modified.
+// Errors carry the URL redacted since they end up in last_error.
+func (m *BundleManager) get(ctx context.Context, rawURL, etag string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("GET %s: invalid request", redactURL(rawURL))
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := m.http.Do(req)
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			uerr.URL = redactURL(uerr.URL)
+		}
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && etag != "":
+		return nil, etag, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, "", fmt.Errorf("GET %s: status %d", redactURL(rawURL), resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBundleBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(body) > maxBundleBytes {
+		return nil, "", fmt.Errorf("GET %s: bundle exceeds %d bytes", redactURL(rawURL), maxBundleBytes)
+	}
+	return body, resp.Header.Get("ETag"), nil
+}
+
+// Run polls the source every interval until ctx is done. Polling pauses
+// while a rollback is pinned.
+func (m *BundleManager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.mu.RLock()
+			pinned := m.state.Pinned
+			m.mu.RUnlock()
+			if pinned {
+				continue
+			}
+			if _, _, err := m.Fetch(ctx); err != nil {
+				slog.Warn("policy bundle fetch failed", "source", m.PublicSource(), "error", err)
+			}
+		}
+	}
+}
+
+// Stats returns swarm_policy_bundle_activations_total and
+// swarm_policy_bundle_fetch_failures_total.
+func (m *BundleManager) Stats() (activations, fetchFailures uint64) {
+	return m.activations.Load(), m.fetchFailures.Load()
+}
+
+// Fresh reports whether the active bundle can be trusted to be current: the
+// source confirmed it within maxAge and fewer than maxFailures fetches failed
+// in a row. Without a source, or while a rollback is pinned, it is always
+// fresh. Zero limits disable the respective check.
+func (m *BundleManager) Fresh(maxAge time.Duration, maxFailures int) (bool, string) {
+	if m.source == "" {
+		return true, ""
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	switch {
+	case m.state.Pinned:
+		return true, ""
+	case maxFailures > 0 && m.failuresInARow >= maxFailures:
+		return false, fmt.Sprintf("last %d bundle fetches failed: %s", m.failuresInARow, m.lastErr)
+	case maxAge > 0 && time.Since(m.lastOK) > maxAge:
+		return false, fmt.Sprintf("bundle not confirmed for %s", time.Since(m.lastOK).Round(time.Second))
+	}
+	return true, ""
+}
+
+// PublicSource is the source URL without query string or user info, so
+// presigned credentials never reach state.json, events, logs or the API.
+func (m *BundleManager) PublicSource() string {
+	return redactURL(m.source)
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	u.User, u.RawQuery, u.ForceQuery, u.Fragment = nil, "", false, ""
+	return u.String()
+}
+
+// SignatureFailures returns swarm_policy_signature_failures_total: bundles
+// refused because their signature did not verify.
+func (m *BundleManager) SignatureFailures() uint64 { return m.signatureFailures.Load() }
+
+func (m *BundleManager) archivePath(digest string) string {
+	return filepath.Join(m.dir, digest+".tar.gz")
+}
+
+// inspectBundle reads the revision from the bundle's .manifest, if any, and
+// the packages declared by its .rego files.
+func inspectBundle(archive []byte) (revision string, packages []string, err error) {
+	zr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return "", nil, fmt.Errorf("bundle is not gzip: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	seen := map[string]bool{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("read bundle: %w", err)
+		}
+		switch name := strings.TrimPrefix(hdr.Name, "/"); {
+		case name == ".manifest":
+			var manifest struct {
+				Revision string `json:"revision"`
+			}
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return "", nil, fmt.Errorf("bundle manifest: %w", err)
+			}
+			revision = manifest.Revision
+		case strings.HasSuffix(name, ".rego"):
+			sc := bufio.NewScanner(tr)
+			for sc.Scan() {
+				if pkg, ok := strings.CutPrefix(strings.TrimSpace(sc.Text()), "package "); ok {
+					seen[strings.TrimSpace(pkg)] = true
+					break
+				}
+			}
+		}
+	}
+	for pkg := range seen {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+	return revision, packages, nil
+}
+
+// publishPolicyChanged announces an activated bundle on policy.v1.changed so
+// the gateway and orchestrator can drop their own caches.
+func publishPolicyChanged(nc *nats.Conn, info BundleInfo) {
+	data, err := json.Marshal(map[string]any{
+		"revision":     info.Revision,
+		"hash":         info.Digest,
+		"packages":     info.Packages,
+		"source":       info.Source,
+		"activated_at": info.ActivatedAt,
+	})
+	if err == nil {
+		err = natsctx.Publish(context.Background(), nc, subjectPolicyChanged, data)
+	}
+	if err != nil {
+		slog.Warn("policy change publish failed", "revision", info.Revision, "error", err)
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// registerBundleRoutes mounts the bundle API. Routes that change the active
+// policy require an API key from keys.
+func registerBundleRoutes(mux *http.ServeMux, m *BundleManager, keys *apikey.Keys) {
+	mux.HandleFunc("GET /v1/bundles", func(w http.ResponseWriter, _ *http.Request) {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		writeJSON(w, http.StatusOK, map[string]any{
+			"source":     m.PublicSource(),
+			"active":     m.state.Active,
+			"history":    m.state.History,
+			"last_fetch": m.lastFetch,
+			"last_error": m.lastErr,
+			"pinned":     m.state.Pinned,
+		})
+	})
+	// Policy revisions are the bundle revisions kept for rollback.
+	mux.HandleFunc("GET /v1/policies/revisions", func(w http.ResponseWriter, _ *http.Request) {
+		type revision struct {
+			BundleInfo
+			Active bool `json:"active"`
+		}
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		out := make([]revision, 0, len(m.state.History))
+		for i := len(m.state.History) - 1; i >= 0; i-- {
+			h := m.state.History[i]
+			out = append(out, revision{BundleInfo: h, Active: m.state.Active != nil && h.Digest == m.state.Active.Digest})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"revisions": out, "pinned": m.state.Pinned})
+	})
+	mux.HandleFunc("GET /v1/policies/packages", func(w http.ResponseWriter, _ *http.Request) {
+		b, ok := m.Active()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no active bundle"})
+			return
+		}
+		packages := b.Packages
+		if packages == nil {
+			packages = []string{}
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"revision": b.Revision, "packages": packages})
+	})
+	mux.HandleFunc("GET /v1/bundles/active", func(w http.ResponseWriter, _ *http.Request) {
+		b, ok := m.Active()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no active bundle"})
+			return
+		}
+		writeJSON(w, http.StatusOK, b)
+	})
+	// The active archive is served to the OPA evaluator as a bundle server;
+	// the digest is its ETag so OPA only downloads it after an activation.
+	mux.HandleFunc("GET /v1/bundles/active/archive", func(w http.ResponseWriter, r *http.Request) {
+		b, ok := m.Active()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no active bundle"})
+			return
+		}
+		etag := `"` + b.Digest + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/gzip")
+		http.ServeFile(w, r, m.archivePath(b.Digest))
+	})
+	mux.Handle("POST /v1/bundles/fetch", keys.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, changed, err := m.Fetch(r.Context())
+		if err != nil {
+			status := http.StatusBadGateway
+			if errors.Is(err, ErrBundleSignature) {
+				status = http.StatusUnprocessableEntity
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"bundle": b, "changed": changed})
+	})))
+	activate := keys.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := m.Activate(r.PathValue("revision"))
+		if errors.Is(err, ErrBundleNotFound) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		slog.Warn("policy revision rolled back", "revision", b.Revision, "digest", b.Digest)
+		writeJSON(w, http.StatusOK, b)
+	}))
+	mux.Handle("POST /v1/bundles/{revision}/activate", activate)
+	mux.Handle("POST /v1/policies/rollback/{revision}", activate)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
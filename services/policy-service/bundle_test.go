@@ -0,0 +1,260 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	apikey "github.com/swarmguard/libs/go/core/apikey"
+)
+
+func testBundle(t *testing.T, revision string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	manifest := []byte(`{"revision":"` + revision + `"}`)
+	_ = tw.WriteHeader(&tar.Header{Name: "/.manifest", Mode: 0o644, Size: int64(len(manifest))})
+	_, _ = tw.Write(manifest)
+	rego := []byte("# authz rules\npackage swarm.authz\n\ndefault allow := false\n")
+	_ = tw.WriteHeader(&tar.Header{Name: "/authz/policy.rego", Mode: 0o644, Size: int64(len(rego))})
+	_, _ = tw.Write(rego)
+	_ = tw.Close()
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+func TestBundleFetchVerifiesAndActivates(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	archive := testBundle(t, "r1")
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, archive))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/bundle.tar.gz.sig" {
+			_, _ = w.Write([]byte(sig))
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"r1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"r1"`)
+		_, _ = w.Write(archive)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	activated := 0
+	m, err := NewBundleManager(dir, srv.URL+"/bundle.tar.gz", "", pub, func(BundleInfo) { activated++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, changed, err := m.Fetch(context.Background())
+	if err != nil || !changed || b.Revision != "r1" || activated != 1 || len(b.Packages) != 1 || b.Packages[0] != "swarm.authz" {
+		t.Fatalf("first fetch: %+v changed=%v err=%v activated=%d", b, changed, err, activated)
+	}
+	if _, changed, err := m.Fetch(context.Background()); err != nil || changed {
+		t.Fatalf("not-modified fetch: changed=%v err=%v", changed, err)
+	}
+
+	if fresh, reason := m.Fresh(time.Minute, 1); !fresh {
+		t.Fatalf("fresh after fetch: %s", reason)
+	}
+	m.lastOK = time.Now().Add(-2 * time.Minute)
+	if fresh, _ := m.Fresh(time.Minute, 1); fresh {
+		t.Fatal("bundle not confirmed within max age must not be fresh")
+	}
+
+	// A restarted manager resumes the active revision from disk.
+	m2, err := NewBundleManager(dir, srv.URL+"/bundle.tar.gz", "", pub, nil)
+	if err != nil || m2.Revision() != "r1" {
+		t.Fatalf("reload: %q %v", m2.Revision(), err)
+	}
+
+	// A bundle signed with another key is rejected and the active one kept.
+	sig = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte("other")))
+	m2.state.ETag = ""
+	archive = testBundle(t, "r2")
+	if _, _, err := m2.Fetch(context.Background()); !errors.Is(err, ErrBundleSignature) {
+		t.Fatalf("want signature error, got %v", err)
+	}
+	if m2.Revision() != "r1" || m2.SignatureFailures() != 1 {
+		t.Fatalf("active revision %q, signature failures %d", m2.Revision(), m2.SignatureFailures())
+	}
+	if fresh, _ := m2.Fresh(0, 1); fresh {
+		t.Fatal("failed fetch must make the bundle stale")
+	}
+}
+
+func TestBundleRollbackPins(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	archive := testBundle(t, "r1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/b.sig" {
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, archive))))
+			return
+		}
+		_, _ = w.Write(archive)
+	}))
+	defer srv.Close()
+	m, _ := NewBundleManager(t.TempDir(), srv.URL+"/b", "", pub, nil)
+	if _, _, err := m.Fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	archive = testBundle(t, "r2")
+	if _, _, err := m.Fetch(context.Background()); err != nil || m.Revision() != "r2" {
+		t.Fatalf("fetch r2: %q %v", m.Revision(), err)
+	}
+	if _, err := m.Activate("r1"); err != nil || m.Revision() != "r1" || !m.state.Pinned {
+		t.Fatalf("rollback: %q pinned=%v %v", m.Revision(), m.state.Pinned, err)
+	}
+	if _, err := m.Activate("r9"); !errors.Is(err, ErrBundleNotFound) {
+		t.Fatalf("want ErrBundleNotFound, got %v", err)
+	}
+	// An explicit fetch clears the pin.
+	if _, _, err := m.Fetch(context.Background()); err != nil || m.Revision() != "r2" || m.state.Pinned {
+		t.Fatalf("refetch: %q pinned=%v %v", m.Revision(), m.state.Pinned, err)
+	}
+}
+
+func TestBundlePresignedSourceIsRedacted(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	archive := testBundle(t, "r1")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bundle.tar.gz":
+			_, _ = w.Write(archive)
+		case "/bundle.tar.gz.sig":
+			if r.URL.Query().Get("X-Amz-Signature") != "sig" {
+				http.Error(w, "bad presign", http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, archive))))
+		}
+	}))
+	defer srv.Close()
+	source := srv.URL + "/bundle.tar.gz?X-Amz-Credential=AKIA&X-Amz-Signature=secret"
+	if _, err := NewBundleManager(t.TempDir(), source, "", pub, nil); err == nil {
+		t.Fatal("presigned source without a signature URL must be rejected")
+	}
+	dir := t.TempDir()
+	m, err := NewBundleManager(dir, source, srv.URL+"/bundle.tar.gz.sig?X-Amz-Signature=sig", pub, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _, err := m.Fetch(context.Background())
+	if err != nil || b.Source != srv.URL+"/bundle.tar.gz" {
+		t.Fatalf("fetch: source %q err %v", b.Source, err)
+	}
+	state, _ := os.ReadFile(filepath.Join(dir, "state.json"))
+	w := httptest.NewRecorder()
+	mux := http.NewServeMux()
+	registerBundleRoutes(mux, m, nil)
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/v1/bundles", nil))
+	for name, body := range map[string]string{"state.json": string(state), "GET /v1/bundles": w.Body.String()} {
+		if strings.Contains(body, "X-Amz") || strings.Contains(body, "secret") {
+			t.Errorf("%s exposes the presigned query: %s", name, body)
+		}
+	}
+}
+
+func TestBundleFetchUnpinsWhenUnchanged(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	archive := testBundle(t, "r1")
+	notModified := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/b.sig" {
+			_, _ = w.Write([]byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, archive))))
+			return
+		}
+		if notModified {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"x"`)
+		_, _ = w.Write(archive)
+	}))
+	defer srv.Close()
+	dir := t.TempDir()
+	m, _ := NewBundleManager(dir, srv.URL+"/b", "", pub, nil)
+	if _, _, err := m.Fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	archive = testBundle(t, "r2")
+	if _, _, err := m.Fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The source went back to r1, so the fetch after the rollback finds the
+	// same digest and activates nothing.
+	archive = testBundle(t, "r1")
+	if _, err := m.Activate("r1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, changed, err := m.Fetch(context.Background()); err != nil || changed || m.state.Pinned {
+		t.Fatalf("same-digest fetch: changed=%v pinned=%v %v", changed, m.state.Pinned, err)
+	}
+	if m2, _ := NewBundleManager(dir, srv.URL+"/b", "", pub, nil); m2.state.Pinned || m2.state.ETag != `"x"` {
+		t.Fatalf("persisted state: pinned=%v etag=%q", m2.state.Pinned, m2.state.ETag)
+	}
+
+	if _, err := m.Activate("r2"); err != nil {
+		t.Fatal(err)
+	}
+	m.state.ETag = `"x"`
+	notModified = true
+	if _, changed, err := m.Fetch(context.Background()); err != nil || changed || m.state.Pinned {
+		t.Fatalf("304 fetch: changed=%v pinned=%v %v", changed, m.state.Pinned, err)
+	}
+}
+
+func TestBundleAdminRoutesRequireAPIKey(t *testing.T) {
+	m, err := NewBundleManager(t.TempDir(), "", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("admin-key"))
+	keys, err := apikey.Parse("ops=" + hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	registerBundleRoutes(mux, m, keys)
+	call := func(method, path, key string) int {
+		r := httptest.NewRequest(method, path, nil)
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Code
+	}
+	for _, path := range []string{"/v1/bundles/fetch", "/v1/bundles/r1/activate", "/v1/policies/rollback/r1"} {
+		for _, key := range []string{"", "wrong-key"} {
+			if code := call("POST", path, key); code != http.StatusUnauthorized {
+				t.Errorf("POST %s with key %q: status %d, want 401", path, key, code)
+			}
+		}
+	}
+	if code := call("POST", "/v1/policies/rollback/r1", "admin-key"); code != http.StatusNotFound {
+		t.Fatalf("authenticated rollback of an unknown revision: status %d", code)
+	}
+	if code := call("POST", "/v1/bundles/fetch", "admin-key"); code != http.StatusBadGateway {
+		t.Fatalf("authenticated fetch without a source: status %d", code)
+	}
+	if code := call("GET", "/v1/policies/revisions", ""); code != http.StatusOK {
+		t.Fatalf("read routes stay open: status %d", code)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// stableCacheKey identifies one decision: the package queried, the bundle
+// version that answered it and the canonical form of the input. A bundle
+// reload therefore never serves a decision made by the previous policy, and
+// inputs that differ only in key order or number spelling share an entry.
+func stableCacheKey(pkg, bundleVersion string, input any) (key string, canonical []byte, err error) {
+	canonical, err = canonicalJSON(input)
+	if err != nil {
+		return "", nil, err
+	}
+	h := sha256.New()
+	h.Write([]byte(pkg))
+	h.Write([]byte{0})
+	h.Write([]byte(bundleVersion))
+	h.Write([]byte{0})
+	h.Write(canonical)
+	return hex.EncodeToString(h.Sum(nil)), canonical, nil
+}
+
+// canonicalJSON serializes v with object keys sorted at every depth and
+// numbers in their shortest form (1.0, 1e0 and 1 all become 1). A number
+// float64 cannot hold exactly keeps its literal text instead, so distinct
+// large integers or long decimals never share a cache key. Values that
+// are not plain decoded JSON (structs, typed maps) are round-tripped through
+// encoding/json first.
+func canonicalJSON(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, v any) error {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(val))
+	case string:
+		b, _ := json.Marshal(val)
+		buf.Write(b)
+	case float64:
+		return writeNumber(buf, val)
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			buf.WriteString(strconv.FormatInt(i, 10))
+			return nil
+		}
+		if f, err := val.Float64(); err == nil && floatExact(val, f) {
+			return writeNumber(buf, f)
+		}
+		if _, ok := new(big.Float).SetString(string(val)); !ok {
+			return fmt.Errorf("invalid number %q", val)
+		}
+		buf.WriteString(string(val))
+	case map[string]any:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			b, _ := json.Marshal(k)
+			buf.Write(b)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, val[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []any:
+		buf.WriteByte('[')
+		for i, item := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		var generic any
+		if err := dec.Decode(&generic); err != nil {
+			return err
+		}
+		return writeCanonical(buf, generic)
+	}
+	return nil
+}
+
+// floatExact reports whether f is exactly the decimal n. Exponents beyond
+// the float64 range are never exact and are not expanded.
+func floatExact(n json.Number, f float64) bool {
+	s := string(n)
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		if e, err := strconv.Atoi(s[i+1:]); err != nil || e > 400 || e < -400 {
+			return false
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	return ok && r.Cmp(new(big.Rat).SetFloat64(f)) == 0
+}
+
+func writeNumber(buf *bytes.Buffer, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("unsupported number %v", f)
+	}
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		buf.WriteString(strconv.FormatInt(int64(f), 10))
+		return nil
+	}
+	buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+	return nil
+}
+
+type cacheEntry struct {
+	key        string
+	pkg        string
+	canonical  []byte // kept to verify hits and account entry size
+	decision   any
+	generation uint64
+	expiresAt  time.Time // zero when the cache has no TTL
+}
+
+// cacheShard is one independently locked LRU.
+type cacheShard struct {
+	mu     sync.Mutex
+	max    int
+	ll     *list.List
+	items  map[string]*list.Element
+	hits   map[string]uint64
+	misses map[string]uint64
+
+	collisions uint64
+	expired    uint64       // entries dropped on lookup after their TTL
+	bytes      int          // canonical input bytes held
+	maxEntry   int          // largest canonical input seen
+	lockWait   atomic.Int64 // nanoseconds spent waiting for mu
+}
+
+// lock acquires the shard mutex and accounts the time spent waiting for it.
+func (s *cacheShard) lock() {
+	start := time.Now()
+	s.mu.Lock()
+	s.lockWait.Add(int64(time.Since(start)))
+}
+
+// removeLocked drops an element; the caller holds mu.
+func (s *cacheShard) removeLocked(el *list.Element) {
+	e := el.Value.(*cacheEntry)
+	s.ll.Remove(el)
+	delete(s.items, e.key)
+	s.bytes -= len(e.canonical)
+}
+
+// decisionCache is a sharded LRU of evaluation results with hit/miss
+// counters per package. Each shard has its own lock so concurrent decisions
+// on different keys do not contend. Invalidate bumps a generation counter:
+// entries from older generations read as misses and are dropped lazily, so a
+// full flush on policy reload is O(1). With a TTL, entries older than it
+// also read as misses, bounding how long a decision can outlive its inputs'
+// external data.
+type decisionCache struct {
+	shards     []*cacheShard
+	generation atomic.Uint64
+	ttl        time.Duration   // 0 keeps entries until evicted or invalidated
+	log        *DecisionLogger // nil disables decision logging
+	metrics    *evalMetrics
+	now        func() time.Time
+}
+
+func newDecisionCache(size, shards int) *decisionCache {
+	shards = max(shards, 1)
+	perShard := 0
+	if size > 0 {
+		perShard = (size + shards - 1) / shards
+	}
+	c := &decisionCache{shards: make([]*cacheShard, shards), metrics: newEvalMetrics(), now: time.Now}
+	for i := range c.shards {
+		c.shards[i] = &cacheShard{max: perShard, ll: list.New(), items: map[string]*list.Element{}, hits: map[string]uint64{}, misses: map[string]uint64{}}
+	}
+	return c
+}
+
+func (c *decisionCache) shard(key string) *cacheShard {
+	// key is a hex sha256, so its prefix is uniformly distributed.
+	n, _ := strconv.ParseUint(key[:8], 16, 32)
+	return c.shards[n%uint64(len(c.shards))]
+}
+
+// Invalidate discards every cached decision, e.g. after a policy reload.
+func (c *decisionCache) Invalidate() { c.generation.Add(1) }
+
+// Decide returns the cached decision for (pkg, bundleVersion, input) or calls
+// eval and caches its result. noCache skips both lookup and store, for
+// decisions that must always reflect the live policy and data. Every call is
+// recorded in the decision log when one is set.
+func (c *decisionCache) Decide(pkg, bundleVersion string, input any, noCache bool, eval func() (any, error)) (any, error) {
+	start := time.Now()
+	d, cached, canonical, err := c.decide(pkg, bundleVersion, input, noCache, eval)
+	latency := time.Since(start)
+	c.metrics.observe(pkg, decisionOutcome(d, err), latency)
+	if c.log != nil {
+		c.log.Record(pkg, bundleVersion, input, canonical, d, cached, latency, err)
+	}
+	return d, err
+}
+
+func (c *decisionCache) decide(pkg, bundleVersion string, input any, noCache bool, eval func() (any, error)) (d any, cached bool, canonical []byte, err error) {
+	if noCache || c.shards[0].max <= 0 {
+		d, err = eval()
+		return d, false, nil, err
+	}
+	key, canonical, err := stableCacheKey(pkg, bundleVersion, input)
+	if err != nil {
+		d, err = eval()
+		return d, false, nil, err
+	}
+	gen, now := c.generation.Load(), c.now()
+	sh := c.shard(key)
+	if d, ok := sh.get(pkg, key, canonical, gen, now); ok {
+		return d, true, canonical, nil
+	}
+	if d, err = eval(); err != nil {
+		return nil, false, canonical, err
+	}
+	var expiresAt time.Time
+	if c.ttl > 0 {
+		expiresAt = now.Add(c.ttl)
+	}
+	sh.put(pkg, key, canonical, d, gen, expiresAt)
+	return d, false, canonical, nil
+}
+
+func (s *cacheShard) get(pkg, key string, canonical []byte, gen uint64, now time.Time) (any, bool) {
+	s.lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if ok {
+		e := el.Value.(*cacheEntry)
+		switch {
+		case e.generation != gen:
+			s.removeLocked(el)
+			ok = false
+		case !e.expiresAt.IsZero() && !now.Before(e.expiresAt):
+			s.removeLocked(el)
+			s.expired++
+			ok = false
+		case !bytes.Equal(e.canonical, canonical):
+			s.collisions++
+			ok = false
+		}
+	}
+	if !ok {
+		s.misses[pkg]++
+		return nil, false
+	}
+	s.hits[pkg]++
+	s.ll.MoveToFront(el)
+	return el.Value.(*cacheEntry).decision, true
+}
+
+func (s *cacheShard) put(pkg, key string, canonical []byte, d any, gen uint64, expiresAt time.Time) {
+	s.lock()
+	defer s.mu.Unlock()
+	s.maxEntry = max(s.maxEntry, len(canonical))
+	if el, ok := s.items[key]; ok {
+		e := el.Value.(*cacheEntry)
+		s.bytes += len(canonical) - len(e.canonical)
+		e.canonical, e.decision, e.generation, e.expiresAt = canonical, d, gen, expiresAt
+		s.ll.MoveToFront(el)
+		return
+	}
+	s.items[key] = s.ll.PushFront(&cacheEntry{key: key, pkg: pkg, canonical: canonical, decision: d, generation: gen, expiresAt: expiresAt})
+	s.bytes += len(canonical)
+	for s.ll.Len() > s.max {
+		s.removeLocked(s.ll.Back())
+	}
+}
+
+// Stats returns per-package hit and miss counts.
+func (c *decisionCache) Stats() (hits, misses map[string]uint64) {
+	hits, misses = map[string]uint64{}, map[string]uint64{}
+	for _, s := range c.shards {
+		s.lock()
+		for k, v := range s.hits {
+			hits[k] += v
+		}
+		for k, v := range s.misses {
+			misses[k] += v
+		}
+		s.mu.Unlock()
+	}
+	return hits, misses
+}
+
+// SizeStats returns the entry count, canonical input bytes held, the largest
+// canonical input seen and how many key collisions were detected on lookup.
+// Entries of invalidated generations count until they are evicted.
+func (c *decisionCache) SizeStats() (entries, size, maxEntry int, collisions uint64) {
+	for _, s := range c.shards {
+		s.lock()
+		entries += s.ll.Len()
+		size += s.bytes
+		maxEntry = max(maxEntry, s.maxEntry)
+		collisions += s.collisions
+		s.mu.Unlock()
+	}
+	return entries, size, maxEntry, collisions
+}
+
+// Expired returns how many entries were dropped on lookup after their TTL.
+func (c *decisionCache) Expired() (n uint64) {
+	for _, s := range c.shards {
+		s.lock()
+		n += s.expired
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// ShardStats returns per-shard occupancy and cumulative lock wait in seconds,
+// keyed by shard index.
+func (c *decisionCache) ShardStats() (entries, lockWait map[string]float64) {
+	entries, lockWait = map[string]float64{}, map[string]float64{}
+	for i, s := range c.shards {
+		id := strconv.Itoa(i)
+		s.lock()
+		entries[id] = float64(s.ll.Len())
+		s.mu.Unlock()
+		lockWait[id] = time.Duration(s.lockWait.Load()).Seconds()
+	}
+	return entries, lockWait
+}
+
+// noCacheRequested reports whether the caller opted out of the decision
+// cache with "Cache-Control: no-cache" or ?no_cache=true.
+func noCacheRequested(r *http.Request) bool {
+	if strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache") {
+		return true
+	}
+	v, _ := strconv.ParseBool(r.URL.Query().Get("no_cache"))
+	return v
+}
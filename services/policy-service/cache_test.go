@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecisionCacheKeyedByPackageAndVersion(t *testing.T) {
+	c := newDecisionCache(10, 4)
+	evals := 0
+	eval := func() (any, error) { evals++; return evals, nil }
+	input := map[string]any{"subject": "svc-a", "action": "read"}
+
+	c.Decide("swarm.authz", "v1", input, false, eval)
+	c.Decide("swarm.authz", "v1", input, false, eval)
+	if evals != 1 {
+		t.Fatalf("evals = %d, want 1 after a repeated decision", evals)
+	}
+	c.Decide("swarm.authz", "v2", input, false, eval)
+	c.Decide("swarm.quota", "v1", input, false, eval)
+	if evals != 3 {
+		t.Fatalf("evals = %d, want a miss per package and bundle version", evals)
+	}
+	c.Decide("swarm.authz", "v1", input, true, eval)
+	if evals != 4 {
+		t.Fatal("no-cache decision must always evaluate")
+	}
+	c.Invalidate()
+	c.Decide("swarm.authz", "v1", input, false, eval)
+	if evals != 5 {
+		t.Fatal("invalidated decision must be re-evaluated")
+	}
+	hits, misses := c.Stats()
+	if hits["swarm.authz"] != 1 || misses["swarm.authz"] != 3 || misses["swarm.quota"] != 1 {
+		t.Fatalf("hits=%v misses=%v", hits, misses)
+	}
+}
+
+func TestDecisionCacheTTL(t *testing.T) {
+	c := newDecisionCache(10, 1)
+	c.ttl = time.Minute
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	evals := 0
+	eval := func() (any, error) { evals++; return evals, nil }
+	input := map[string]any{"subject": "svc-a"}
+
+	c.Decide("swarm.authz", "v1", input, false, eval)
+	now = now.Add(59 * time.Second)
+	c.Decide("swarm.authz", "v1", input, false, eval)
+	if evals != 1 {
+		t.Fatalf("evals = %d, want a hit within the TTL", evals)
+	}
+	now = now.Add(time.Second)
+	c.Decide("swarm.authz", "v1", input, false, eval)
+	if evals != 2 || c.Expired() != 1 {
+		t.Fatalf("evals = %d expired = %d, want a re-evaluation at the TTL", evals, c.Expired())
+	}
+}
+
+func TestEvaluationMetricsByOutcome(t *testing.T) {
+	c := newDecisionCache(0, 1)
+	c.Decide("swarm.authz", "v1", 1, false, func() (any, error) { return map[string]any{"allow": false}, nil })
+	c.Decide("swarm.authz", "v1", 2, false, func() (any, error) { return true, nil })
+	c.Decide("swarm.quota", "v1", 3, false, func() (any, error) { return nil, errors.New("boom") })
+	var buf strings.Builder
+	c.metrics.write(&buf)
+	for _, want := range []string{
+		`swarm_policy_evaluations_total{package="swarm.authz",outcome="deny"} 1`,
+		`swarm_policy_evaluations_total{package="swarm.authz",outcome="allow"} 1`,
+		`swarm_policy_evaluations_total{package="swarm.quota",outcome="error"} 1`,
+		`swarm_policy_evaluation_duration_seconds_count{package="swarm.authz"} 2`,
+		`swarm_policy_evaluation_duration_seconds_bucket{package="swarm.quota",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %s in\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestStableCacheKeyNestedInputs(t *testing.T) {
+	var a, b any
+	dec := func(s string, v *any) {
+		d := json.NewDecoder(strings.NewReader(s))
+		d.UseNumber()
+		if err := d.Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dec(`{"subject":{"roles":["a","b"],"attrs":{"z":1.0,"a":{"y":true,"x":null}}},"n":1e2}`, &a)
+	dec(`{"n":100,"subject":{"attrs":{"a":{"x":null,"y":true},"z":1},"roles":["a","b"]}}`, &b)
+	ka, ca, err := stableCacheKey("swarm.authz", "v1", a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, _, _ := stableCacheKey("swarm.authz", "v1", b)
+	if ka != kb {
+		t.Fatalf("keys differ for equivalent inputs: %s", ca)
+	}
+	if want := `{"n":100,"subject":{"attrs":{"a":{"x":null,"y":true},"z":1},"roles":["a","b"]}}`; string(ca) != want {
+		t.Fatalf("canonical = %s", ca)
+	}
+	type subject struct {
+		Roles []string `json:"roles"`
+	}
+	kc, _, _ := stableCacheKey("swarm.authz", "v1", map[string]any{"s": subject{Roles: []string{"a"}}})
+	kd, _, _ := stableCacheKey("swarm.authz", "v1", map[string]any{"s": map[string]any{"roles": []any{"a"}}})
+	if kc != kd {
+		t.Fatal("struct and decoded map inputs must share a key")
+	}
+}
+
+func TestStableCacheKeyKeepsLargeNumbersDistinct(t *testing.T) {
+	key := func(s string) (string, string) {
+		var v any
+		d := json.NewDecoder(strings.NewReader(s))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		k, c, err := stableCacheKey("swarm.authz", "v1", v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k, string(c)
+	}
+	for _, pair := range [][2]string{
+		{`{"id":12345678901234567890}`, `{"id":12345678901234567891}`},
+		{`{"id":9007199254740993}`, `{"id":9007199254740992}`},
+		{`{"x":0.1}`, `{"x":0.10000000000000000001}`},
+		{`{"x":1e400}`, `{"x":2e400}`},
+	} {
+		ka, ca := key(pair[0])
+		kb, cb := key(pair[1])
+		if ka == kb {
+			t.Fatalf("%s and %s share a key: %s / %s", pair[0], pair[1], ca, cb)
+		}
+	}
+	if _, c := key(`{"id":12345678901234567891}`); c != `{"id":12345678901234567891}` {
+		t.Fatalf("canonical = %s", c)
+	}
+	// Spellings of a value float64 holds exactly still share a key.
+	ka, _ := key(`{"id":18446744073709551616}`)
+	kb, _ := key(`{"id":1.8446744073709551616e19}`)
+	if ka != kb {
+		t.Fatal("exact spellings of 2^64 must share a key")
+	}
+}
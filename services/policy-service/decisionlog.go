@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	nats "github.com/nats-io/nats.go"
+	natsctx "github.com/swarmguard/libs/go/core/natsctx"
+	resilience "github.com/swarmguard/libs/go/core/resilience"
+)
+
+const subjectDecisionLogged = "policy.v1.decision.logged"
+
+// DecisionRecord is one logged evaluation. The input itself is never logged,
+// only the sha256 of its canonical JSON.
+type DecisionRecord struct {
+	ID        string    `json:"decision_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Package   string    `json:"package"`
+	Revision  string    `json:"revision"`
+	InputHash string    `json:"input_hash"`
+	Result    any       `json:"result,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Cached    bool      `json:"cached"`
+	LatencyMS float64   `json:"latency_ms"`
+}
+
+// DecisionSink ships a batch of records to one destination. A sink that
+// delivers records one at a time returns a *partialShipError on failure so
+// retries resend only the records that were not delivered.
+type DecisionSink interface {
+	Name() string
+	Ship(ctx context.Context, batch []DecisionRecord) error
+}
+
+// partialShipError reports that the first shipped records of a batch were
+// delivered before err.
+type partialShipError struct {
+	shipped int
+	err     error
+}
+
+func (e *partialShipError) Error() string { return e.err.Error() }
+func (e *partialShipError) Unwrap() error { return e.err }
+
+// auditSink appends each record to the audit-trail "policy-decisions" stream.
+// Appends are not idempotent, so a failure reports how far it got.
+type auditSink struct {
+	url  string
+	http *http.Client
+}
+
+func (s *auditSink) Name() string { return "audit-trail" }
+
+func (s *auditSink) Ship(ctx context.Context, batch []DecisionRecord) error {
+	for i, rec := range batch {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return &partialShipError{shipped: i, err: err}
+		}
+		entry := map[string]any{
+			"stream": "policy-decisions", "producer": "policy-service",
+			"action": "policy.evaluate", "resource": rec.Package, "data": json.RawMessage(data),
+		}
+		if err := postJSON(ctx, s.http, s.url+"/v1/entries", entry); err != nil {
+			return &partialShipError{shipped: i, err: err}
+		}
+	}
+	return nil
+}
+
+// httpSink posts each batch as a JSON array.
+type httpSink struct {
+	url  string
+	http *http.Client
+}
+
+func (s *httpSink) Name() string { return "http" }
+
+func (s *httpSink) Ship(ctx context.Context, batch []DecisionRecord) error {
+	return postJSON(ctx, s.http, s.url, batch)
+}
+
+// natsSink publishes each batch as one JSON array message.
+type natsSink struct{ nc *nats.Conn }
+
+func (s *natsSink) Name() string { return "nats" }
+
+func (s *natsSink) Ship(ctx context.Context, batch []DecisionRecord) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	return natsctx.Publish(ctx, s.nc, subjectDecisionLogged, data)
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("POST %s: status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// DecisionLogger records evaluations without blocking them: records go into
+// a bounded buffer and are dropped (and counted) when it is full. Run ships
+// batches to every sink, retrying each a few times before giving the batch up
+// for that sink.
+type DecisionLogger struct {
+	sinks     []DecisionSink
+	queue     chan DecisionRecord
+	batchSize int
+
+	dropped  atomic.Uint64
+	mu       sync.Mutex
+	shipped  map[string]uint64
+	failures map[string]uint64
+}
+
+func NewDecisionLogger(sinks []DecisionSink, buffer, batchSize int) *DecisionLogger {
+	return &DecisionLogger{
+		sinks:     sinks,
+		queue:     make(chan DecisionRecord, max(buffer, 1)),
+		batchSize: max(batchSize, 1),
+		shipped:   map[string]uint64{},
+		failures:  map[string]uint64{},
+	}
+}
+
+// Record logs one evaluation. canonical is the canonical input when the
+// caller already has it; otherwise it is computed here.
+func (l *DecisionLogger) Record(pkg, revision string, input any, canonical []byte, result any, cached bool, latency time.Duration, evalErr error) {
+	if canonical == nil {
+		canonical, _ = canonicalJSON(input)
+	}
+	sum := sha256.Sum256(canonical)
+	rec := DecisionRecord{
+		ID: newDecisionID(), Timestamp: time.Now().UTC(), Package: pkg, Revision: revision,
+		InputHash: hex.EncodeToString(sum[:]), Result: result, Cached: cached,
+		LatencyMS: float64(latency.Microseconds()) / 1000,
+	}
+	if evalErr != nil {
+		rec.Error = evalErr.Error()
+	}
+	select {
+	case l.queue <- rec:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Run ships batches when full or every interval until ctx is done, then
+// drains what is buffered within a short grace period.
+func (l *DecisionLogger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	batch := make([]DecisionRecord, 0, l.batchSize)
+	for {
+		select {
+		case rec := <-l.queue:
+			batch = append(batch, rec)
+			if len(batch) < l.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			drainCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for {
+				select {
+				case rec := <-l.queue:
+					batch = append(batch, rec)
+					if len(batch) >= l.batchSize {
+						l.ship(drainCtx, batch)
+						batch = batch[:0]
+					}
+				default:
+					l.ship(drainCtx, batch)
+					return
+				}
+			}
+		}
+		l.ship(ctx, batch)
+		batch = batch[:0]
+	}
+}
+
+func (l *DecisionLogger) ship(ctx context.Context, batch []DecisionRecord) {
+	if len(batch) == 0 {
+		return
+	}
+	for _, s := range l.sinks {
+		// Retries resume after the records a sink already delivered.
+		pending := batch
+		_, err := resilience.Retry(ctx, 3, 200*time.Millisecond, func() (struct{}, error) {
+			err := s.Ship(ctx, pending)
+			var partial *partialShipError
+			if errors.As(err, &partial) {
+				pending = pending[partial.shipped:]
+			} else if err == nil {
+				pending = nil
+			}
+			return struct{}{}, err
+		})
+		l.mu.Lock()
+		l.shipped[s.Name()] += uint64(len(batch) - len(pending))
+		l.failures[s.Name()] += uint64(len(pending))
+		l.mu.Unlock()
+		if err != nil {
+			slog.Warn("decision log shipping failed", "sink", s.Name(), "records", len(pending), "error", err)
+		}
+	}
+}
+
+// Stats returns records shipped and failed per sink, and records dropped
+// because the buffer was full.
+func (l *DecisionLogger) Stats() (shipped, failed map[string]uint64, dropped uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	shipped, failed = map[string]uint64{}, map[string]uint64{}
+	for k, v := range l.shipped {
+		shipped[k] = v
+	}
+	for k, v := range l.failures {
+		failed[k] = v
+	}
+	return shipped, failed, l.dropped.Load()
+}
+
+func newDecisionID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
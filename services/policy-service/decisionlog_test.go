@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memorySink struct {
+	mu      sync.Mutex
+	records []DecisionRecord
+}
+
+func (s *memorySink) Name() string { return "memory" }
+
+func (s *memorySink) Ship(_ context.Context, batch []DecisionRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, batch...)
+	return nil
+}
+
+func TestDecisionLogRecordsEvaluations(t *testing.T) {
+	sink := &memorySink{}
+	c := newDecisionCache(10, 1)
+	c.log = NewDecisionLogger([]DecisionSink{sink}, 10, 2)
+	eval := func() (any, error) { return map[string]any{"allow": true}, nil }
+	input := map[string]any{"user": "svc-a", "action": "read"}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Decide("swarm.authz", "r1", input, false, eval); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, _ = c.Decide("swarm.authz", "r1", input, true, func() (any, error) { return nil, errors.New("boom") })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { c.log.Run(ctx, time.Hour); close(done) }()
+	cancel()
+	<-done
+
+	if len(sink.records) != 3 {
+		t.Fatalf("want 3 records, got %d", len(sink.records))
+	}
+	first, second, failed := sink.records[0], sink.records[1], sink.records[2]
+	if first.Cached || !second.Cached || first.InputHash != second.InputHash || first.Revision != "r1" {
+		t.Fatalf("unexpected records %+v %+v", first, second)
+	}
+	if failed.Error != "boom" || failed.InputHash != first.InputHash {
+		t.Fatalf("no-cache record %+v", failed)
+	}
+	if shipped, _, dropped := c.log.Stats(); shipped["memory"] != 3 || dropped != 0 {
+		t.Fatalf("stats shipped=%v dropped=%d", shipped, dropped)
+	}
+}
+
+func TestAuditSinkRetriesOnlyUndeliveredRecords(t *testing.T) {
+	var mu sync.Mutex
+	var posts int
+	appended := map[string]int{}
+	audit := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var entry struct {
+			Data DecisionRecord `json:"data"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&entry)
+		mu.Lock()
+		defer mu.Unlock()
+		if posts++; posts == 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		appended[entry.Data.ID]++
+	}))
+	defer audit.Close()
+	l := NewDecisionLogger([]DecisionSink{&auditSink{url: audit.URL, http: audit.Client()}}, 10, 10)
+	batch := []DecisionRecord{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	l.ship(context.Background(), batch)
+	if len(appended) != 3 || appended["a"] != 1 || appended["b"] != 1 || appended["c"] != 1 {
+		t.Fatalf("appended %v", appended)
+	}
+	if shipped, failed, _ := l.Stats(); shipped["audit-trail"] != 3 || failed["audit-trail"] != 0 {
+		t.Fatalf("shipped=%v failed=%v", shipped, failed)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// evalLatencyBuckets are upper bounds in seconds for
+// swarm_policy_evaluation_duration_seconds.
+var evalLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 1}
+
+type latencyHistogram struct {
+	counts []uint64 // per bucket, non-cumulative; last is +Inf
+	sum    float64
+	total  uint64
+}
+
+// evalMetrics counts evaluations per package and outcome and keeps a latency
+// histogram per package, so slow packages and frequent denies stand out.
+type evalMetrics struct {
+	mu        sync.Mutex
+	outcomes  map[[2]string]uint64 // {package, outcome}
+	latencies map[string]*latencyHistogram
+}
+
+func newEvalMetrics() *evalMetrics {
+	return &evalMetrics{outcomes: map[[2]string]uint64{}, latencies: map[string]*latencyHistogram{}}
+}
+
+// decisionOutcome classifies a decision as allow, deny, error or other. A
+// decision is a bool or an object with an "allow" bool.
+func decisionOutcome(d any, err error) string {
+	if err != nil {
+		return "error"
+	}
+	if m, ok := d.(map[string]any); ok {
+		d = m["allow"]
+	}
+	switch d {
+	case true:
+		return "allow"
+	case false:
+		return "deny"
+	}
+	return "other"
+}
+
+func (m *evalMetrics) observe(pkg, outcome string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outcomes[[2]string{pkg, outcome}]++
+	h, ok := m.latencies[pkg]
+	if !ok {
+		h = &latencyHistogram{counts: make([]uint64, len(evalLatencyBuckets)+1)}
+		m.latencies[pkg] = h
+	}
+	sec := latency.Seconds()
+	i := sort.SearchFloat64s(evalLatencyBuckets, sec)
+	h.counts[i]++
+	h.sum += sec
+	h.total++
+}
+
+// write renders swarm_policy_evaluations_total{package,outcome} and
+// swarm_policy_evaluation_duration_seconds{package} in Prometheus text format.
+func (m *evalMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP swarm_policy_evaluations_total Policy evaluations by package and outcome.\n# TYPE swarm_policy_evaluations_total counter\n")
+	keys := make([][2]string, 0, len(m.outcomes))
+	for k := range m.outcomes {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "swarm_policy_evaluations_total{package=%q,outcome=%q} %d\n", k[0], k[1], m.outcomes[k])
+	}
+	const name = "swarm_policy_evaluation_duration_seconds"
+	fmt.Fprintf(w, "# HELP %s Policy evaluation latency, including cache lookups.\n# TYPE %s histogram\n", name, name)
+	pkgs := make([]string, 0, len(m.latencies))
+	for p := range m.latencies {
+		pkgs = append(pkgs, p)
+	}
+	sort.Strings(pkgs)
+	for _, p := range pkgs {
+		h := m.latencies[p]
+		var cum uint64
+		for i, le := range evalLatencyBuckets {
+			cum += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket{package=%q,le=\"%g\"} %d\n", name, p, le, cum)
+		}
+		fmt.Fprintf(w, "%s_bucket{package=%q,le=\"+Inf\"} %d\n", name, p, h.total)
+		fmt.Fprintf(w, "%s_sum{package=%q} %g\n%s_count{package=%q} %d\n", name, p, h.sum, name, p, h.total)
+	}
+}
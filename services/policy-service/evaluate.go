@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// packagePattern is a dotted Rego package name. Anything else is rejected
+// before it is turned into an OPA Data API path, so a package can never
+// address another OPA endpoint.
+var packagePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+var ErrInvalidPackage = errors.New("invalid policy package name")
+
+// maxBatchItems caps the inputs of one batch evaluation.
+const maxBatchItems = 1000
+
+// OPAClient evaluates decisions on OPA servers through their Data API
+// (POST /v1/data/<package path>). OPA loads the active bundle from
+// GET /v1/bundles/active/archive and keeps queries prepared between calls;
+// caching, schema validation and the decision log stay in policy-service.
+// Servers are tried in order: one that is unreachable or answers 5xx fails
+// over to the next.
+type OPAClient struct {
+	urls []string
+	http *http.Client
+
+	mu        sync.Mutex
+	phases    map[string]float64 // OPA query timers in seconds, by phase
+	failovers uint64
+}
+
+// NewOPAClient takes a comma-separated list of OPA server URLs.
+func NewOPAClient(urls string, timeout time.Duration) *OPAClient {
+	c := &OPAClient{http: &http.Client{Timeout: timeout}, phases: map[string]float64{}}
+	for _, u := range strings.Split(urls, ",") {
+		if u = strings.TrimSuffix(strings.TrimSpace(u), "/"); u != "" {
+			c.urls = append(c.urls, u)
+		}
+	}
+	return c
+}
+
+// errOPAUnavailable marks failures another OPA server may not have.
+var errOPAUnavailable = errors.New("opa unavailable")
+
+type opaResult struct {
+	Result      any                `json:"result"`
+	Explanation json.RawMessage    `json:"explanation,omitempty"`
+	Metrics     map[string]float64 `json:"metrics,omitempty"`
+}
+
+// Evaluate queries pkg (e.g. "swarm.authz") with input. An undefined
+// decision is returned as nil.
+func (c *OPAClient) Evaluate(ctx context.Context, pkg string, input any) (any, error) {
+	res, err := c.query(ctx, pkg, input, false)
+	return res.Result, err
+}
+
+// Explain evaluates like Evaluate and also returns OPA's full trace of the
+// query.
+func (c *OPAClient) Explain(ctx context.Context, pkg string, input any) (any, json.RawMessage, error) {
+	res, err := c.query(ctx, pkg, input, true)
+	return res.Result, res.Explanation, err
+}
+
+func (c *OPAClient) query(ctx context.Context, pkg string, input any, explain bool) (opaResult, error) {
+	if !packagePattern.MatchString(pkg) {
+		return opaResult{}, fmt.Errorf("%w: %q", ErrInvalidPackage, pkg)
+	}
+	body, err := json.Marshal(map[string]any{"input": input})
+	if err != nil {
+		return opaResult{}, err
+	}
+	err = fmt.Errorf("%w: no OPA server configured", errOPAUnavailable)
+	for i, base := range c.urls {
+		var res opaResult
+		if res, err = c.queryServer(ctx, base, pkg, body, explain); err == nil {
+			c.observe(res.Metrics, i > 0)
+			return res, nil
+		}
+		if !errors.Is(err, errOPAUnavailable) || ctx.Err() != nil {
+			break
+		}
+	}
+	return opaResult{}, err
+}
+
+func (c *OPAClient) queryServer(ctx context.Context, base, pkg string, body []byte, explain bool) (opaResult, error) {
+	u := base + "/v1/data/" + strings.ReplaceAll(pkg, ".", "/") + "?metrics=true"
+	if explain {
+		u += "&explain=full"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return opaResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return opaResult{}, fmt.Errorf("%w: %v", errOPAUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("opa %s: status %d: %s", pkg, resp.StatusCode, bytes.TrimSpace(msg))
+		if resp.StatusCode >= 500 {
+			err = fmt.Errorf("%w: %v", errOPAUnavailable, err)
+		}
+		return opaResult{}, err
+	}
+	var out opaResult
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&out); err != nil {
+		return opaResult{}, fmt.Errorf("opa %s: %w", pkg, err)
+	}
+	return out, nil
+}
+
+// observe adds OPA's query timers (timer_rego_query_<phase>_ns) to the phase
+// totals. Parse and compile only show up when OPA had to prepare the query,
+// so their share shows how well its prepared query cache works.
+func (c *OPAClient) observe(metrics map[string]float64, failover bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, ns := range metrics {
+		if phase, ok := strings.CutPrefix(name, "timer_rego_query_"); ok {
+			c.phases[strings.TrimSuffix(phase, "_ns")] += ns / 1e9
+		}
+	}
+	if failover {
+		c.failovers++
+	}
+}
+
+// Stats returns swarm_policy_opa_query_phase_seconds_total by phase and
+// swarm_policy_opa_failovers_total.
+func (c *OPAClient) Stats() (phases map[string]float64, failovers uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	phases = make(map[string]float64, len(c.phases))
+	for k, v := range c.phases {
+		phases[k] = v
+	}
+	return phases, c.failovers
+}
+
+// registerEvaluateRoutes serves POST /v1/evaluate/{package} with body
+// {"input": ...}. Packages the active bundle does not declare are 404.
+// Inputs of packages with a registered schema are validated first and
+// rejected with 422 and field errors. Decisions go through the decision
+// cache keyed by the active bundle revision; "Cache-Control: no-cache" or
+// ?no_cache=true bypasses it, and so does ?explain=full, which returns OPA's
+// trace of the query as "explanation".
+//
+// POST /v1/evaluate/batch takes {"package": ..., "inputs": [...]} and returns
+// one result per input, in order, evaluated by up to batchWorkers goroutines.
+// Items fail individually: an invalid input or evaluation error is reported
+// in its own result and does not fail the batch.
+func registerEvaluateRoutes(mux *http.ServeMux, opa *OPAClient, decisions *decisionCache, bundles *BundleManager, schemas *SchemaRegistry, batchWorkers int) {
+	mux.HandleFunc("POST /v1/evaluate/batch", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Package string `json:"package"`
+			Inputs  []any  `json:"inputs"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 16<<20)).Decode(&req); err != nil || len(req.Inputs) == 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must be {\"package\": ..., \"inputs\": [...]}"})
+			return
+		}
+		if len(req.Inputs) > maxBatchItems {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("batch exceeds %d inputs", maxBatchItems)})
+			return
+		}
+		pkg := req.Package
+		if !packagePattern.MatchString(pkg) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": ErrInvalidPackage.Error()})
+			return
+		}
+		if !bundles.HasPackage(pkg) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": ErrUnknownPackage.Error(), "package": pkg})
+			return
+		}
+		// The whole batch is decided against one revision even if a bundle
+		// is activated meanwhile.
+		revision, noCache := bundles.Revision(), noCacheRequested(r)
+		results := make([]batchResult, len(req.Inputs))
+		next := make(chan int)
+		var wg sync.WaitGroup
+		for n := max(1, min(batchWorkers, len(req.Inputs))); n > 0; n-- {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					input := req.Inputs[i]
+					if errs, ok := schemas.Validate(pkg, input); ok && len(errs) > 0 {
+						results[i] = batchResult{Error: "input does not match schema", Fields: errs}
+						continue
+					}
+					d, err := decisions.Decide(pkg, revision, input, noCache, func() (any, error) {
+						return opa.Evaluate(r.Context(), pkg, input)
+					})
+					if err != nil {
+						results[i] = batchResult{Error: err.Error()}
+						continue
+					}
+					results[i] = batchResult{Result: d}
+				}
+			}()
+		}
+		for i := range req.Inputs {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+		writeJSON(w, http.StatusOK, map[string]any{"package": pkg, "revision": revision, "results": results})
+	})
+	mux.HandleFunc("POST /v1/evaluate/{package}", func(w http.ResponseWriter, r *http.Request) {
+		pkg := r.PathValue("package")
+		if !packagePattern.MatchString(pkg) {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": ErrInvalidPackage.Error()})
+			return
+		}
+		if !bundles.HasPackage(pkg) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": ErrUnknownPackage.Error(), "package": pkg})
+			return
+		}
+		var req struct {
+			Input any `json:"input"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must be {\"input\": ...}"})
+			return
+		}
+		if errs, ok := schemas.Validate(pkg, req.Input); ok && len(errs) > 0 {
+			writeSchemaRejection(w, pkg, errs)
+			return
+		}
+		revision := bundles.Revision()
+		explain := r.URL.Query().Get("explain") == "full"
+		var explanation json.RawMessage
+		d, err := decisions.Decide(pkg, revision, req.Input, explain || noCacheRequested(r), func() (any, error) {
+			if explain {
+				d, trace, err := opa.Explain(r.Context(), pkg, req.Input)
+				explanation = trace
+				return d, err
+			}
+			return opa.Evaluate(r.Context(), pkg, req.Input)
+		})
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		out := map[string]any{"package": pkg, "revision": revision, "result": d}
+		if explain {
+			out["explanation"] = explanation
+		}
+		writeJSON(w, http.StatusOK, out)
+	})
+}
+
+// batchResult is the outcome of one batch input: a decision, or an error
+// with the schema violations if validation rejected the input.
+type batchResult struct {
+	Result any          `json:"result,omitempty"`
+	Error  string       `json:"error,omitempty"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
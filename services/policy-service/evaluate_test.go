@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// newEvaluateMux serves the evaluate route against a fake OPA that allows
+// every input except {"fail": true} and counts its queries.
+func newEvaluateMux(t *testing.T, decisions *decisionCache, schemas *SchemaRegistry) (*http.ServeMux, *atomic.Int32) {
+	t.Helper()
+	bundles, err := NewBundleManager(t.TempDir(), "", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newEvaluateMuxWith(t, decisions, schemas, bundles)
+}
+
+func newEvaluateMuxWith(t *testing.T, decisions *decisionCache, schemas *SchemaRegistry, bundles *BundleManager) (*http.ServeMux, *atomic.Int32) {
+	t.Helper()
+	var queries atomic.Int32
+	opa := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/data/swarm/authz" {
+			http.NotFound(w, r)
+			return
+		}
+		queries.Add(1)
+		var body struct {
+			Input map[string]any `json:"input"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.Input["fail"] == true {
+			http.Error(w, "eval failed", http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"result":{"allow":true}}`))
+	}))
+	t.Cleanup(opa.Close)
+	mux := http.NewServeMux()
+	registerEvaluateRoutes(mux, NewOPAClient(opa.URL, 0), decisions, bundles, schemas, 4)
+	return mux, &queries
+}
+
+func evaluate(mux *http.ServeMux, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+	return w
+}
+
+func TestEvaluateUsesDecisionCache(t *testing.T) {
+	mux, queries := newEvaluateMux(t, newDecisionCache(10, 2), NewSchemaRegistry())
+	for _, body := range []string{`{"input":{"user":"a","n":1}}`, `{"input":{"n":1.0,"user":"a"}}`} {
+		w := evaluate(mux, "/v1/evaluate/swarm.authz", body)
+		var out struct {
+			Result map[string]any `json:"result"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&out); w.Code != http.StatusOK || err != nil || out.Result["allow"] != true {
+			t.Fatalf("status %d, result %v, err %v", w.Code, out.Result, err)
+		}
+	}
+	if n := queries.Load(); n != 1 {
+		t.Fatalf("opa queried %d times, want 1 for equivalent inputs", n)
+	}
+	evaluate(mux, "/v1/evaluate/swarm.authz?no_cache=true", `{"input":{"user":"a","n":1}}`)
+	if n := queries.Load(); n != 2 {
+		t.Fatalf("opa queried %d times, want no_cache to bypass the cache", n)
+	}
+	if w := evaluate(mux, "/v1/evaluate/swarm.unknown", `{"input":{}}`); w.Code != http.StatusBadGateway {
+		t.Fatalf("opa error: status %d", w.Code)
+	}
+}
+
+func TestEvaluateFillsCacheMetrics(t *testing.T) {
+	decisions := newDecisionCache(10, 2)
+	mux, _ := newEvaluateMux(t, decisions, NewSchemaRegistry())
+	evaluate(mux, "/v1/evaluate/swarm.authz", `{"input":{"subject":{"roles":["a","b"]}}}`)
+	evaluate(mux, "/v1/evaluate/swarm.authz", `{"input":{"subject":{"roles":["a","b"]}}}`)
+	w := httptest.NewRecorder()
+	writeDecisionCacheMetrics(w, decisions)
+	for _, want := range []string{
+		`swarm_policy_decision_cache_hits_total{package="swarm.authz"} 1`,
+		`swarm_policy_decision_cache_misses_total{package="swarm.authz"} 1`,
+		"swarm_policy_decision_cache_entries 1\n",
+		"swarm_policy_decision_cache_entry_max_bytes 31\n",
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, w.Body)
+		}
+	}
+}
+
+func TestEvaluateFillsEvaluationMetrics(t *testing.T) {
+	decisions := newDecisionCache(10, 2)
+	mux, _ := newEvaluateMux(t, decisions, NewSchemaRegistry())
+	evaluate(mux, "/v1/evaluate/swarm.authz", `{"input":{"user":"a"}}`)
+	evaluate(mux, "/v1/evaluate/swarm.unknown", `{"input":{"user":"a"}}`)
+	var buf strings.Builder
+	decisions.metrics.write(&buf)
+	for _, want := range []string{
+		`swarm_policy_evaluations_total{package="swarm.authz",outcome="allow"} 1`,
+		`swarm_policy_evaluations_total{package="swarm.unknown",outcome="error"} 1`,
+		`swarm_policy_evaluation_duration_seconds_count{package="swarm.authz"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestEvaluateRejectsInvalidInput(t *testing.T) {
+	schemas := NewSchemaRegistry()
+	if err := schemas.Put("swarm.authz", []byte(`{"type":"object","required":["user"],"properties":{"n":{"type":"integer","maximum":5}}}`)); err != nil {
+		t.Fatal(err)
+	}
+	mux, queries := newEvaluateMux(t, newDecisionCache(10, 2), schemas)
+	w := evaluate(mux, "/v1/evaluate/swarm.authz", `{"input":{"n":7}}`)
+	var out struct {
+		Fields []FieldError `json:"fields"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&out); w.Code != http.StatusUnprocessableEntity || err != nil || len(out.Fields) != 2 {
+		t.Fatalf("status %d, fields %+v, err %v", w.Code, out.Fields, err)
+	}
+	if queries.Load() != 0 {
+		t.Fatal("invalid input reached the evaluator")
+	}
+	if w := evaluate(mux, "/v1/evaluate/swarm.authz", `{"input":{"user":"a","n":3}}`); w.Code != http.StatusOK {
+		t.Fatalf("valid input: status %d", w.Code)
+	}
+	if got := schemas.RejectionCounts()["swarm.authz"]; got != 1 {
+		t.Fatalf("rejections = %d, want 1", got)
+	}
+}
+
+func TestEvaluateRejectsPackagePaths(t *testing.T) {
+	mux, queries := newEvaluateMux(t, newDecisionCache(10, 2), NewSchemaRegistry())
+	for _, path := range []string{
+		"/v1/evaluate/%2e%2e",
+		"/v1/evaluate/swarm.%2e%2e",
+		"/v1/evaluate/..%2fpolicies",
+		"/v1/evaluate/swarm%2fauthz",
+		"/v1/evaluate/swarm.authz%2f..%2f..%2fpolicies",
+		"/v1/evaluate/swarm..authz",
+	} {
+		if w := evaluate(mux, path, `{"input":{}}`); w.Code == http.StatusOK {
+			t.Errorf("%s: status %d", path, w.Code)
+		}
+	}
+	if w := evaluate(mux, "/v1/evaluate/swarm%2fauthz", `{"input":{}}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("encoded slash: status %d, want 400", w.Code)
+	}
+	if n := queries.Load(); n != 0 {
+		t.Fatalf("opa queried %d times for invalid packages", n)
+	}
+	if _, err := NewOPAClient("http://opa.invalid", 0).Evaluate(context.Background(), "../policies", nil); !errors.Is(err, ErrInvalidPackage) {
+		t.Fatalf("client: %v", err)
+	}
+}
+
+func TestEvaluateRoutesByBundlePackage(t *testing.T) {
+	bundles, err := NewBundleManager(t.TempDir(), "", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux, queries := newEvaluateMuxWith(t, newDecisionCache(10, 2), NewSchemaRegistry(), bundles)
+	registerBundleRoutes(mux, bundles, nil)
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/v1/policies/packages", nil))
+		return w
+	}
+	if w := get(); w.Code != http.StatusNotFound {
+		t.Fatalf("packages without a bundle: status %d", w.Code)
+	}
+	bundles.state.Active = &BundleInfo{Revision: "r1", Packages: []string{"swarm.authz", "swarm.quota"}}
+
+	w := get()
+	var list struct {
+		Revision string   `json:"revision"`
+		Packages []string `json:"packages"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&list); w.Code != http.StatusOK || err != nil || list.Revision != "r1" || len(list.Packages) != 2 {
+		t.Fatalf("packages: status %d, %+v, %v", w.Code, list, err)
+	}
+	for path, want := range map[string]int{
+		"/v1/evaluate/swarm.authz":       http.StatusOK,
+		"/v1/evaluate/swarm.unknown":     http.StatusNotFound,
+		"/v1/evaluate/swarm":             http.StatusNotFound,
+		"/v1/evaluate/swarm.authzextra":  http.StatusNotFound,
+		"/v1/evaluate/swarm.quota.limit": http.StatusBadGateway, // declared, but the fake OPA has no such document
+	} {
+		if w := evaluate(mux, path, `{"input":{}}`); w.Code != want {
+			t.Errorf("%s: status %d, want %d", path, w.Code, want)
+		}
+	}
+	if n := queries.Load(); n != 1 {
+		t.Fatalf("opa queried %d times for swarm.authz, want 1", n)
+	}
+}
+
+func TestEvaluateBatch(t *testing.T) {
+	schemas := NewSchemaRegistry()
+	if err := schemas.Put("swarm.authz", []byte(`{"type":"object","required":["user"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	mux, queries := newEvaluateMux(t, newDecisionCache(100, 2), schemas)
+	var inputs []string
+	for i := 0; i < 50; i++ {
+		inputs = append(inputs, fmt.Sprintf(`{"user":"u%d"}`, i))
+	}
+	inputs[7] = `{"n":1}`                     // fails validation
+	inputs[13] = `{"user":"u13","fail":true}` // fails evaluation
+	inputs[21] = inputs[20]                   // decided once, possibly served from the cache
+	w := evaluate(mux, "/v1/evaluate/batch", `{"package":"swarm.authz","inputs":[`+strings.Join(inputs, ",")+`]}`)
+	var out struct {
+		Results []struct {
+			Result map[string]any `json:"result"`
+			Error  string         `json:"error"`
+			Fields []FieldError   `json:"fields"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&out); w.Code != http.StatusOK || err != nil || len(out.Results) != len(inputs) {
+		t.Fatalf("status %d, %d results, err %v", w.Code, len(out.Results), err)
+	}
+	for i, res := range out.Results {
+		switch i {
+		case 7:
+			if len(res.Fields) != 1 || res.Result != nil {
+				t.Errorf("item 7: %+v", res)
+			}
+		case 13:
+			if !strings.Contains(res.Error, "eval failed") || res.Result != nil {
+				t.Errorf("item 13: %+v", res)
+			}
+		default:
+			if res.Error != "" || res.Result["allow"] != true {
+				t.Errorf("item %d: %+v", i, res)
+			}
+		}
+	}
+	if n := queries.Load(); n < 48 || n > 49 {
+		t.Fatalf("opa queried %d times for 48 distinct valid inputs", n)
+	}
+
+	tooMany := `{"package":"swarm.authz","inputs":[` + strings.Repeat(`{},`, maxBatchItems) + `{}]}`
+	for body, want := range map[string]int{
+		`{"package":"swarm.authz","inputs":[]}`:   http.StatusBadRequest,
+		`{"package":"swarm/authz","inputs":[{}]}`: http.StatusBadRequest,
+		tooMany: http.StatusRequestEntityTooLarge,
+	} {
+		if w := evaluate(mux, "/v1/evaluate/batch", body); w.Code != want {
+			t.Errorf("%.60s: status %d, want %d", body, w.Code, want)
+		}
+	}
+}
+
+func TestOPAClientFailsOverAndExplains(t *testing.T) {
+	var downHits atomic.Int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downHits.Add(1)
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/data/swarm/authz" {
+			http.NotFound(w, r)
+			return
+		}
+		out := `{"result":{"allow":true},"metrics":{"timer_rego_query_compile_ns":2000000,"timer_rego_query_eval_ns":1000000,"timer_server_handler_ns":5000000}`
+		if r.URL.Query().Get("explain") == "full" {
+			out += `,"explanation":[{"op":"enter"}]`
+		}
+		_, _ = w.Write([]byte(out + "}"))
+	}))
+	defer up.Close()
+
+	opa := NewOPAClient(down.URL+", "+up.URL+"/", 0)
+	if d, err := opa.Evaluate(context.Background(), "swarm.authz", map[string]any{}); err != nil || d.(map[string]any)["allow"] != true {
+		t.Fatalf("failover: %v %v", d, err)
+	}
+	if _, err := opa.Evaluate(context.Background(), "swarm.unknown", nil); err == nil || downHits.Load() != 2 {
+		t.Fatalf("404 from the fallback: %v, first server hit %d times", err, downHits.Load())
+	}
+	phases, failovers := opa.Stats()
+	if failovers != 1 || phases["compile"] != 0.002 || phases["eval"] != 0.001 || len(phases) != 2 {
+		t.Fatalf("phases %v failovers %d", phases, failovers)
+	}
+
+	// A 4xx is the policy's answer and is not retried elsewhere.
+	if _, err := NewOPAClient(up.URL+","+down.URL, 0).Evaluate(context.Background(), "swarm.unknown", nil); err == nil || downHits.Load() != 2 {
+		t.Fatalf("4xx failed over: %v", err)
+	}
+
+	bundles, _ := NewBundleManager(t.TempDir(), "", "", nil, nil)
+	mux := http.NewServeMux()
+	decisions := newDecisionCache(10, 2)
+	registerEvaluateRoutes(mux, NewOPAClient(up.URL, 0), decisions, bundles, NewSchemaRegistry(), 1)
+	for i := 0; i < 2; i++ {
+		w := evaluate(mux, "/v1/evaluate/swarm.authz?explain=full", `{"input":{}}`)
+		var out struct {
+			Explanation []map[string]any `json:"explanation"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&out); w.Code != http.StatusOK || err != nil || len(out.Explanation) != 1 {
+			t.Fatalf("explain: status %d, %+v, %v", w.Code, out, err)
+		}
+	}
+	if entries, _, _, _ := decisions.SizeStats(); entries != 0 {
+		t.Fatalf("explained decisions were cached: %d entries", entries)
+	}
+}
@@ -1,13 +1,337 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
 
+	nats "github.com/nats-io/nats.go"
+	apikey "github.com/swarmguard/libs/go/core/apikey"
+	deprecation "github.com/swarmguard/libs/go/core/deprecation"
 	sloglog "github.com/swarmguard/libs/go/core/logging"
+	resilience "github.com/swarmguard/libs/go/core/resilience"
+	warmup "github.com/swarmguard/libs/go/core/warmup"
 )
 
 func main() {
 	sloglog.Init("policy-service")
 	slog.Info("starting service")
 	// TODO: gRPC server + policy CRUD + version store
+
+	schemas := NewSchemaRegistry()
+	warm := warmup.New()
+	warm.Register("input-schemas", func(context.Context) error {
+		n, err := schemas.LoadDir(getenv("POLICY_SCHEMA_DIR", "schemas"))
+		if err == nil {
+			slog.Info("input schemas loaded", "count", n)
+		}
+		return err
+	})
+	decisions := newDecisionCache(getenvInt("POLICY_DECISION_CACHE_SIZE", 10000), getenvInt("POLICY_DECISION_CACHE_SHARDS", 16))
+	decisions.ttl = getenvDuration("POLICY_DECISION_CACHE_TTL", 5*time.Minute)
+	nc, err := nats.Connect(getenv("NATS_URL", "127.0.0.1:4222"))
+	if err != nil {
+		slog.Warn("nats connect failed, policy changes will not be published", "error", err)
+		nc = nil
+	} else {
+		defer nc.Close()
+	}
+	if sinks := decisionLogSinks(nc); len(sinks) > 0 {
+		decisions.log = NewDecisionLogger(sinks, getenvInt("POLICY_DECISION_LOG_BUFFER", 10000), getenvInt("POLICY_DECISION_LOG_BATCH", 100))
+	}
+	bundlePub, err := base64.StdEncoding.DecodeString(os.Getenv("POLICY_BUNDLE_PUBLIC_KEY"))
+	if err != nil {
+		slog.Error("invalid POLICY_BUNDLE_PUBLIC_KEY", "error", err)
+		os.Exit(1)
+	}
+	// Decisions are keyed by bundle revision already; invalidating drops the
+	// entries of the previous revision instead of letting them age out.
+	bundles, err := NewBundleManager(getenv("POLICY_BUNDLE_DIR", "data/bundles"), os.Getenv("POLICY_BUNDLE_URL"), os.Getenv("POLICY_BUNDLE_SIGNATURE_URL"), bundlePub,
+		func(info BundleInfo) {
+			decisions.Invalidate()
+			if nc != nil {
+				publishPolicyChanged(nc, info)
+			}
+		})
+	if err != nil {
+		slog.Error("bundle manager init failed", "error", err)
+		os.Exit(1)
+	}
+	if bundles.source != "" {
+		// Without a previously activated bundle there is nothing to serve.
+		warm.Register("policy-bundle", func(ctx context.Context) error {
+			if _, ok := bundles.Active(); ok {
+				return nil
+			}
+			_, _, err := bundles.Fetch(ctx)
+			return err
+		})
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Deprecated routes are configured as pattern=deprecated[/sunset[!]][>successor];...
+	deprecations := deprecation.New(nil)
+	if err := deprecations.ParseSpec(os.Getenv("POLICY_DEPRECATED_ROUTES")); err != nil {
+		slog.Error("invalid POLICY_DEPRECATED_ROUTES", "error", err)
+		os.Exit(1)
+	}
+	// POLICY_API_KEYS (tenant=sha256hex;...) authenticates callers for
+	// per-tenant limits, where everyone else is limited per client address,
+	// and is required for routes that change the active policy.
+	keys, err := apikey.Parse(os.Getenv("POLICY_API_KEYS"))
+	if err != nil {
+		slog.Error("invalid POLICY_API_KEYS", "error", err)
+		os.Exit(1)
+	}
+	if keys.Len() == 0 {
+		slog.Warn("POLICY_API_KEYS not set, bundle activation, reload and schema updates are disabled")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc("GET /internal/deprecations", deprecations.ReportHandler)
+	// Ready once warmed up and, with a bundle source, while the active bundle
+	// is fresh, so traffic stops reaching instances with stale policies.
+	bundleMaxAge := getenvDuration("POLICY_BUNDLE_MAX_AGE", 15*time.Minute)
+	bundleMaxFailures := getenvInt("POLICY_BUNDLE_MAX_FAILURES", 3)
+	mux.HandleFunc("GET /ready", func(w http.ResponseWriter, r *http.Request) {
+		if !warm.Ready() {
+			warm.ReadyHandler(w, r)
+			return
+		}
+		if fresh, reason := bundles.Fresh(bundleMaxAge, bundleMaxFailures); !fresh {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]any{"ready": false, "reason": reason})
+			return
+		}
+		warm.ReadyHandler(w, r)
+	})
+	registerBundleRoutes(mux, bundles, keys)
+	registerSchemaRoutes(mux, schemas, keys)
+	// Evaluation is delegated to an OPA server loading the active bundle from
+	// this service; without POLICY_OPA_URL nothing is evaluated and neither
+	// the evaluation nor the decision cache series are exported.
+	// POLICY_OPA_URL may list several servers, tried in order.
+	opaURL := os.Getenv("POLICY_OPA_URL")
+	var opa *OPAClient
+	if opaURL != "" {
+		opa = NewOPAClient(opaURL, getenvDuration("POLICY_OPA_TIMEOUT", 2*time.Second))
+		registerEvaluateRoutes(mux, opa, decisions, bundles, schemas, getenvInt("POLICY_BATCH_WORKERS", 8))
+	} else {
+		slog.Warn("POLICY_OPA_URL not set, /v1/evaluate is disabled")
+	}
+	// Reload re-reads input schemas, pulls the bundle source if configured and
+	// always flushes the decision cache, so no decision survives a reload.
+	// Since it can move the active bundle it needs an API key too.
+	mux.Handle("POST /v1/reload", keys.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer decisions.Invalidate()
+		n, err := schemas.LoadDir(getenv("POLICY_SCHEMA_DIR", "schemas"))
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		out := map[string]any{"schemas": n}
+		if bundles.source != "" {
+			b, changed, err := bundles.Fetch(r.Context())
+			if err != nil {
+				writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+				return
+			}
+			out["bundle"], out["bundle_changed"] = b, changed
+		}
+		writeJSON(w, http.StatusOK, out)
+	})))
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeCounterVec(w, "swarm_policy_input_rejections_total", "Evaluation inputs rejected by schema validation (dry runs excluded).", "schema", schemas.RejectionCounts())
+		if opa != nil {
+			decisions.metrics.write(w)
+			writeDecisionCacheMetrics(w, decisions)
+			phases, failovers := opa.Stats()
+			writeFloatVec(w, "swarm_policy_opa_query_phase_seconds_total", "counter", "Time OPA spent parsing, compiling and evaluating queries.", "phase", phases)
+			writeMetric(w, "swarm_policy_opa_failovers_total", "counter", "Evaluations answered by a fallback OPA server.", float64(failovers))
+		}
+		activations, fetchFailures := bundles.Stats()
+		writeMetric(w, "swarm_policy_bundle_activations_total", "counter", "Policy bundle activations.", float64(activations))
+		writeMetric(w, "swarm_policy_bundle_fetch_failures_total", "counter", "Failed policy bundle fetches, including signature failures.", float64(fetchFailures))
+		writeMetric(w, "swarm_policy_signature_failures_total", "counter", "Policy bundles refused because their signature did not verify.", float64(bundles.SignatureFailures()))
+		writeFloatVec(w, "swarm_warmup_step_duration_seconds", "gauge", "Duration of each cold-start warmup step.", "step", warm.Durations())
+		if decisions.log != nil {
+			shipped, failed, dropped := decisions.log.Stats()
+			writeCounterVec(w, "swarm_policy_decision_log_shipped_total", "Decision records shipped per sink.", "sink", shipped)
+			writeCounterVec(w, "swarm_policy_decision_log_failed_total", "Decision records a sink failed to accept after retries.", "sink", failed)
+			writeMetric(w, "swarm_policy_decision_log_dropped_total", "counter", "Decision records dropped because the log buffer was full.", float64(dropped))
+		}
+		deprecations.WriteMetrics(w)
+	})
+
+	handler := deprecations.Middleware(mux)
+	var limiter *resilience.PerKeyLimiter
+	if rps := getenvInt("POLICY_RATE_LIMIT_RPS", 200); rps > 0 {
+		overrides, err := resilience.ParseKeyLimits(os.Getenv("POLICY_RATE_LIMIT_OVERRIDES"))
+		if err != nil {
+			slog.Error("invalid POLICY_RATE_LIMIT_OVERRIDES", "error", err)
+			os.Exit(1)
+		}
+		limiter = resilience.NewPerKeyLimiter(resilience.KeyLimit{Rate: float64(rps), Burst: float64(getenvInt("POLICY_RATE_LIMIT_BURST", 2*rps))}, overrides, 10*time.Minute, getenvInt("POLICY_RATE_LIMIT_MAX_KEYS", 10000))
+		go limiter.Run(ctx)
+	}
+	// POLICY_PRIORITY_CLASSES splits POLICY_PRIORITY_RPS across classes by
+	// weight; class patterns match the rate limit key ("tenant:<id>" or
+	// "ip:<addr>"), e.g. [{"name":"internal","patterns":["ip:10.*"],"weight":4,"overflow":"queue","max_wait":"50ms"},{"name":"default","weight":1}].
+	var priority *resilience.PriorityLimiter
+	if spec := os.Getenv("POLICY_PRIORITY_CLASSES"); spec != "" {
+		classes, err := resilience.ParsePriorityClasses([]byte(spec))
+		if err != nil {
+			slog.Error("invalid POLICY_PRIORITY_CLASSES", "error", err)
+			os.Exit(1)
+		}
+		total := getenvInt("POLICY_PRIORITY_RPS", 1000)
+		priority = resilience.NewPriorityLimiter(float64(total), float64(getenvInt("POLICY_PRIORITY_BURST", 2*total)), classes)
+	}
+	if limiter != nil || priority != nil {
+		mux.HandleFunc("GET /internal/ratelimit", func(w http.ResponseWriter, _ *http.Request) {
+			out := map[string]any{}
+			if limiter != nil {
+				out["keys"] = limiter.Stats()
+			}
+			if priority != nil {
+				out["classes"] = priority.Stats()
+			}
+			writeJSON(w, http.StatusOK, out)
+		})
+		handler = rateLimited(limiter, priority, keys, handler)
+	}
+	srv := &http.Server{Addr: getenv("POLICY_HTTP_ADDR", ":8181"), Handler: handler}
+	go func() {
+		slog.Info("http listening", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("http server failed", "error", err)
+			stop()
+		}
+	}()
+	// Liveness is served during warmup; readiness flips when it completes.
+	warmErr := warm.Run(ctx, getenvDuration("POLICY_WARMUP_DEADLINE", time.Minute))
+	if warmErr != nil {
+		slog.Error("warmup failed", "error", warmErr)
+		stop()
+	}
+	if decisions.log != nil {
+		go decisions.log.Run(ctx, getenvDuration("POLICY_DECISION_LOG_FLUSH_INTERVAL", 5*time.Second))
+	}
+	if bundles.source != "" {
+		go bundles.Run(ctx, getenvDuration("POLICY_BUNDLE_POLL_INTERVAL", time.Minute))
+	}
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+	if warmErr != nil {
+		os.Exit(1)
+	}
+}
+
+// decisionLogSinks builds the decision log destinations from
+// POLICY_DECISION_LOG_AUDIT_URL, POLICY_DECISION_LOG_HTTP_URL and
+// POLICY_DECISION_LOG_NATS; none configured disables decision logging.
+func decisionLogSinks(nc *nats.Conn) []DecisionSink {
+	client := &http.Client{Timeout: 5 * time.Second}
+	var sinks []DecisionSink
+	if u := os.Getenv("POLICY_DECISION_LOG_AUDIT_URL"); u != "" {
+		sinks = append(sinks, &auditSink{url: u, http: client})
+	}
+	if u := os.Getenv("POLICY_DECISION_LOG_HTTP_URL"); u != "" {
+		sinks = append(sinks, &httpSink{url: u, http: client})
+	}
+	if os.Getenv("POLICY_DECISION_LOG_NATS") == "true" {
+		if nc == nil {
+			slog.Warn("nats unavailable, decisions will not be published")
+		} else {
+			sinks = append(sinks, &natsSink{nc: nc})
+		}
+	}
+	return sinks
+}
+
+// writeDecisionCacheMetrics renders the decision cache hit, size and shard
+// series.
+func writeDecisionCacheMetrics(w http.ResponseWriter, decisions *decisionCache) {
+	hits, misses := decisions.Stats()
+	writeCounterVec(w, "swarm_policy_decision_cache_hits_total", "Decision cache hits.", "package", hits)
+	writeCounterVec(w, "swarm_policy_decision_cache_misses_total", "Decision cache misses.", "package", misses)
+	entries, size, maxEntry, collisions := decisions.SizeStats()
+	writeMetric(w, "swarm_policy_decision_cache_entries", "gauge", "Decisions currently cached.", float64(entries))
+	writeMetric(w, "swarm_policy_decision_cache_bytes", "gauge", "Canonical input bytes held by the decision cache.", float64(size))
+	writeMetric(w, "swarm_policy_decision_cache_entry_max_bytes", "gauge", "Largest canonical input cached.", float64(maxEntry))
+	writeMetric(w, "swarm_policy_decision_cache_expired_total", "counter", "Cached decisions dropped after their TTL.", float64(decisions.Expired()))
+	writeMetric(w, "swarm_policy_decision_cache_key_collisions_total", "counter", "Cache keys that matched a different canonical input.", float64(collisions))
+	shardEntries, lockWait := decisions.ShardStats()
+	writeFloatVec(w, "swarm_policy_decision_cache_shard_entries", "gauge", "Decisions cached per shard.", "shard", shardEntries)
+	writeFloatVec(w, "swarm_policy_decision_cache_shard_lock_wait_seconds_total", "counter", "Time spent waiting for a decision cache shard lock.", "shard", lockWait)
+}
+
+// writeCounterVec renders one labelled counter in Prometheus text format.
+func writeCounterVec(w http.ResponseWriter, name, help, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", name, label, k, values[k])
+	}
+}
+
+// writeFloatVec renders one labelled float series in Prometheus text format.
+func writeFloatVec(w http.ResponseWriter, name, typ, help, label string, values map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %g\n", name, label, k, values[k])
+	}
+}
+
+// writeMetric renders one unlabelled sample in Prometheus text format.
+func writeMetric(w http.ResponseWriter, name, typ, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, typ, name, v)
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+func getenvDuration(k string, def time.Duration) time.Duration {
+	if v := os.Getenv(k); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		slog.Warn("invalid duration, using default", "key", k, "value", v)
+	}
+	return def
+}
+
+func getenvInt(k string, def int) int {
+	if v := os.Getenv(k); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+		slog.Warn("invalid integer, using default", "key", k, "value", v)
+	}
+	return def
 }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	apikey "github.com/swarmguard/libs/go/core/apikey"
+	resilience "github.com/swarmguard/libs/go/core/resilience"
+)
+
+// rateLimitKey identifies the caller by the tenant its API key authenticates,
+// else by the client address. Unverified headers are never used, so callers
+// cannot get a fresh bucket by changing them.
+func rateLimitKey(r *http.Request, keys *apikey.Keys) string {
+	if t, ok := keys.Tenant(r); ok {
+		return "tenant:" + t
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimited applies limits to /v1/ API calls; health, readiness, metrics
+// and internal routes are never limited. A request first needs a token from
+// its caller's bucket (l), then from its priority class's share of the total
+// rate (pl), where it may queue if the class allows. A request the class
+// rejects gets its caller token back. Either may be nil.
+func rateLimited(l *resilience.PerKeyLimiter, pl *resilience.PriorityLimiter, keys *apikey.Keys, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/v1/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := rateLimitKey(r, keys)
+		if l != nil {
+			if ok, wait := l.Allow(key); !ok {
+				writeRateLimited(w, wait)
+				return
+			}
+		}
+		if pl != nil {
+			if err := pl.Wait(r.Context(), key); err != nil {
+				if l != nil {
+					l.Refund(key)
+				}
+				writeRateLimited(w, time.Second)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeRateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": resilience.ErrRateLimited.Error()})
+}
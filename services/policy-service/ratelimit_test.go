@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	apikey "github.com/swarmguard/libs/go/core/apikey"
+	resilience "github.com/swarmguard/libs/go/core/resilience"
+)
+
+func TestRateLimitedPerKey(t *testing.T) {
+	sum := sha256.Sum256([]byte("big-key"))
+	keys, err := apikey.Parse("big=" + hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	overrides := map[string]resilience.KeyLimit{"tenant:big": {Rate: 0, Burst: 3}}
+	l := resilience.NewPerKeyLimiter(resilience.KeyLimit{Rate: 0, Burst: 1}, overrides, time.Minute, 3)
+	h := rateLimited(l, nil, keys, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	call := func(path, addr string, hdr map[string]string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = addr + ":40000"
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if call("/v1/bundles", "10.0.0.1", nil) != http.StatusOK {
+		t.Fatal("default limit must allow one call")
+	}
+	// Unverified identity headers must not buy a fresh bucket.
+	for i, hdr := range []map[string]string{{"X-Tenant-ID": "t1"}, {"X-API-Key": "unknown"}} {
+		if code := call("/v1/bundles", "10.0.0.1", hdr); code != http.StatusTooManyRequests {
+			t.Fatalf("rotated header %d: %d", i, code)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if code := call("/v1/bundles", "10.0.0.1", map[string]string{"X-API-Key": "big-key"}); code != http.StatusOK {
+			t.Fatalf("authenticated call %d: %d", i, code)
+		}
+	}
+	if call("/health", "10.0.0.1", nil) != http.StatusOK {
+		t.Fatal("health must not be limited")
+	}
+	// Past the key cap new addresses share the overflow bucket.
+	for i := 2; i < 6; i++ {
+		call("/v1/bundles", "10.0.0."+strconv.Itoa(i), nil)
+	}
+	stats := l.Stats()
+	if len(stats) != 4 || stats[2].Key != resilience.OverflowKey || stats[2].Rejected != 2 {
+		t.Fatalf("stats %+v", stats)
+	}
+}
+
+func TestRateLimitedPriorityClasses(t *testing.T) {
+	pl := resilience.NewPriorityLimiter(0, 4, []resilience.PriorityClass{
+		{Name: "internal", Patterns: []string{"ip:10.*"}, Weight: 3},
+		{Name: "default", Weight: 1},
+	})
+	h := rateLimited(nil, pl, nil, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	call := func(addr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/bundles", nil)
+		req.RemoteAddr = addr + ":40000"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	// An external burst gets the default share plus what internal holds
+	// above its reserve, never the reserve itself.
+	for i := 0; i < 5; i++ {
+		call("203.0.113.9")
+	}
+	for i := 0; i < 2; i++ {
+		if code := call("10.0.0.1"); code != http.StatusOK {
+			t.Fatalf("internal call %d: %d", i, code)
+		}
+	}
+	if code := call("10.0.0.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("internal share exhausted: %d", code)
+	}
+	if stats := pl.Stats(); stats[1].Allowed != 2 || stats[1].Borrowed != 1 || stats[1].Rejected != 3 {
+		t.Fatalf("default class %+v", stats[1])
+	}
+}
+
+func TestRateLimitedRefundsCallerTokenOnPriorityReject(t *testing.T) {
+	l := resilience.NewPerKeyLimiter(resilience.KeyLimit{Rate: 0, Burst: 2}, nil, time.Minute, 0)
+	pl := resilience.NewPriorityLimiter(0, 1, []resilience.PriorityClass{{Name: "default", Weight: 1}})
+	h := rateLimited(l, pl, nil, http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	call := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/v1/bundles", nil)
+		req.RemoteAddr = "203.0.113.9:40000"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := call(); code != http.StatusOK {
+		t.Fatalf("first call: %d", code)
+	}
+	for i := 0; i < 3; i++ {
+		if code := call(); code != http.StatusTooManyRequests {
+			t.Fatalf("call %d past the total rate: %d", i, code)
+		}
+	}
+	// Requests the priority class rejected must not drain the caller's bucket.
+	stats := l.Stats()
+	if len(stats) != 1 || stats[0].Allowed != 1 || stats[0].Tokens != 1 {
+		t.Fatalf("caller stats %+v", stats)
+	}
+}
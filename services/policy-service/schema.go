@@ -0,0 +1,322 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	apikey "github.com/swarmguard/libs/go/core/apikey"
+)
+
+// Schema is the JSON-schema subset used to validate evaluation inputs:
+// type, required, properties, additionalProperties, items, enum, minimum,
+// maximum, minLength, maxLength and pattern.
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// FieldError points at one invalid input field using a dotted path
+// (e.g. "input.subject.roles[2]").
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (s *Schema) compile() error {
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("pattern %q: %w", s.Pattern, err)
+		}
+		s.re = re
+	}
+	for name, p := range s.Properties {
+		if err := p.compile(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+	return nil
+}
+
+// Validate checks v (decoded with encoding/json) and returns all field errors.
+func (s *Schema) Validate(v any) []FieldError {
+	var errs []FieldError
+	s.validate("input", v, &errs)
+	return errs
+}
+
+func (s *Schema) validate(path string, v any, errs *[]FieldError) {
+	add := func(format string, args ...any) {
+		*errs = append(*errs, FieldError{Field: path, Message: fmt.Sprintf(format, args...)})
+	}
+	if s.Type != "" && !matchesType(s.Type, v) {
+		add("expected %s, got %s", s.Type, jsonType(v))
+		return
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, v) {
+		add("value not in enum")
+	}
+	switch val := v.(type) {
+	case map[string]any:
+		for _, r := range s.Required {
+			if _, ok := val[r]; !ok {
+				*errs = append(*errs, FieldError{Field: path + "." + r, Message: "required"})
+			}
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if p, ok := s.Properties[k]; ok {
+				p.validate(path+"."+k, val[k], errs)
+			} else if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				*errs = append(*errs, FieldError{Field: path + "." + k, Message: "unknown field"})
+			}
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range val {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	case string:
+		if s.MinLength != nil && len(val) < *s.MinLength {
+			add("shorter than %d", *s.MinLength)
+		}
+		if s.MaxLength != nil && len(val) > *s.MaxLength {
+			add("longer than %d", *s.MaxLength)
+		}
+		if s.re != nil && !s.re.MatchString(val) {
+			add("does not match %s", s.Pattern)
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			add("less than minimum %v", *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			add("greater than maximum %v", *s.Maximum)
+		}
+	}
+}
+
+func matchesType(t string, v any) bool {
+	switch t {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return jsonType(v) == t
+}
+
+func jsonType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func inEnum(enum []any, v any) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(v) && jsonType(e) == jsonType(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// SchemaRegistry maps policy packages to input schemas. Packages without a
+// schema are not validated.
+type SchemaRegistry struct {
+	mu       sync.RWMutex
+	schemas  map[string]*Schema
+	rejected map[string]*atomic.Uint64 // per package, read by metrics
+}
+
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{schemas: map[string]*Schema{}, rejected: map[string]*atomic.Uint64{}}
+}
+
+// LoadDir loads every <package>.schema.json in dir, e.g.
+// swarm.authz.schema.json for package swarm.authz. A missing dir is not an error.
+func (r *SchemaRegistry) LoadDir(dir string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.schema.json"))
+	if err != nil {
+		return 0, err
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return 0, err
+		}
+		pkg := strings.TrimSuffix(filepath.Base(f), ".schema.json")
+		if err := r.Put(pkg, b); err != nil {
+			return 0, fmt.Errorf("%s: %w", filepath.Base(f), err)
+		}
+	}
+	return len(files), nil
+}
+
+// Put parses, compiles and registers a schema for pkg.
+func (r *SchemaRegistry) Put(pkg string, raw []byte) error {
+	var s Schema
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return err
+	}
+	if err := s.compile(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schemas[pkg] = &s
+	if r.rejected[pkg] == nil {
+		r.rejected[pkg] = &atomic.Uint64{}
+	}
+	return nil
+}
+
+// Validate returns field errors for input against the schema of pkg and
+// counts the rejection; the evaluate route calls it before every evaluation.
+// ok is false when pkg has no schema.
+func (r *SchemaRegistry) Validate(pkg string, input any) (errs []FieldError, ok bool) {
+	r.mu.RLock()
+	s, ok := r.schemas[pkg]
+	counter := r.rejected[pkg]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	errs = s.Validate(input)
+	if len(errs) > 0 {
+		counter.Add(1)
+	}
+	return errs, true
+}
+
+// Get returns the schema registered for pkg.
+func (r *SchemaRegistry) Get(pkg string) (*Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	sc, ok := r.schemas[pkg]
+	return sc, ok
+}
+
+// Packages lists the packages with a registered schema.
+func (r *SchemaRegistry) Packages() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]string, 0, len(r.schemas))
+	for pkg := range r.schemas {
+		out = append(out, pkg)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// RejectionCounts returns swarm_policy_input_rejections_total per schema.
+func (r *SchemaRegistry) RejectionCounts() map[string]uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make(map[string]uint64, len(r.rejected))
+	for pkg, c := range r.rejected {
+		out[pkg] = c.Load()
+	}
+	return out
+}
+
+// writeSchemaRejection answers an invalid evaluation input with 422 and the
+// field errors instead of letting it evaluate to a silent deny.
+func writeSchemaRejection(w http.ResponseWriter, pkg string, errs []FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	_ = json.NewEncoder(w).Encode(map[string]any{"error": "input does not match schema", "package": pkg, "fields": errs})
+}
+
+// registerSchemaRoutes mounts the schema API. Replacing a schema can loosen
+// input validation, so it requires an API key from keys.
+func registerSchemaRoutes(mux *http.ServeMux, r *SchemaRegistry, keys *apikey.Keys) {
+	mux.HandleFunc("GET /v1/schemas", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"packages": r.Packages()})
+	})
+	mux.HandleFunc("GET /v1/schemas/{package}", func(w http.ResponseWriter, req *http.Request) {
+		sc, ok := r.Get(req.PathValue("package"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no schema for package"})
+			return
+		}
+		writeJSON(w, http.StatusOK, sc)
+	})
+	// Registered schemas live in memory; POLICY_SCHEMA_DIR stays the durable
+	// source and a reload re-reads it.
+	mux.Handle("PUT /v1/schemas/{package}", keys.Require(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		raw, err := io.ReadAll(io.LimitReader(req.Body, 1<<20))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := r.Put(req.PathValue("package"), raw); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid schema: " + err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	// Validate checks an input without evaluating it, for callers and CI.
+	mux.HandleFunc("POST /v1/schemas/{package}/validate", func(w http.ResponseWriter, req *http.Request) {
+		pkg := req.PathValue("package")
+		var input any
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "input must be JSON"})
+			return
+		}
+		// Dry runs use the schema directly so they do not count as rejections.
+		sc, ok := r.Get(pkg)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no schema for package"})
+			return
+		}
+		switch errs := sc.Validate(input); {
+		case len(errs) > 0:
+			writeSchemaRejection(w, pkg, errs)
+		default:
+			writeJSON(w, http.StatusOK, map[string]any{"package": pkg, "valid": true})
+		}
+	})
+}
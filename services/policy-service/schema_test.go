@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	apikey "github.com/swarmguard/libs/go/core/apikey"
+)
+
+func TestSchemaRegistryValidate(t *testing.T) {
+	r := NewSchemaRegistry()
+	err := r.Put("swarm.authz", []byte(`{
+		"type": "object",
+		"required": ["subject", "action"],
+		"additionalProperties": false,
+		"properties": {
+			"subject": {"type": "object", "required": ["id"], "properties": {"id": {"type": "string", "pattern": "^svc-"}}},
+			"action": {"type": "string", "enum": ["read", "write"]},
+			"score": {"type": "number", "minimum": 0, "maximum": 1}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var input any
+	_ = json.Unmarshal([]byte(`{"subject": {"id": "user-1"}, "action": "delete", "score": 2, "extra": true}`), &input)
+	errs, ok := r.Validate("swarm.authz", input)
+	if !ok {
+		t.Fatal("schema not found")
+	}
+	want := map[string]bool{"input.subject.id": true, "input.action": true, "input.score": true, "input.extra": true}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors: %+v", len(errs), errs)
+	}
+	for _, e := range errs {
+		if !want[e.Field] {
+			t.Errorf("unexpected field error %+v", e)
+		}
+	}
+	if got := r.RejectionCounts()["swarm.authz"]; got != 1 {
+		t.Fatalf("rejections = %d, want 1", got)
+	}
+	if _, ok := r.Validate("other.pkg", input); ok {
+		t.Fatal("packages without schema must not be validated")
+	}
+}
+
+func TestSchemaUpdateRequiresAPIKey(t *testing.T) {
+	r := NewSchemaRegistry()
+	if err := r.Put("swarm.authz", []byte(`{"type":"object","required":["user"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte("admin-key"))
+	keys, err := apikey.Parse("ops=" + hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	registerSchemaRoutes(mux, r, keys)
+	put := func(key string) int {
+		req := httptest.NewRequest("PUT", "/v1/schemas/swarm.authz", strings.NewReader(`{"type":"object"}`))
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w.Code
+	}
+	for _, key := range []string{"", "wrong-key"} {
+		if code := put(key); code != http.StatusUnauthorized {
+			t.Fatalf("PUT with key %q: status %d, want 401", key, code)
+		}
+	}
+	if errs, _ := r.Validate("swarm.authz", map[string]any{}); len(errs) != 1 {
+		t.Fatalf("rejected update loosened the schema: %v", errs)
+	}
+	if code := put("admin-key"); code != http.StatusNoContent {
+		t.Fatalf("authenticated PUT: status %d", code)
+	}
+	if errs, _ := r.Validate("swarm.authz", map[string]any{}); len(errs) != 0 {
+		t.Fatalf("schema not replaced: %v", errs)
+	}
+}
@@ -0,0 +1,7 @@
+module github.com/swarmguard/swarm-kv
+
+go 1.22
+
+require github.com/swarmguard/libs/go/core v0.0.0
+
+replace github.com/swarmguard/libs/go/core => ../../libs/go/core
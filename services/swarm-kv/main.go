@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	kv "github.com/swarmguard/libs/go/core/kv"
+	sloglog "github.com/swarmguard/libs/go/core/logging"
+)
+
+func main() {
+	sloglog.Init("swarm-kv")
+	slog.Info("starting service")
+
+	quotas, err := kv.ParseQuotas(os.Getenv("KV_QUOTAS"))
+	if err != nil {
+		slog.Error("invalid KV_QUOTAS", "error", err)
+		os.Exit(1)
+	}
+	def := kv.Quota{MaxKeys: getenvInt("KV_DEFAULT_MAX_KEYS", 100000), MaxBytes: getenvInt("KV_DEFAULT_MAX_BYTES", 64<<20)}
+	// KV_TOTAL_* caps the whole store, whatever namespaces callers pick.
+	total := kv.Quota{MaxKeys: getenvInt("KV_TOTAL_MAX_KEYS", 1000000), MaxBytes: getenvInt("KV_TOTAL_MAX_BYTES", 512<<20)}
+	store := kv.NewMemory(def, quotas, total, getenvDuration("KV_MAX_TTL", 24*time.Hour))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go store.Run(ctx, getenvDuration("KV_SWEEP_INTERVAL", 10*time.Second))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		store.WriteMetrics(w)
+	})
+	kv.Register(mux, store)
+
+	srv := &http.Server{Addr: getenv("KV_HTTP_ADDR", ":8080"), Handler: mux}
+	go func() {
+		slog.Info("http listening", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("http server failed", "error", err)
+			stop()
+		}
+	}()
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+func getenvDuration(k string, def time.Duration) time.Duration {
+	if v := os.Getenv(k); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		slog.Warn("invalid duration, using default", "key", k, "value", v)
+	}
+	return def
+}
+
+func getenvInt(k string, def int) int {
+	if v := os.Getenv(k); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+		slog.Warn("invalid integer, using default", "key", k, "value", v)
+	}
+	return def
+}
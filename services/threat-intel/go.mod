@@ -2,6 +2,22 @@ module github.com/swarmguard/threat-intel
 
 go 1.22
 
-require github.com/swarmguard/libs/go/core v0.0.0
+require (
+	github.com/nats-io/nats.go v1.33.1
+	github.com/swarmguard/libs/go/core v0.0.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/klauspost/compress v1.17.2 // indirect
+	github.com/nats-io/nkeys v0.4.7 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	go.opentelemetry.io/otel v1.28.0 // indirect
+	go.opentelemetry.io/otel/metric v1.28.0 // indirect
+	go.opentelemetry.io/otel/trace v1.28.0 // indirect
+	golang.org/x/crypto v0.18.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+)
 
 replace github.com/swarmguard/libs/go/core => ../../libs/go/core
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Edge links two indicators, e.g. a domain resolving to an IP or a hash seen
+// contacting a URL. Weight is a 0..1 strength of the relation.
+type Edge struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Relation  string    `json:"relation"`
+	Weight    float64   `json:"weight"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (e Edge) key() string { return e.From + "|" + e.To + "|" + e.Relation }
+
+// ThreatGraph stores relations between indicators with JSON file persistence.
+type ThreatGraph struct {
+	mu    sync.RWMutex
+	path  string
+	edges map[string]*Edge
+}
+
+func NewThreatGraph(path string) (*ThreatGraph, error) {
+	g := &ThreatGraph{path: path, edges: map[string]*Edge{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*Edge
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	for _, e := range list {
+		g.edges[e.key()] = e
+	}
+	return g, nil
+}
+
+// AddEdge inserts or updates a relation; the weight of a repeated relation
+// only grows.
+func (g *ThreatGraph) AddEdge(e Edge) (Edge, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	e.UpdatedAt = time.Now().UTC()
+	if cur, ok := g.edges[e.key()]; ok {
+		cur.Weight = max(cur.Weight, e.Weight)
+		cur.UpdatedAt = e.UpdatedAt
+		return *cur, g.persistLocked()
+	}
+	g.edges[e.key()] = &e
+	return e, g.persistLocked()
+}
+
+func (g *ThreatGraph) Edges() []Edge {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	out := make([]Edge, 0, len(g.edges))
+	for _, e := range g.edges {
+		out = append(out, *e)
+	}
+	return out
+}
+
+func (g *ThreatGraph) persistLocked() error {
+	list := make([]*Edge, 0, len(g.edges))
+	for _, e := range g.edges {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].key() < list[j].key() })
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(g.path), 0o755); err != nil {
+		return err
+	}
+	tmp := g.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, g.path)
+}
+
+// Campaign is a candidate grouping of related indicators.
+type Campaign struct {
+	ID       string   `json:"id"`
+	Members  []string `json:"members"`
+	Edges    int      `json:"edges"`
+	Score    float64  `json:"score"` // mean member score
+	MaxScore float64  `json:"max_score"`
+}
+
+// clusterCampaigns groups indicators into connected components over edges of
+// at least minWeight and keeps components with at least minSize members.
+// Edges to unknown or expired indicators are ignored. A campaign is named
+// after its smallest member ID so it stays stable while the cluster grows.
+func clusterCampaigns(edges []Edge, scores map[string]float64, minWeight float64, minSize int) []Campaign {
+	parent := map[string]string{}
+	var find func(string) string
+	find = func(x string) string {
+		if parent[x] != x {
+			parent[x] = find(parent[x])
+		}
+		return parent[x]
+	}
+	union := func(a, b string) {
+		ra, rb := find(a), find(b)
+		if ra == rb {
+			return
+		}
+		if rb < ra {
+			ra, rb = rb, ra
+		}
+		parent[rb] = ra
+	}
+	var used []Edge
+	for _, e := range edges {
+		_, okFrom := scores[e.From]
+		_, okTo := scores[e.To]
+		if e.Weight < minWeight || !okFrom || !okTo || e.From == e.To {
+			continue
+		}
+		for _, n := range []string{e.From, e.To} {
+			if _, ok := parent[n]; !ok {
+				parent[n] = n
+			}
+		}
+		union(e.From, e.To)
+		used = append(used, e)
+	}
+	groups := map[string]*Campaign{}
+	for n := range parent {
+		root := find(n)
+		c, ok := groups[root]
+		if !ok {
+			c = &Campaign{ID: "campaign-" + root}
+			groups[root] = c
+		}
+		c.Members = append(c.Members, n)
+		c.Score += scores[n]
+		c.MaxScore = max(c.MaxScore, scores[n])
+	}
+	for _, e := range used {
+		groups[find(e.From)].Edges++
+	}
+	var out []Campaign
+	for _, c := range groups {
+		if len(c.Members) < minSize {
+			continue
+		}
+		sort.Strings(c.Members)
+		c.Score /= float64(len(c.Members))
+		out = append(out, *c)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// CampaignDetector periodically clusters the graph and writes campaign IDs
+// back onto member indicators.
+type CampaignDetector struct {
+	graph     *ThreatGraph
+	store     *IndicatorStore
+	minWeight float64
+	minSize   int
+
+	mu   sync.RWMutex
+	last []Campaign
+}
+
+func NewCampaignDetector(graph *ThreatGraph, store *IndicatorStore, minWeight float64, minSize int) *CampaignDetector {
+	return &CampaignDetector{graph: graph, store: store, minWeight: minWeight, minSize: minSize}
+}
+
+func (d *CampaignDetector) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := d.Detect(); err != nil {
+			slog.Warn("campaign detection failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Detect runs one clustering pass and tags member indicators.
+func (d *CampaignDetector) Detect() ([]Campaign, error) {
+	scores := map[string]float64{}
+	now := time.Now()
+	for _, ind := range d.store.Select(func(ind Indicator) bool { return now.Before(ind.ExpiresAt) }) {
+		scores[ind.ID] = ind.Score
+	}
+	campaigns := clusterCampaigns(d.graph.Edges(), scores, d.minWeight, d.minSize)
+	assign := map[string]string{}
+	for _, c := range campaigns {
+		for _, m := range c.Members {
+			assign[m] = c.ID
+		}
+	}
+	d.mu.Lock()
+	d.last = campaigns
+	d.mu.Unlock()
+	n, err := d.store.SetCampaigns(assign)
+	if n > 0 {
+		slog.Info("campaign assignments updated", "campaigns", len(campaigns), "indicators_changed", n)
+	}
+	return campaigns, err
+}
+
+// Campaigns returns the result of the last pass.
+func (d *CampaignDetector) Campaigns() []Campaign {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.last
+}
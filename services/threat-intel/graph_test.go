@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestClusterCampaigns(t *testing.T) {
+	scores := map[string]float64{"a": 0.9, "b": 0.6, "c": 0.3, "d": 0.8, "e": 0.8, "f": 1}
+	edges := []Edge{
+		{From: "a", To: "b", Relation: "resolves", Weight: 0.9},
+		{From: "b", To: "c", Relation: "contacts", Weight: 0.7},
+		{From: "c", To: "d", Relation: "weak", Weight: 0.2},   // below threshold
+		{From: "d", To: "e", Relation: "resolves", Weight: 1}, // too small a cluster
+		{From: "a", To: "x", Relation: "unknown", Weight: 1},  // x is not a live indicator
+	}
+	got := clusterCampaigns(edges, scores, 0.5, 3)
+	if len(got) != 1 {
+		t.Fatalf("got %d campaigns: %+v", len(got), got)
+	}
+	c := got[0]
+	if c.ID != "campaign-a" || len(c.Members) != 3 || c.Edges != 2 {
+		t.Fatalf("unexpected campaign %+v", c)
+	}
+	if c.MaxScore != 0.9 || c.Score < 0.59 || c.Score > 0.61 {
+		t.Fatalf("scores = %v / %v", c.Score, c.MaxScore)
+	}
+}
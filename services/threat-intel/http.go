@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	apikey "github.com/swarmguard/libs/go/core/apikey"
+	envelope "github.com/swarmguard/libs/go/core/envelope"
+)
+
+// registerRoutes mounts the indicator API. The caller's tenant comes from its
+// API key; tenant-scoped writes require a matching key and Sensitive metadata
+// is only returned to the owning tenant.
+func registerRoutes(mux *http.ServeMux, store *IndicatorStore, reval *Revalidator, keys *apikey.Keys, defaultTTL time.Duration) {
+	mux.HandleFunc("POST /v1/indicators", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Indicator
+			TTL string `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Type == "" || req.Value == "" {
+			writeError(w, http.StatusBadRequest, "type and value required")
+			return
+		}
+		ttl := defaultTTL
+		if req.TTL != "" {
+			d, err := time.ParseDuration(req.TTL)
+			if err != nil || d <= 0 {
+				writeError(w, http.StatusBadRequest, "invalid ttl")
+				return
+			}
+			ttl = d
+		}
+		tenant, _ := keys.Tenant(r)
+		if req.Tenant == "" {
+			req.Tenant = tenant
+		}
+		if req.Tenant != tenant {
+			writeError(w, http.StatusForbidden, "tenant does not match API key")
+			return
+		}
+		ind, err := store.Upsert(req.Indicator, ttl)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, ind)
+	})
+	mux.HandleFunc("GET /v1/indicators/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ind, ok := store.Get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrIndicatorNotFound.Error())
+			return
+		}
+		tenant, _ := keys.Tenant(r)
+		writeJSON(w, http.StatusOK, ind.Redacted(tenant))
+	})
+	// GET /v1/lookup?type=ip&value=1.2.3.4
+	mux.HandleFunc("GET /v1/lookup", func(w http.ResponseWriter, r *http.Request) {
+		tenant, _ := keys.Tenant(r)
+		ind, ok := store.Lookup(tenant, r.URL.Query().Get("type"), r.URL.Query().Get("value"))
+		if !ok {
+			writeError(w, http.StatusNotFound, ErrIndicatorNotFound.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, ind.Redacted(tenant))
+	})
+	// Bulk TTL extension, by explicit ids or by minimum score. Either way only
+	// shared indicators and the caller's own are touched.
+	mux.HandleFunc("POST /v1/indicators/extend", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			IDs      []string `json:"ids"`
+			MinScore *float64 `json:"min_score"`
+			ExtendBy string   `json:"extend_by"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (len(req.IDs) == 0 && req.MinScore == nil) {
+			writeError(w, http.StatusBadRequest, "ids or min_score required")
+			return
+		}
+		by, err := time.ParseDuration(req.ExtendBy)
+		if err != nil || by <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid extend_by")
+			return
+		}
+		tenant, _ := keys.Tenant(r)
+		if !writableIDs(w, store, tenant, req.IDs) {
+			return
+		}
+		ids := req.IDs
+		if req.MinScore != nil {
+			for _, ind := range store.Select(func(ind Indicator) bool { return ind.Score >= *req.MinScore && writableBy(ind, tenant) }) {
+				ids = append(ids, ind.ID)
+			}
+		}
+		n, err := store.ExtendTTL(ids, by)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"extended": n})
+	})
+	// Manual re-validation of specific ids, which must be writable by the
+	// caller, or of the next due batch.
+	mux.HandleFunc("POST /v1/revalidate", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			IDs []string `json:"ids"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				writeError(w, http.StatusBadRequest, "invalid body")
+				return
+			}
+		}
+		if tenant, _ := keys.Tenant(r); !writableIDs(w, store, tenant, req.IDs) {
+			return
+		}
+		if len(reval.providers) == 0 {
+			writeError(w, http.StatusServiceUnavailable, "no revalidation providers configured")
+			return
+		}
+		writeJSON(w, http.StatusOK, reval.RunBatch(r.Context(), req.IDs))
+	})
+}
+
+func registerGraphRoutes(mux *http.ServeMux, store *IndicatorStore, graph *ThreatGraph, campaigns *CampaignDetector) {
+	mux.HandleFunc("POST /v1/graph/edges", func(w http.ResponseWriter, r *http.Request) {
+		var e Edge
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil || e.From == "" || e.To == "" || e.Relation == "" {
+			writeError(w, http.StatusBadRequest, "from, to and relation required")
+			return
+		}
+		if e.Weight <= 0 || e.Weight > 1 {
+			writeError(w, http.StatusBadRequest, "weight must be in (0, 1]")
+			return
+		}
+		for _, id := range []string{e.From, e.To} {
+			if _, ok := store.Get(id); !ok {
+				writeError(w, http.StatusNotFound, ErrIndicatorNotFound.Error()+": "+id)
+				return
+			}
+		}
+		edge, err := graph.AddEdge(e)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, edge)
+	})
+	// GET /v1/graph/campaigns?refresh=true forces a clustering pass.
+	mux.HandleFunc("GET /v1/graph/campaigns", func(w http.ResponseWriter, r *http.Request) {
+		list := campaigns.Campaigns()
+		if r.URL.Query().Get("refresh") == "true" {
+			var err error
+			if list, err = campaigns.Detect(); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+		if list == nil {
+			list = []Campaign{}
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+}
+
+// registerSightingRoutes mounts the sighting API. Sightings of a tenant's
+// indicator can only be recorded and read with that tenant's API key; shared
+// indicators are open to every caller.
+func registerSightingRoutes(mux *http.ServeMux, store *IndicatorStore, sightings *SightingRecorder, keys *apikey.Keys) {
+	// Detection engines report hits on indicator-derived rules here.
+	mux.HandleFunc("POST /v1/indicators/{id}/sightings", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authorizeIndicator(w, r, store, keys, r.PathValue("id")); !ok {
+			return
+		}
+		var s Sighting
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil || s.RuleID == "" {
+			writeError(w, http.StatusBadRequest, "rule_id required")
+			return
+		}
+		s.IndicatorID = r.PathValue("id")
+		if s.CorrelationID == "" {
+			s.CorrelationID = r.Header.Get("X-Correlation-ID")
+		}
+		rec, err := sightings.Record(r.Context(), s)
+		switch {
+		case errors.Is(err, ErrIndicatorNotFound):
+			writeError(w, http.StatusNotFound, err.Error())
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err.Error())
+		default:
+			w.Header().Set("X-Correlation-ID", rec.CorrelationID)
+			writeJSON(w, http.StatusCreated, rec)
+		}
+	})
+	mux.HandleFunc("GET /v1/indicators/{id}/sightings", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := authorizeIndicator(w, r, store, keys, r.PathValue("id")); !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, sightings.Sightings(r.PathValue("id")))
+	})
+}
+
+// writableBy reports whether tenant may change ind: shared indicators are
+// open to every caller, tenant indicators only to their owner.
+func writableBy(ind Indicator, tenant string) bool {
+	return ind.Tenant == "" || ind.Tenant == tenant
+}
+
+// writableIDs answers 403 and returns false if any existing indicator in ids
+// belongs to another tenant. Unknown ids are left to the caller.
+func writableIDs(w http.ResponseWriter, store *IndicatorStore, tenant string, ids []string) bool {
+	for _, id := range ids {
+		if ind, ok := store.Get(id); ok && !writableBy(ind, tenant) {
+			writeError(w, http.StatusForbidden, "indicator "+id+" belongs to another tenant")
+			return false
+		}
+	}
+	return true
+}
+
+// authorizeIndicator loads indicator id for a tenant-scoped write, answering
+// 404 or 403 itself when it is missing or owned by another tenant.
+func authorizeIndicator(w http.ResponseWriter, r *http.Request, store *IndicatorStore, keys *apikey.Keys, id string) (Indicator, bool) {
+	ind, ok := store.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, ErrIndicatorNotFound.Error())
+		return Indicator{}, false
+	}
+	if tenant, _ := keys.Tenant(r); !writableBy(ind, tenant) {
+		writeError(w, http.StatusForbidden, "indicator belongs to another tenant")
+		return Indicator{}, false
+	}
+	return ind, true
+}
+
+// registerKeyRoutes exposes data key rotation. After rotating a tenant key or
+// re-wrapping under a new master key the store is resealed so nothing at rest
+// still depends on the retired key.
+func registerKeyRoutes(mux *http.ServeMux, keys *envelope.Keyring, store *IndicatorStore) {
+	mux.HandleFunc("POST /internal/keys/rotate", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Tenant string `json:"tenant"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Tenant == "" {
+			writeError(w, http.StatusBadRequest, "tenant required")
+			return
+		}
+		version, err := keys.Rotate(req.Tenant)
+		if err == nil {
+			err = store.Reseal()
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"tenant": req.Tenant, "version": version})
+	})
+	mux.HandleFunc("POST /internal/keys/rewrap", func(w http.ResponseWriter, _ *http.Request) {
+		n, err := keys.Rewrap()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"rewrapped": n})
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
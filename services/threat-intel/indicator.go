@@ -0,0 +1,321 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	envelope "github.com/swarmguard/libs/go/core/envelope"
+)
+
+var ErrIndicatorNotFound = errors.New("indicator not found")
+
+// Indicator is one IOC. Score is a 0..1 confidence; ExpiresAt is the TTL.
+type Indicator struct {
+	ID       string            `json:"id"`
+	Type     string            `json:"type"` // ip, domain, url, hash
+	Value    string            `json:"value"`
+	Score    float64           `json:"score"`
+	Source   string            `json:"source"`
+	Tags     []string          `json:"tags,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Tenant scopes the data key used for Sensitive.
+	Tenant string `json:"tenant,omitempty"`
+	// Sensitive metadata is envelope-encrypted at rest (SensitiveEnc) when a
+	// keyring is configured and decrypted when the store loads.
+	Sensitive    map[string]string `json:"sensitive,omitempty"`
+	SensitiveEnc string            `json:"sensitive_enc,omitempty"`
+	FirstSeen    time.Time         `json:"first_seen"`
+	LastSeen     time.Time         `json:"last_seen"`
+	ExpiresAt    time.Time         `json:"expires_at"`
+	// LastValidated is set by the re-validation subsystem.
+	LastValidated time.Time `json:"last_validated,omitempty"`
+	// CampaignID is set by the graph clustering pass.
+	CampaignID string `json:"campaign_id,omitempty"`
+}
+
+// indicatorID is stable per (tenant, type, value) so re-ingesting the same
+// IOC updates it instead of creating duplicates. Tenants get their own record,
+// so one tenant's Sensitive metadata is never merged into another's; shared
+// indicators (no tenant) keep the original (type, value) ID.
+func indicatorID(tenant, typ, value string) string {
+	key := strings.ToLower(typ) + "|" + strings.ToLower(strings.TrimSpace(value))
+	if tenant != "" {
+		key = tenant + "|" + key
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:12])
+}
+
+// Redacted returns ind without Sensitive unless tenant owns it.
+func (ind Indicator) Redacted(tenant string) Indicator {
+	if ind.Tenant != tenant {
+		ind.Sensitive = nil
+	}
+	return ind
+}
+
+// IndicatorStore keeps indicators in memory with JSON file persistence.
+// Sensitive metadata is only held in plaintext in memory.
+type IndicatorStore struct {
+	mu         sync.RWMutex
+	path       string
+	keys       *envelope.Keyring // nil disables encryption at rest
+	indicators map[string]*Indicator
+}
+
+func NewIndicatorStore(path string, keys *envelope.Keyring) (*IndicatorStore, error) {
+	s := &IndicatorStore{path: path, keys: keys, indicators: map[string]*Indicator{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*Indicator
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	for _, ind := range list {
+		if err := s.decryptSensitive(ind); err != nil {
+			return nil, fmt.Errorf("indicator %s: %w", ind.ID, err)
+		}
+		s.indicators[ind.ID] = ind
+	}
+	return s, nil
+}
+
+func tenantOf(ind *Indicator) string {
+	if ind.Tenant == "" {
+		return "default"
+	}
+	return ind.Tenant
+}
+
+func (s *IndicatorStore) decryptSensitive(ind *Indicator) error {
+	if ind.SensitiveEnc == "" {
+		return nil
+	}
+	if s.keys == nil {
+		return errors.New("sensitive metadata is encrypted but no keyring is configured")
+	}
+	plain, err := s.keys.Decrypt(ind.SensitiveEnc, []byte(ind.ID))
+	if err != nil {
+		return err
+	}
+	ind.SensitiveEnc = ""
+	return json.Unmarshal(plain, &ind.Sensitive)
+}
+
+// sealed returns the at-rest form of ind. Data is re-encrypted on every
+// persist, so it moves to the tenant's newest key version after a rotation.
+func (s *IndicatorStore) sealed(ind *Indicator) (*Indicator, error) {
+	if s.keys == nil || len(ind.Sensitive) == 0 {
+		return ind, nil
+	}
+	plain, err := json.Marshal(ind.Sensitive)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := s.keys.Encrypt(tenantOf(ind), plain, []byte(ind.ID))
+	if err != nil {
+		return nil, err
+	}
+	out := *ind
+	out.Sensitive, out.SensitiveEnc = nil, enc
+	return &out, nil
+}
+
+// Upsert inserts or merges an indicator: the score becomes the max of old and
+// new, LastSeen and ExpiresAt only move forward.
+func (s *IndicatorStore) Upsert(in Indicator, ttl time.Duration) (Indicator, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	in.Type = strings.ToLower(in.Type)
+	in.ID = indicatorID(in.Tenant, in.Type, in.Value)
+	expires := now.Add(ttl)
+	if cur, ok := s.indicators[in.ID]; ok {
+		if cur.Tenant != in.Tenant {
+			return Indicator{}, fmt.Errorf("indicator %s belongs to another tenant", in.ID)
+		}
+		cur.Score = max(cur.Score, in.Score)
+		cur.LastSeen = now
+		if expires.After(cur.ExpiresAt) {
+			cur.ExpiresAt = expires
+		}
+		cur.Tags = mergeTags(cur.Tags, in.Tags)
+		cur.Metadata = mergeMetadata(cur.Metadata, in.Metadata)
+		cur.Sensitive = mergeMetadata(cur.Sensitive, in.Sensitive)
+		return *cur, s.persistLocked()
+	}
+	in.SensitiveEnc = ""
+	in.FirstSeen, in.LastSeen, in.ExpiresAt = now, now, expires
+	s.indicators[in.ID] = &in
+	return in, s.persistLocked()
+}
+
+func mergeMetadata(dst, src map[string]string) map[string]string {
+	for k, v := range src {
+		if dst == nil {
+			dst = map[string]string{}
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+func mergeTags(a, b []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, t := range append(append([]string{}, a...), b...) {
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (s *IndicatorStore) Get(id string) (Indicator, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ind, ok := s.indicators[id]
+	if !ok {
+		return Indicator{}, false
+	}
+	return *ind, true
+}
+
+// Lookup finds an indicator by type and value, preferring the tenant's own
+// record over the shared one.
+func (s *IndicatorStore) Lookup(tenant, typ, value string) (Indicator, bool) {
+	if tenant != "" {
+		if ind, ok := s.Get(indicatorID(tenant, typ, value)); ok {
+			return ind, true
+		}
+	}
+	return s.Get(indicatorID("", typ, value))
+}
+
+// Select returns indicators matching f, sorted by expiry (soonest first).
+func (s *IndicatorStore) Select(f func(Indicator) bool) []Indicator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []Indicator
+	for _, ind := range s.indicators {
+		if f(*ind) {
+			out = append(out, *ind)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ExpiresAt.Before(out[j].ExpiresAt) })
+	return out
+}
+
+// Update applies f to an indicator under the write lock and persists.
+func (s *IndicatorStore) Update(id string, f func(*Indicator)) (Indicator, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ind, ok := s.indicators[id]
+	if !ok {
+		return Indicator{}, ErrIndicatorNotFound
+	}
+	f(ind)
+	return *ind, s.persistLocked()
+}
+
+// ExtendTTL pushes ExpiresAt of the given indicators to at least now+by and
+// returns how many were changed.
+func (s *IndicatorStore) ExtendTTL(ids []string, by time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	target := time.Now().UTC().Add(by)
+	n := 0
+	for _, id := range ids {
+		if ind, ok := s.indicators[id]; ok && target.After(ind.ExpiresAt) {
+			ind.ExpiresAt = target
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.persistLocked()
+}
+
+// SetCampaigns assigns campaign IDs by indicator ID; indicators missing from
+// assign lose any previous campaign. It returns how many changed.
+func (s *IndicatorStore) SetCampaigns(assign map[string]string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, ind := range s.indicators {
+		if c := assign[id]; c != ind.CampaignID {
+			ind.CampaignID = c
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.persistLocked()
+}
+
+// Reseal rewrites the state file, re-encrypting sensitive metadata with the
+// current data keys (used after a key rotation).
+func (s *IndicatorStore) Reseal() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.persistLocked()
+}
+
+// Sweep removes expired indicators.
+func (s *IndicatorStore) Sweep(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, ind := range s.indicators {
+		if now.After(ind.ExpiresAt) {
+			delete(s.indicators, id)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.persistLocked()
+}
+
+func (s *IndicatorStore) persistLocked() error {
+	list := make([]*Indicator, 0, len(s.indicators))
+	for _, ind := range s.indicators {
+		sealed, err := s.sealed(ind)
+		if err != nil {
+			return fmt.Errorf("encrypt indicator %s: %w", ind.ID, err)
+		}
+		list = append(list, sealed)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
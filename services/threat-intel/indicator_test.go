@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIndicatorsAreTenantScoped(t *testing.T) {
+	store, err := NewIndicatorStore(filepath.Join(t.TempDir(), "indicators.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	registerRoutes(mux, store, nil, testKeys(t), time.Hour)
+	call := func(method, path, key, body string) (int, Indicator) {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		var ind Indicator
+		_ = json.NewDecoder(w.Body).Decode(&ind)
+		return w.Code, ind
+	}
+
+	code, a := call("POST", "/v1/indicators", "key-a", `{"type":"ip","value":"10.0.0.1","sensitive":{"case":"acme-1"}}`)
+	if code != http.StatusOK || a.Tenant != "acme" {
+		t.Fatalf("acme upsert: %d %+v", code, a)
+	}
+	_, b := call("POST", "/v1/indicators", "key-b", `{"type":"ip","value":"10.0.0.1","sensitive":{"case":"globex-1"}}`)
+	if b.ID == a.ID {
+		t.Fatal("tenants share one indicator record")
+	}
+	if cur, _ := store.Get(a.ID); len(cur.Sensitive) != 1 || cur.Sensitive["case"] != "acme-1" {
+		t.Fatalf("acme sensitive metadata changed: %v", cur.Sensitive)
+	}
+
+	if _, got := call("GET", "/v1/indicators/"+a.ID, "key-b", ""); got.Sensitive != nil {
+		t.Fatalf("globex read acme sensitive metadata: %v", got.Sensitive)
+	}
+	if _, got := call("GET", "/v1/indicators/"+a.ID, "", ""); got.Sensitive != nil {
+		t.Fatalf("anonymous caller read acme sensitive metadata: %v", got.Sensitive)
+	}
+	if _, got := call("GET", "/v1/indicators/"+a.ID, "key-a", ""); got.Sensitive["case"] != "acme-1" {
+		t.Fatalf("acme cannot read its own sensitive metadata: %v", got.Sensitive)
+	}
+	if _, got := call("GET", "/v1/lookup?type=ip&value=10.0.0.1", "key-b", ""); got.ID != b.ID || got.Sensitive["case"] != "globex-1" {
+		t.Fatalf("globex lookup: %+v", got)
+	}
+
+	if code, _ := call("POST", "/v1/indicators", "key-b", `{"type":"ip","value":"10.0.0.2","tenant":"acme"}`); code != http.StatusForbidden {
+		t.Fatalf("write into another tenant: status %d", code)
+	}
+}
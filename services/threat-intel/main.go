@@ -1,13 +1,193 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
 
+	nats "github.com/nats-io/nats.go"
+	apikey "github.com/swarmguard/libs/go/core/apikey"
+	deprecation "github.com/swarmguard/libs/go/core/deprecation"
+	envelope "github.com/swarmguard/libs/go/core/envelope"
 	sloglog "github.com/swarmguard/libs/go/core/logging"
+	natsctx "github.com/swarmguard/libs/go/core/natsctx"
 )
 
 func main() {
 	sloglog.Init("threat-intel")
 	slog.Info("starting service")
 	// TODO: IOC ingest + reputation cache
+
+	var keys *envelope.Keyring
+	if spec := getenv("TI_MASTER_KEYS", ""); spec != "" {
+		kr, err := envelope.Open(getenv("TI_KEYRING_PATH", "data/keyring.json"), spec)
+		if err != nil {
+			slog.Error("keyring init failed", "error", err)
+			os.Exit(1)
+		}
+		keys = kr
+	} else {
+		slog.Warn("TI_MASTER_KEYS not set, sensitive indicator metadata is stored unencrypted")
+	}
+	store, err := NewIndicatorStore(getenv("TI_STATE_PATH", "data/indicators.json"), keys)
+	if err != nil {
+		slog.Error("indicator store init failed", "error", err)
+		os.Exit(1)
+	}
+	graph, err := NewThreatGraph(getenv("TI_GRAPH_PATH", "data/graph.json"))
+	if err != nil {
+		slog.Error("threat graph init failed", "error", err)
+		os.Exit(1)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var pub *natsctx.ShapedPublisher
+	nc, err := nats.Connect(getenv("NATS_URL", "127.0.0.1:4222"))
+	if err != nil {
+		slog.Warn("nats connect failed, sightings will not be forwarded", "error", err)
+	} else {
+		defer nc.Close()
+		pub = natsctx.NewShapedPublisher(nc, getenvFloat("TI_NATS_RATE", 200), getenvInt("TI_NATS_BURST", 50), getenvInt("TI_NATS_BUFFER", 10000))
+		go pub.Run(ctx)
+	}
+	sightings, err := NewSightingRecorder(getenv("TI_SIGHTINGS_PATH", "data/sightings.jsonl"), store, pub, getenvFloat("TI_SIGHTING_SCORE_BUMP", 0.05))
+	if err != nil {
+		slog.Error("sighting log init failed", "error", err)
+		os.Exit(1)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if n, err := store.Sweep(now); err != nil {
+					slog.Warn("expiry sweep failed", "error", err)
+				} else if n > 0 {
+					slog.Info("indicators expired", "count", n)
+				}
+			}
+		}
+	}()
+
+	providers := providersFromEnv()
+	reval := NewRevalidator(store, providers, RevalidationPolicy{
+		Window:    getenvDuration("TI_REVALIDATE_WINDOW", 24*time.Hour),
+		MinScore:  getenvFloat("TI_REVALIDATE_MIN_SCORE", 0.7),
+		BatchSize: getenvInt("TI_REVALIDATE_BATCH", 50),
+		ExtendBy:  getenvDuration("TI_REVALIDATE_EXTEND", 7*24*time.Hour),
+		Demote:    getenvFloat("TI_REVALIDATE_DEMOTE", 0.5),
+	})
+	if len(providers) > 0 {
+		go reval.Run(ctx, getenvDuration("TI_REVALIDATE_INTERVAL", 15*time.Minute))
+	}
+
+	campaigns := NewCampaignDetector(graph, store, getenvFloat("TI_CAMPAIGN_MIN_WEIGHT", 0.5), getenvInt("TI_CAMPAIGN_MIN_SIZE", 3))
+	go campaigns.Run(ctx, getenvDuration("TI_CAMPAIGN_INTERVAL", 10*time.Minute))
+
+	// TI_API_KEYS is tenant=sha256hex;... and scopes indicator writes and
+	// sensitive metadata to the tenant of the caller's key.
+	apiKeys, err := apikey.Parse(os.Getenv("TI_API_KEYS"))
+	if err != nil {
+		slog.Error("invalid TI_API_KEYS", "error", err)
+		os.Exit(1)
+	}
+
+	// Deprecated routes are configured as pattern=deprecated[/sunset[!]][>successor];...
+	deprecations := deprecation.New(nil)
+	if err := deprecations.ParseSpec(os.Getenv("TI_DEPRECATED_ROUTES")); err != nil {
+		slog.Error("invalid TI_DEPRECATED_ROUTES", "error", err)
+		os.Exit(1)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	mux.HandleFunc("GET /internal/deprecations", deprecations.ReportHandler)
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if pub != nil {
+			writePublisherMetrics(w, pub)
+		}
+		deprecations.WriteMetrics(w)
+	})
+	registerRoutes(mux, store, reval, apiKeys, getenvDuration("TI_DEFAULT_TTL", 30*24*time.Hour))
+	registerGraphRoutes(mux, store, graph, campaigns)
+	registerSightingRoutes(mux, store, sightings, apiKeys)
+	if keys != nil {
+		registerKeyRoutes(mux, keys, store)
+	}
+
+	srv := &http.Server{Addr: getenv("TI_HTTP_ADDR", ":8080"), Handler: deprecations.Middleware(mux)}
+	go func() {
+		slog.Info("http listening", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("http server failed", "error", err)
+			stop()
+		}
+	}()
+	<-ctx.Done()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Shutdown(shutdownCtx)
+}
+
+// writePublisherMetrics renders NATS publish/drop counters per subject in
+// Prometheus text format.
+func writePublisherMetrics(w http.ResponseWriter, pub *natsctx.ShapedPublisher) {
+	stats := pub.Stats()
+	subjects := make([]string, 0, len(stats))
+	for s := range stats {
+		subjects = append(subjects, s)
+	}
+	sort.Strings(subjects)
+	fmt.Fprintf(w, "# HELP swarm_nats_published_total Messages published to NATS.\n# TYPE swarm_nats_published_total counter\n")
+	for _, s := range subjects {
+		fmt.Fprintf(w, "swarm_nats_published_total{subject=%q} %d\n", s, stats[s].Published)
+	}
+	fmt.Fprintf(w, "# HELP swarm_nats_dropped_total Messages dropped by publish shaping or publish errors.\n# TYPE swarm_nats_dropped_total counter\n")
+	for _, s := range subjects {
+		fmt.Fprintf(w, "swarm_nats_dropped_total{subject=%q} %d\n", s, stats[s].Dropped)
+	}
+	fmt.Fprintf(w, "# HELP swarm_nats_publish_buffered Messages waiting in the publish buffer.\n# TYPE swarm_nats_publish_buffered gauge\nswarm_nats_publish_buffered %d\n", pub.Buffered())
+}
+
+func getenv(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+func getenvInt(k string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(k)); err == nil {
+		return v
+	}
+	return def
+}
+
+func getenvFloat(k string, def float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(k), 64); err == nil {
+		return v
+	}
+	return def
+}
+
+func getenvDuration(k string, def time.Duration) time.Duration {
+	if v := os.Getenv(k); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		slog.Warn("invalid duration, using default", "key", k, "value", v)
+	}
+	return def
 }
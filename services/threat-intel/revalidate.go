@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	resilience "github.com/swarmguard/libs/go/core/resilience"
+)
+
+var errQuotaExhausted = errors.New("provider quota exhausted")
+
+// Verdict is a provider's current view of an indicator.
+type Verdict struct {
+	Provider string  `json:"provider"`
+	Found    bool    `json:"found"`
+	Active   bool    `json:"active"`
+	Score    float64 `json:"score"`
+}
+
+// Provider re-checks indicators against an external feed.
+type Provider interface {
+	Name() string
+	Supports(typ string) bool
+	Check(ctx context.Context, ind Indicator) (Verdict, error)
+}
+
+// quotaProvider enforces a per-provider request budget (requests per minute)
+// so batch jobs cannot burn through a paid API quota.
+type quotaProvider struct {
+	Provider
+	bucket *resilience.TokenBucket
+}
+
+func withQuota(p Provider, perMinute int) Provider {
+	return &quotaProvider{Provider: p, bucket: resilience.NewTokenBucket(float64(perMinute)/60, float64(max(perMinute, 1)))}
+}
+
+func (q *quotaProvider) Check(ctx context.Context, ind Indicator) (Verdict, error) {
+	if ok, _ := q.bucket.Take(); !ok {
+		return Verdict{}, errQuotaExhausted
+	}
+	return q.Provider.Check(ctx, ind)
+}
+
+// virusTotalProvider uses the VT v3 API (files, domains, ip_addresses, urls).
+type virusTotalProvider struct {
+	apiKey string
+	http   *http.Client
+}
+
+func (v *virusTotalProvider) Name() string { return "virustotal" }
+
+func (v *virusTotalProvider) Supports(typ string) bool {
+	switch typ {
+	case "hash", "domain", "ip", "url":
+		return true
+	}
+	return false
+}
+
+func (v *virusTotalProvider) Check(ctx context.Context, ind Indicator) (Verdict, error) {
+	path := map[string]string{"hash": "files", "domain": "domains", "ip": "ip_addresses", "url": "urls"}[ind.Type]
+	id := ind.Value
+	if ind.Type == "url" {
+		// VT identifies URLs by unpadded base64url of the URL itself.
+		id = base64URLNoPad(ind.Value)
+	}
+	var body struct {
+		Data struct {
+			Attributes struct {
+				Stats struct {
+					Malicious  int `json:"malicious"`
+					Suspicious int `json:"suspicious"`
+					Harmless   int `json:"harmless"`
+					Undetected int `json:"undetected"`
+				} `json:"last_analysis_stats"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	found, err := getJSON(ctx, v.http, "https://www.virustotal.com/api/v3/"+path+"/"+url.PathEscape(id), map[string]string{"x-apikey": v.apiKey}, &body)
+	if err != nil || !found {
+		return Verdict{Provider: v.Name(), Found: found}, err
+	}
+	s := body.Data.Attributes.Stats
+	total := s.Malicious + s.Suspicious + s.Harmless + s.Undetected
+	verdict := Verdict{Provider: v.Name(), Found: true, Active: s.Malicious > 0}
+	if total > 0 {
+		verdict.Score = float64(s.Malicious+s.Suspicious/2) / float64(total)
+	}
+	return verdict, nil
+}
+
+// otxProvider uses AlienVault OTX indicator details; an indicator referenced
+// by at least one pulse is considered active.
+type otxProvider struct {
+	apiKey string
+	http   *http.Client
+}
+
+func (o *otxProvider) Name() string { return "otx" }
+
+func (o *otxProvider) Supports(typ string) bool {
+	_, ok := otxSections[typ]
+	return ok
+}
+
+var otxSections = map[string]string{"ip": "IPv4", "domain": "domain", "url": "url", "hash": "file"}
+
+func (o *otxProvider) Check(ctx context.Context, ind Indicator) (Verdict, error) {
+	var body struct {
+		PulseInfo struct {
+			Count int `json:"count"`
+		} `json:"pulse_info"`
+	}
+	u := fmt.Sprintf("https://otx.alienvault.com/api/v1/indicators/%s/%s/general", otxSections[ind.Type], url.PathEscape(ind.Value))
+	found, err := getJSON(ctx, o.http, u, map[string]string{"X-OTX-API-KEY": o.apiKey}, &body)
+	if err != nil || !found {
+		return Verdict{Provider: o.Name(), Found: found}, err
+	}
+	n := body.PulseInfo.Count
+	return Verdict{Provider: o.Name(), Found: true, Active: n > 0, Score: min(1, float64(n)/10)}, nil
+}
+
+func base64URLNoPad(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+func getJSON(ctx context.Context, client *http.Client, u string, headers map[string]string, out any) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return false, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return false, errQuotaExhausted
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return true, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// providersFromEnv enables providers whose API key is configured.
+func providersFromEnv() []Provider {
+	client := &http.Client{Timeout: 10 * time.Second}
+	var ps []Provider
+	if key := getenv("TI_VT_API_KEY", ""); key != "" {
+		ps = append(ps, withQuota(&virusTotalProvider{apiKey: key, http: client}, getenvInt("TI_VT_QUOTA_PER_MIN", 4)))
+	}
+	if key := getenv("TI_OTX_API_KEY", ""); key != "" {
+		ps = append(ps, withQuota(&otxProvider{apiKey: key, http: client}, getenvInt("TI_OTX_QUOTA_PER_MIN", 60)))
+	}
+	return ps
+}
+
+// RevalidationPolicy selects which indicators are re-checked and how the
+// outcome changes them.
+type RevalidationPolicy struct {
+	Window    time.Duration // re-check indicators expiring within this window
+	MinScore  float64       // only high-value indicators are worth API quota
+	BatchSize int
+	ExtendBy  time.Duration // TTL granted when a provider still reports it active
+	Demote    float64       // score multiplier when no provider reports it active
+}
+
+// RevalidationResult summarizes one batch.
+type RevalidationResult struct {
+	Checked  int      `json:"checked"`
+	Extended int      `json:"extended"`
+	Demoted  int      `json:"demoted"`
+	Skipped  int      `json:"skipped"` // quota exhausted or provider errors
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// Revalidator re-checks indicators before they expire, extending the TTL of
+// those still active in the wild and demoting the rest.
+type Revalidator struct {
+	store     *IndicatorStore
+	providers []Provider
+	policy    RevalidationPolicy
+	running   sync.Mutex
+}
+
+func NewRevalidator(store *IndicatorStore, providers []Provider, policy RevalidationPolicy) *Revalidator {
+	return &Revalidator{store: store, providers: providers, policy: policy}
+}
+
+func (r *Revalidator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res := r.RunBatch(ctx, nil)
+			if res.Checked > 0 {
+				slog.Info("indicator revalidation", "checked", res.Checked, "extended", res.Extended, "demoted", res.Demoted, "skipped", res.Skipped)
+			}
+		}
+	}
+}
+
+// RunBatch re-validates ids, or when ids is empty the next batch of
+// high-value indicators close to expiry. Only one batch runs at a time.
+func (r *Revalidator) RunBatch(ctx context.Context, ids []string) RevalidationResult {
+	r.running.Lock()
+	defer r.running.Unlock()
+	var batch []Indicator
+	if len(ids) > 0 {
+		for _, id := range ids {
+			if ind, ok := r.store.Get(id); ok {
+				batch = append(batch, ind)
+			}
+		}
+	} else {
+		horizon := time.Now().Add(r.policy.Window)
+		batch = r.store.Select(func(ind Indicator) bool {
+			return ind.Score >= r.policy.MinScore && ind.ExpiresAt.Before(horizon)
+		})
+	}
+	if len(batch) > r.policy.BatchSize {
+		batch = batch[:r.policy.BatchSize]
+	}
+	var res RevalidationResult
+	for _, ind := range batch {
+		verdicts, err := r.check(ctx, ind)
+		if len(verdicts) == 0 {
+			res.Skipped++
+			if err != nil {
+				res.Errors = append(res.Errors, ind.ID+": "+err.Error())
+			}
+			continue
+		}
+		res.Checked++
+		active, best := false, 0.0
+		for _, v := range verdicts {
+			active = active || v.Active
+			best = max(best, v.Score)
+		}
+		now := time.Now().UTC()
+		_, uerr := r.store.Update(ind.ID, func(cur *Indicator) {
+			cur.LastValidated = now
+			if active {
+				cur.Score = max(cur.Score, best)
+				if exp := now.Add(r.policy.ExtendBy); exp.After(cur.ExpiresAt) {
+					cur.ExpiresAt = exp
+				}
+			} else {
+				cur.Score *= r.policy.Demote
+			}
+		})
+		if uerr != nil {
+			res.Errors = append(res.Errors, ind.ID+": "+uerr.Error())
+			continue
+		}
+		if active {
+			res.Extended++
+		} else {
+			res.Demoted++
+		}
+	}
+	return res
+}
+
+// check asks every provider that supports the indicator type; providers that
+// fail or are out of quota are skipped for this indicator.
+func (r *Revalidator) check(ctx context.Context, ind Indicator) ([]Verdict, error) {
+	var out []Verdict
+	var lastErr error
+	for _, p := range r.providers {
+		if !p.Supports(ind.Type) {
+			continue
+		}
+		v, err := p.Check(ctx, ind)
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %w", p.Name(), err)
+			continue
+		}
+		out = append(out, v)
+	}
+	return out, lastErr
+}
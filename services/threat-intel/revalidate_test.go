@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeProvider answers every indicator type with verdict.
+type fakeProvider struct {
+	name    string
+	verdict func(Indicator) (Verdict, error)
+	calls   atomic.Int32
+}
+
+func (p *fakeProvider) Name() string         { return p.name }
+func (p *fakeProvider) Supports(string) bool { return true }
+
+func (p *fakeProvider) Check(_ context.Context, ind Indicator) (Verdict, error) {
+	p.calls.Add(1)
+	v, err := p.verdict(ind)
+	v.Provider = p.name
+	return v, err
+}
+
+func newRevalidationStore(t *testing.T, path string, values ...string) (*IndicatorStore, []Indicator) {
+	t.Helper()
+	store, err := NewIndicatorStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var inds []Indicator
+	for _, v := range values {
+		ind, err := store.Upsert(Indicator{Type: "ip", Value: v, Score: 0.8}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		inds = append(inds, ind)
+	}
+	return store, inds
+}
+
+var testRevalidationPolicy = RevalidationPolicy{Window: 2 * time.Hour, MinScore: 0.7, BatchSize: 10, ExtendBy: 7 * 24 * time.Hour, Demote: 0.5}
+
+func TestRevalidationStopsAtQuota(t *testing.T) {
+	store, _ := newRevalidationStore(t, filepath.Join(t.TempDir(), "indicators.json"), "10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5")
+	vt := &fakeProvider{name: "virustotal", verdict: func(Indicator) (Verdict, error) { return Verdict{Found: true, Active: true, Score: 0.9}, nil }}
+	res := NewRevalidator(store, []Provider{withQuota(vt, 2)}, testRevalidationPolicy).RunBatch(context.Background(), nil)
+	if vt.calls.Load() != 2 {
+		t.Fatalf("provider called %d times with a quota of 2", vt.calls.Load())
+	}
+	if res.Checked != 2 || res.Extended != 2 || res.Skipped != 3 || len(res.Errors) != 3 || !strings.Contains(res.Errors[0], errQuotaExhausted.Error()) {
+		t.Fatalf("result %+v", res)
+	}
+	if n := len(store.Select(func(ind Indicator) bool { return !ind.LastValidated.IsZero() })); n != 2 {
+		t.Fatalf("%d indicators validated, want 2", n)
+	}
+}
+
+func TestRevalidationResolvesDisagreement(t *testing.T) {
+	store, inds := newRevalidationStore(t, filepath.Join(t.TempDir(), "indicators.json"), "10.0.0.1", "10.0.0.2")
+	active, retired := inds[0], inds[1]
+	// VT still flags the first indicator, OTX has no pulse for it any more;
+	// nobody reports the second one as active.
+	vt := &fakeProvider{name: "virustotal", verdict: func(ind Indicator) (Verdict, error) {
+		return Verdict{Found: true, Active: ind.ID == active.ID, Score: 0.95}, nil
+	}}
+	otx := &fakeProvider{name: "otx", verdict: func(Indicator) (Verdict, error) { return Verdict{Found: true}, nil }}
+	res := NewRevalidator(store, []Provider{vt, otx}, testRevalidationPolicy).RunBatch(context.Background(), nil)
+	if res.Checked != 2 || res.Extended != 1 || res.Demoted != 1 {
+		t.Fatalf("result %+v", res)
+	}
+	if cur, _ := store.Get(active.ID); cur.Score != 0.95 || !cur.ExpiresAt.After(time.Now().Add(6*24*time.Hour)) {
+		t.Fatalf("one active verdict must extend: %+v", cur)
+	}
+	if cur, _ := store.Get(retired.ID); cur.Score != 0.4 || !cur.ExpiresAt.Equal(retired.ExpiresAt) {
+		t.Fatalf("no active verdict must demote: %+v", cur)
+	}
+}
+
+func TestRevalidationProviderErrorsLeaveIndicator(t *testing.T) {
+	store, inds := newRevalidationStore(t, filepath.Join(t.TempDir(), "indicators.json"), "10.0.0.1")
+	down := &fakeProvider{name: "virustotal", verdict: func(Indicator) (Verdict, error) { return Verdict{}, errors.New("status 503") }}
+	res := NewRevalidator(store, []Provider{down}, testRevalidationPolicy).RunBatch(context.Background(), []string{inds[0].ID})
+	if res.Checked != 0 || res.Skipped != 1 || len(res.Errors) != 1 || !strings.Contains(res.Errors[0], "virustotal: status 503") {
+		t.Fatalf("result %+v", res)
+	}
+	if cur, _ := store.Get(inds[0].ID); cur.Score != inds[0].Score || !cur.ExpiresAt.Equal(inds[0].ExpiresAt) || !cur.LastValidated.IsZero() {
+		t.Fatalf("failed check changed the indicator: %+v", cur)
+	}
+}
+
+func TestRevalidationPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "indicators.json")
+	store, inds := newRevalidationStore(t, path, "10.0.0.1")
+	vt := &fakeProvider{name: "virustotal", verdict: func(Indicator) (Verdict, error) { return Verdict{Found: true, Active: true, Score: 0.9}, nil }}
+	if res := NewRevalidator(store, []Provider{vt}, testRevalidationPolicy).RunBatch(context.Background(), nil); res.Extended != 1 {
+		t.Fatalf("result %+v", res)
+	}
+	want, _ := store.Get(inds[0].ID)
+	reopened, err := NewIndicatorStore(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := reopened.Get(inds[0].ID)
+	if got.LastValidated.IsZero() || !got.LastValidated.Equal(want.LastValidated) || !got.ExpiresAt.Equal(want.ExpiresAt) {
+		t.Fatalf("after reopen: %+v, want %+v", got, want)
+	}
+	// Freshly validated indicators are no longer due.
+	if res := NewRevalidator(reopened, []Provider{vt}, testRevalidationPolicy).RunBatch(context.Background(), nil); res.Checked != 0 || vt.calls.Load() != 1 {
+		t.Fatalf("second batch: %+v", res)
+	}
+}
+
+func TestBulkRoutesAreTenantScoped(t *testing.T) {
+	store, err := NewIndicatorStore(filepath.Join(t.TempDir(), "indicators.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme, _ := store.Upsert(Indicator{Type: "ip", Value: "10.0.0.1", Tenant: "acme", Score: 0.9}, time.Hour)
+	globex, _ := store.Upsert(Indicator{Type: "ip", Value: "10.0.0.1", Tenant: "globex", Score: 0.9}, time.Hour)
+	vt := &fakeProvider{name: "virustotal", verdict: func(Indicator) (Verdict, error) { return Verdict{Found: true, Active: true, Score: 0.9}, nil }}
+	mux := http.NewServeMux()
+	registerRoutes(mux, store, NewRevalidator(store, []Provider{vt}, testRevalidationPolicy), testKeys(t), time.Hour)
+	call := func(path, key, body string) int {
+		r := httptest.NewRequest("POST", path, strings.NewReader(body))
+		r.Header.Set("X-API-Key", key)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	if code := call("/v1/indicators/extend", "key-b", `{"ids":["`+acme.ID+`"],"extend_by":"24h"}`); code != http.StatusForbidden {
+		t.Fatalf("extend another tenant's indicator: status %d", code)
+	}
+	if code := call("/v1/revalidate", "key-b", `{"ids":["`+acme.ID+`"]}`); code != http.StatusForbidden || vt.calls.Load() != 0 {
+		t.Fatalf("revalidate another tenant's indicator: status %d", code)
+	}
+	if code := call("/v1/indicators/extend", "key-b", `{"min_score":0.5,"extend_by":"24h"}`); code != http.StatusOK {
+		t.Fatalf("extend by score: status %d", code)
+	}
+	if cur, _ := store.Get(acme.ID); !cur.ExpiresAt.Equal(acme.ExpiresAt) {
+		t.Fatal("extend by score reached another tenant's indicator")
+	}
+	if cur, _ := store.Get(globex.ID); !cur.ExpiresAt.After(globex.ExpiresAt) {
+		t.Fatal("extend by score skipped the caller's own indicator")
+	}
+	if code := call("/v1/revalidate", "key-a", `{"ids":["`+acme.ID+`"]}`); code != http.StatusOK || vt.calls.Load() != 1 {
+		t.Fatalf("owner revalidate: status %d", code)
+	}
+}
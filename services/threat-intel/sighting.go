@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	natsctx "github.com/swarmguard/libs/go/core/natsctx"
+)
+
+const subjectSightingRecorded = "threat.v1.sighting.recorded"
+
+// maxSightingsPerIndicator bounds the history kept in memory per indicator;
+// the JSONL log keeps everything.
+const maxSightingsPerIndicator = 100
+
+// Sighting is a detection hit on a rule generated from an indicator.
+// CorrelationID ties indicator -> rule -> match -> sighting together.
+type Sighting struct {
+	CorrelationID string    `json:"correlation_id"`
+	IndicatorID   string    `json:"indicator_id"`
+	RuleID        string    `json:"rule_id"`
+	MatchID       string    `json:"match_id,omitempty"`
+	Source        string    `json:"source"` // e.g. signature-engine
+	NodeID        string    `json:"node_id,omitempty"`
+	ObservedAt    time.Time `json:"observed_at"`
+	ScoreAfter    float64   `json:"score_after"`
+}
+
+// SightingRecorder applies sightings to indicators, appends them to a JSONL
+// log and forwards them as evidence to federation over NATS.
+type SightingRecorder struct {
+	store *IndicatorStore
+	pub   *natsctx.ShapedPublisher // nil when NATS is unavailable
+	bump  float64
+
+	mu     sync.RWMutex
+	path   string
+	recent map[string][]Sighting
+}
+
+func NewSightingRecorder(path string, store *IndicatorStore, pub *natsctx.ShapedPublisher, bump float64) (*SightingRecorder, error) {
+	r := &SightingRecorder{store: store, pub: pub, bump: bump, path: path, recent: map[string][]Sighting{}}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var s Sighting
+		if err := json.Unmarshal(sc.Bytes(), &s); err != nil {
+			slog.Warn("skipping corrupt sighting record", "error", err)
+			continue
+		}
+		r.remember(s)
+	}
+	return r, sc.Err()
+}
+
+// Record bumps the indicator score and LastSeen, then logs and publishes the
+// sighting. A missing correlation ID is generated so the chain stays traceable.
+func (r *SightingRecorder) Record(ctx context.Context, s Sighting) (Sighting, error) {
+	if s.ObservedAt.IsZero() {
+		s.ObservedAt = time.Now().UTC()
+	}
+	if s.CorrelationID == "" {
+		s.CorrelationID = newCorrelationID()
+	}
+	ind, err := r.store.Update(s.IndicatorID, func(cur *Indicator) {
+		cur.Score = min(1, cur.Score+r.bump)
+		if s.ObservedAt.After(cur.LastSeen) {
+			cur.LastSeen = s.ObservedAt
+		}
+	})
+	if err != nil {
+		return s, err
+	}
+	s.ScoreAfter = ind.Score
+	data, err := json.Marshal(s)
+	if err != nil {
+		return s, err
+	}
+	r.mu.Lock()
+	err = appendLine(r.path, data)
+	if err == nil {
+		r.rememberLocked(s)
+	}
+	r.mu.Unlock()
+	if err != nil {
+		return s, err
+	}
+	slog.Info("indicator sighting", "indicator", s.IndicatorID, "rule", s.RuleID, "correlation_id", s.CorrelationID, "score", s.ScoreAfter)
+	if r.pub != nil {
+		if err := r.pub.Publish(ctx, subjectSightingRecorded, data); err != nil {
+			slog.Warn("sighting publish failed", "correlation_id", s.CorrelationID, "error", err)
+		}
+	}
+	return s, nil
+}
+
+// Sightings returns the most recent sightings of an indicator, newest last.
+func (r *SightingRecorder) Sightings(indicatorID string) []Sighting {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Sighting{}, r.recent[indicatorID]...)
+}
+
+func (r *SightingRecorder) remember(s Sighting) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.rememberLocked(s)
+}
+
+func (r *SightingRecorder) rememberLocked(s Sighting) {
+	list := append(r.recent[s.IndicatorID], s)
+	if len(list) > maxSightingsPerIndicator {
+		list = list[len(list)-maxSightingsPerIndicator:]
+	}
+	r.recent[s.IndicatorID] = list
+}
+
+func appendLine(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	apikey "github.com/swarmguard/libs/go/core/apikey"
+)
+
+// testKeys authenticates tenant acme with "key-a" and globex with "key-b".
+func testKeys(t *testing.T) *apikey.Keys {
+	t.Helper()
+	var spec []string
+	for tenant, key := range map[string]string{"acme": "key-a", "globex": "key-b"} {
+		sum := sha256.Sum256([]byte(key))
+		spec = append(spec, tenant+"="+hex.EncodeToString(sum[:]))
+	}
+	keys, err := apikey.Parse(strings.Join(spec, ";"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestSightingsAggregateOnIndicator(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewIndicatorStore(filepath.Join(dir, "indicators.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ind, err := store.Upsert(Indicator{Type: "ip", Value: "10.0.0.1", Score: 0.5}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "sightings.jsonl")
+	rec, err := NewSightingRecorder(path, store, nil, 0.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := time.Now().Add(time.Minute).UTC().Truncate(time.Second)
+	for i, at := range []time.Time{seen, seen.Add(-time.Hour), {}} {
+		s, err := rec.Record(context.Background(), Sighting{IndicatorID: ind.ID, RuleID: "r1", ObservedAt: at})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.CorrelationID == "" || s.ObservedAt.IsZero() {
+			t.Fatalf("sighting %d: %+v", i, s)
+		}
+	}
+	// The score is bumped per sighting and capped at 1; LastSeen never
+	// moves back for a late report.
+	cur, _ := store.Get(ind.ID)
+	if cur.Score != 1 || !cur.LastSeen.Equal(seen) {
+		t.Fatalf("indicator after sightings: score %v, last seen %v", cur.Score, cur.LastSeen)
+	}
+	if got := rec.Sightings(ind.ID); len(got) != 3 || got[0].ScoreAfter != 0.7 || got[2].ScoreAfter != 1 {
+		t.Fatalf("sightings %+v", got)
+	}
+	if _, err := rec.Record(context.Background(), Sighting{IndicatorID: "missing", RuleID: "r1"}); !errors.Is(err, ErrIndicatorNotFound) {
+		t.Fatalf("unknown indicator: %v", err)
+	}
+
+	// History is rebuilt from the log and bounded per indicator.
+	for i := 0; i < maxSightingsPerIndicator; i++ {
+		if _, err := rec.Record(context.Background(), Sighting{IndicatorID: ind.ID, RuleID: "r2"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	reloaded, err := NewSightingRecorder(path, store, nil, 0.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Sightings(ind.ID); len(got) != maxSightingsPerIndicator || got[0].RuleID != "r2" {
+		t.Fatalf("reloaded %d sightings, first rule %q", len(got), got[0].RuleID)
+	}
+}
+
+func TestSightingsAreTenantScoped(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewIndicatorStore(filepath.Join(dir, "indicators.json"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owned, _ := store.Upsert(Indicator{Type: "ip", Value: "10.0.0.1", Tenant: "acme", Score: 0.5}, time.Hour)
+	shared, _ := store.Upsert(Indicator{Type: "ip", Value: "10.0.0.2", Score: 0.5}, time.Hour)
+	rec, err := NewSightingRecorder(filepath.Join(dir, "sightings.jsonl"), store, nil, 0.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	registerSightingRoutes(mux, store, rec, testKeys(t))
+	call := func(method, id, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/v1/indicators/"+id+"/sightings", strings.NewReader(`{"rule_id":"r1"}`))
+		if key != "" {
+			r.Header.Set("X-API-Key", key)
+		}
+		r.Header.Set("X-Correlation-ID", "corr-1")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, key := range []string{"key-b", ""} {
+		if w := call("POST", owned.ID, key); w.Code != http.StatusForbidden {
+			t.Fatalf("record on acme's indicator with %q: status %d", key, w.Code)
+		}
+		if w := call("GET", owned.ID, key); w.Code != http.StatusForbidden {
+			t.Fatalf("read acme's sightings with %q: status %d", key, w.Code)
+		}
+	}
+	if cur, _ := store.Get(owned.ID); cur.Score != 0.5 || len(rec.Sightings(owned.ID)) != 0 {
+		t.Fatalf("rejected sightings changed the indicator: %+v", cur)
+	}
+
+	w := call("POST", owned.ID, "key-a")
+	var s Sighting
+	if err := json.NewDecoder(w.Body).Decode(&s); w.Code != http.StatusCreated || err != nil || s.CorrelationID != "corr-1" || w.Header().Get("X-Correlation-ID") != "corr-1" {
+		t.Fatalf("owner record: status %d, %+v, %v", w.Code, s, err)
+	}
+	var list []Sighting
+	if w := call("GET", owned.ID, "key-a"); json.NewDecoder(w.Body).Decode(&list) != nil || len(list) != 1 {
+		t.Fatalf("owner read: %v", list)
+	}
+	if w := call("POST", shared.ID, "key-b"); w.Code != http.StatusCreated {
+		t.Fatalf("record on a shared indicator: status %d", w.Code)
+	}
+	if w := call("POST", "missing", "key-a"); w.Code != http.StatusNotFound {
+		t.Fatalf("unknown indicator: status %d", w.Code)
+	}
+}